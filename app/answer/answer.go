@@ -0,0 +1,101 @@
+package answer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// An excerpt from a page that the model can use to answer a question
+type Passage struct {
+	URL   string
+	Title string
+	Text  string
+}
+
+// A passage that the answer refers to. `Number` matches the "[1]"-style markers in the answer's text.
+type Citation struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+}
+
+type Answer struct {
+	Text      string     `json:"text"`
+	Citations []Citation `json:"citations"`
+}
+
+const systemPrompt = `You answer questions about a website using excerpts from its pages.
+Only use information from the numbered sources in the user's message. After each statement, cite the sources that support it with their numbers in square brackets, like [1] or [2][3].
+If the sources don't contain the answer, say that you couldn't find it. Answer in the language of the question.`
+
+// Returns the messages sent to the model: the instructions, then the numbered passages and the question
+func buildMessages(question string, passages []Passage) []llms.MessageContent {
+	var prompt strings.Builder
+	prompt.WriteString("Sources:\n\n")
+	for i, p := range passages {
+		fmt.Fprintf(&prompt, "[%d] %s (%s)\n%s\n\n", i+1, p.Title, p.URL, strings.TrimSpace(p.Text))
+	}
+	prompt.WriteString("Question: " + question)
+
+	return []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, systemPrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, prompt.String()),
+	}
+}
+
+// Asks a chat model from an OpenAI-compatible API to answer the question using the passages.
+// If `stream` isn't nil, it's called with each part of the answer's text as it's generated.
+func Generate(ctx context.Context, openAIBaseURL string, model string, apiKey string, question string, passages []Passage, stream func(text string) error) (*Answer, error) {
+	if apiKey == "" {
+		// `langchaingo` emits an error when the OpenAI API key is empty, even if the API URL has been changed to one that doesn't require authentication.
+		apiKey = "-"
+	}
+	llm, err := openai.New(openai.WithBaseURL(openAIBaseURL), openai.WithModel(model), openai.WithToken(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("error setting up LLM for answering: %v", err)
+	}
+
+	options := []llms.CallOption{}
+	if stream != nil {
+		options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			return stream(string(chunk))
+		}))
+	}
+
+	response, err := llm.GenerateContent(ctx, buildMessages(question, passages), options...)
+	if err != nil {
+		return nil, fmt.Errorf("error generating answer: %v", err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("error generating answer: the model didn't return any choices")
+	}
+
+	text := response.Choices[0].Content
+	return &Answer{Text: text, Citations: citations(text, passages)}, nil
+}
+
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// Finds the passages that the text refers to, in the order that they're first cited. References to passages that don't exist are ignored.
+func citations(text string, passages []Passage) []Citation {
+	cited := []Citation{}
+	seen := map[int]struct{}{}
+	for _, match := range citationPattern.FindAllStringSubmatch(text, -1) {
+		number, err := strconv.Atoi(match[1])
+		if err != nil || number < 1 || number > len(passages) {
+			continue
+		}
+		if _, ok := seen[number]; ok {
+			continue
+		}
+		seen[number] = struct{}{}
+		cited = append(cited, Citation{Number: number, URL: passages[number-1].URL, Title: passages[number-1].Title})
+	}
+	return cited
+}
@@ -0,0 +1,98 @@
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Starts a server that imitates an OpenAI-compatible chat completions API, replying with `reply` in one or more parts
+func stubLLM(t *testing.T, reply ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/chat/completions" {
+			t.Errorf("unexpected request to %v", req.URL.Path)
+			w.WriteHeader(404)
+			return
+		}
+		var body struct {
+			Model    string `json:"model"`
+			Stream   bool   `json:"stream"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content any    `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if body.Model != "test-model" || len(body.Messages) != 2 || !strings.Contains(fmt.Sprint(body.Messages[1].Content), "[1] Pricing (https://example.com/pricing)") {
+			t.Errorf("unexpected request: %+v", body)
+		}
+
+		if body.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, part := range reply {
+				data, _ := json.Marshal(map[string]any{"choices": []map[string]any{{"index": 0, "delta": map[string]string{"content": part}}}})
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"index": 0, "message": map[string]string{"role": "assistant", "content": strings.Join(reply, "")}, "finish_reason": "stop"}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+var passages = []Passage{
+	{URL: "https://example.com/pricing", Title: "Pricing", Text: "The basic plan costs $5 per month."},
+	{URL: "https://example.com/faq", Title: "FAQ", Text: "You can cancel at any time."},
+}
+
+func TestGenerate(t *testing.T) {
+	server := stubLLM(t, "The basic plan costs $5 per month [1]. You can cancel at any time [2][1].")
+
+	answer, err := Generate(context.Background(), server.URL, "test-model", "", "How much does it cost?", passages, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Citation{{Number: 1, URL: "https://example.com/pricing", Title: "Pricing"}, {Number: 2, URL: "https://example.com/faq", Title: "FAQ"}}
+	if answer.Text != "The basic plan costs $5 per month [1]. You can cancel at any time [2][1]." || !reflect.DeepEqual(answer.Citations, want) {
+		t.Fatalf("unexpected answer: %+v", answer)
+	}
+}
+
+func TestGenerateStream(t *testing.T) {
+	server := stubLLM(t, "It costs ", "$5 per month ", "[1].")
+
+	parts := []string{}
+	answer, err := Generate(context.Background(), server.URL, "test-model", "", "How much does it cost?", passages, func(text string) error {
+		parts = append(parts, text)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parts, []string{"It costs ", "$5 per month ", "[1]."}) {
+		t.Fatalf("unexpected streamed parts: %q", parts)
+	}
+	if answer.Text != "It costs $5 per month [1]." || len(answer.Citations) != 1 {
+		t.Fatalf("unexpected answer: %+v", answer)
+	}
+}
+
+func TestCitations(t *testing.T) {
+	// References to passages that don't exist are ignored
+	got := citations("See [3], [0], [2], and [2].", passages)
+	if !reflect.DeepEqual(got, []Citation{{Number: 2, URL: "https://example.com/faq", Title: "FAQ"}}) {
+		t.Fatalf("unexpected citations: %+v", got)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,9 +18,28 @@ type Config struct {
 	DB struct {
 		Driver           string
 		ConnectionString string `yaml:"connectionString"`
+		// How text is split into terms in the full-text search index. If this changes, the index is rebuilt when Easysearch starts.
+		Tokenizer struct {
+			// `unicode61` (the default), `porter`, or `trigram`
+			Name string
+			// Whether diacritics are ignored, so that "cafe" matches "café". Defaults to true.
+			RemoveDiacritics *bool `yaml:"removeDiacritics"`
+		}
 	} `yaml:"db"`
 	Sources     []Source
 	ResultsPage ResultsPageConfig `yaml:"resultsPage"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Search      SearchConfig      `yaml:"search"`
+}
+
+type SearchConfig struct {
+	// The largest `pageSize` that search requests can ask for. Defaults to 100.
+	MaxPageSize int `yaml:"maxPageSize"`
+}
+
+type AdminConfig struct {
+	// The API key required to use the admin API, which manages curations. The admin API is disabled if this is empty.
+	APIKey string `yaml:"apiKey"`
 }
 
 type ResultsPageConfig struct {
@@ -36,6 +57,10 @@ type Source struct {
 	URL string `yaml:"url"`
 	// The maximum amount of requests per minute that can be made to this source.
 	Speed int32
+	// The number of pages that are crawled at the same time. Defaults to 4.
+	Concurrency int32 `yaml:"concurrency"`
+	// The number of pages on the same host that are crawled at the same time. Defaults to 2.
+	HostConcurrency int32 `yaml:"hostConcurrency"`
 	// The maximum amount of text content to index per page, in bytes
 	SizeLimit int `yaml:"sizeLimit"`
 
@@ -50,6 +75,13 @@ type Source struct {
 		// The amount of time in between refreshes per URL, in days.
 		MinAge int32 `yaml:"minAge"`
 	}
+	// How pages that fail to load are crawled again
+	Retry struct {
+		// The number of times a page is crawled before a temporary failure (like a server error or a timeout) is recorded as an error. Defaults to 5.
+		MaxAttempts int32 `yaml:"maxAttempts"`
+		// The number of times in a row that a page can fail permanently (like with a 404 status or a noindex tag) before it's removed from the index. Defaults to 3.
+		RemoveAfter int32 `yaml:"removeAfter"`
+	}
 
 	Embeddings struct {
 		Enabled bool
@@ -63,6 +95,74 @@ type Source struct {
 		ChunkSize     int `yaml:"chunkSize"`
 		ChunkOverlap  int `yaml:"chunkOverlap"`
 	}
+
+	// Answering questions about the source's pages with a chat model from an OpenAI-compatible API
+	Answers struct {
+		Enabled       bool
+		OpenAIBaseURL string `yaml:"openaiBaseUrl"`
+		APIKey        string `yaml:"apiKey"`
+		Model         string
+		// The maximum number of page excerpts sent to the model with each question. Defaults to 8.
+		MaxChunks int `yaml:"maxChunks"`
+	}
+
+	// Reordering the top search results with a more accurate model. See the README for more info.
+	Reranking struct {
+		Enabled bool
+		// `http` to call an external `/rerank` API, or `local` to use a simple scorer that doesn't need a model
+		Type string
+		// The full URL of the `/rerank` endpoint, for the `http` type
+		URL    string `yaml:"url"`
+		APIKey string `yaml:"apiKey"`
+		Model  string
+		// `cohere` (the default, which Jina and many other APIs also use) or `tei` for Hugging Face Text Embeddings Inference
+		Format string
+		// The number of top results that are reranked. Defaults to 20.
+		TopN int `yaml:"topN"`
+	}
+
+	// Settings that affect the order of search results. Fields that aren't specified use the defaults.
+	Ranking struct {
+		// The weights of matches in each field when calculating bm25 scores. Defaults to 1 for `url` and `content`, 3 for `title`, and 0.8 for `description`.
+		FieldWeights struct {
+			URL         *float64 `yaml:"url"`
+			Title       *float64
+			Description *float64
+			Content     *float64
+		} `yaml:"fieldWeights"`
+		// How much a page's authority (a PageRank score computed from the links between pages in this source) affects its rank.
+		// 0 disables the authority boost. At 1, the most linked-to page's full-text search score is doubled.
+		AuthorityWeight float64 `yaml:"authorityWeight"`
+		// How much pages closer to the base URL are preferred. A page's score is multiplied by `1 + depthBoost / (1 + depth)`.
+		DepthBoost float64 `yaml:"depthBoost"`
+		// How much pages in the user's preferred languages (from the `Accept-Language` header) are boosted. Defaults to 1, which doubles the scores of pages in the most preferred language.
+		LanguageBoost *float64 `yaml:"languageBoost"`
+		// Multiply the scores of pages whose URLs contain a string. Use multipliers above 1 to boost pages and between 0 and 1 to bury them.
+		URLRules []struct {
+			Contains   string
+			Multiplier float64
+		} `yaml:"urlRules"`
+		// The constant `k` used to combine full-text and vector search results with reciprocal rank fusion. Defaults to 60.
+		RRFConstant *float64 `yaml:"rrfConstant"`
+		// How much vector search results count in hybrid search, compared to full-text search results. Defaults to 0.5.
+		VectorWeight *float64 `yaml:"vectorWeight"`
+	}
+
+	// Words and phrases that should match each other in search queries
+	Synonyms struct {
+		// Groups of words or phrases that all match each other, like `[login, log in, sign in]`
+		Equivalent [][]string
+		// Words or phrases that also match other words or phrases, but not the other way around
+		OneWay []OneWaySynonym `yaml:"oneWay"`
+		// The path to a file with more synonyms, with one rule per line. `a, b, c` is an equivalent group, and `a, b => c, d` is a one-way rule.
+		File string
+	}
+}
+
+// A one-way synonym rule. Queries containing `From` also match each of `To`.
+type OneWaySynonym struct {
+	From string
+	To   []string
 }
 
 var sourceIDPattern = regexp.MustCompile("^[a-zA-Z0-9_]+$")
@@ -83,11 +183,120 @@ func Read() (*Config, error) {
 
 	// Validate the loaded configuration
 
-	for _, src := range config.Sources {
+	if config.Search.MaxPageSize < 0 {
+		return nil, fmt.Errorf("invalid search config: `maxPageSize` can't be negative")
+	}
+
+	for i, src := range config.Sources {
 		if !sourceIDPattern.MatchString(src.ID) {
 			panic(fmt.Sprintf("Invalid source ID: %v. Source IDs may only contain alphanumeric characters and underscores.", src.ID))
 		}
+		for _, rule := range src.Ranking.URLRules {
+			if rule.Contains == "" || rule.Multiplier <= 0 {
+				return nil, fmt.Errorf("invalid URL rule in source %v: rules must have a `contains` string and a positive `multiplier`", src.ID)
+			}
+		}
+		if src.Ranking.RRFConstant != nil && *src.Ranking.RRFConstant <= 0 {
+			return nil, fmt.Errorf("invalid ranking in source %v: `rrfConstant` must be positive", src.ID)
+		}
+
+		if src.Answers.Enabled && (src.Answers.OpenAIBaseURL == "" || src.Answers.Model == "") {
+			return nil, fmt.Errorf("invalid answers config in source %v: `openaiBaseUrl` and `model` are required", src.ID)
+		}
+		if src.Speed <= 0 {
+			return nil, fmt.Errorf("invalid source %v: `speed` must be positive", src.ID)
+		}
+		if src.Concurrency < 0 || src.HostConcurrency < 0 {
+			return nil, fmt.Errorf("invalid source %v: `concurrency` and `hostConcurrency` can't be negative", src.ID)
+		}
+		if src.Retry.MaxAttempts < 0 || src.Retry.RemoveAfter < 0 {
+			return nil, fmt.Errorf("invalid retry config in source %v: `maxAttempts` and `removeAfter` can't be negative", src.ID)
+		}
+		if src.Answers.MaxChunks < 0 {
+			return nil, fmt.Errorf("invalid answers config in source %v: `maxChunks` can't be negative", src.ID)
+		}
+
+		if src.Reranking.Enabled {
+			switch src.Reranking.Type {
+			case "local":
+			case "http":
+				if src.Reranking.URL == "" {
+					return nil, fmt.Errorf("invalid reranking config in source %v: `url` is required for the `http` type", src.ID)
+				}
+				if src.Reranking.Format != "" && src.Reranking.Format != "cohere" && src.Reranking.Format != "tei" {
+					return nil, fmt.Errorf("invalid reranking config in source %v: `format` must be `cohere` or `tei`", src.ID)
+				}
+			default:
+				return nil, fmt.Errorf("invalid reranking config in source %v: `type` must be `http` or `local`", src.ID)
+			}
+			if src.Reranking.TopN < 0 {
+				return nil, fmt.Errorf("invalid reranking config in source %v: `topN` can't be negative", src.ID)
+			}
+		}
+
+		if src.Synonyms.File != "" {
+			equivalent, oneWay, err := readSynonymsFile(src.Synonyms.File)
+			if err != nil {
+				return nil, fmt.Errorf("error reading synonyms file for source %v: %v", src.ID, err)
+			}
+			config.Sources[i].Synonyms.Equivalent = append(src.Synonyms.Equivalent, equivalent...)
+			config.Sources[i].Synonyms.OneWay = append(src.Synonyms.OneWay, oneWay...)
+		}
+		for _, group := range config.Sources[i].Synonyms.Equivalent {
+			if len(group) < 2 || slices.Contains(group, "") {
+				return nil, fmt.Errorf("invalid synonyms in source %v: equivalent groups must have at least two non-empty entries", src.ID)
+			}
+		}
+		for _, rule := range config.Sources[i].Synonyms.OneWay {
+			if rule.From == "" || len(rule.To) == 0 || slices.Contains(rule.To, "") {
+				return nil, fmt.Errorf("invalid synonyms in source %v: one-way rules must have a `from` and at least one `to`", src.ID)
+			}
+		}
 	}
 
 	return config, nil
 }
+
+// Reads a synonyms file, where each line is either a group of equivalent words or phrases separated by commas (`a, b, c`),
+// or a one-way rule (`a, b => c, d`). Empty lines and lines starting with `#` are ignored.
+func readSynonymsFile(path string) ([][]string, []OneWaySynonym, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	equivalent := [][]string{}
+	oneWay := []OneWaySynonym{}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if from, to, ok := strings.Cut(line, "=>"); ok {
+			words, alternatives := splitSynonyms(from), splitSynonyms(to)
+			if len(words) == 0 || len(alternatives) == 0 {
+				return nil, nil, fmt.Errorf("line %v: one-way rules must have words on both sides of `=>`", i+1)
+			}
+			for _, word := range words {
+				oneWay = append(oneWay, OneWaySynonym{From: word, To: alternatives})
+			}
+		} else {
+			equivalent = append(equivalent, splitSynonyms(line))
+		}
+	}
+
+	return equivalent, oneWay, nil
+}
+
+// Splits a comma-separated list of synonyms, ignoring empty entries
+func splitSynonyms(list string) []string {
+	words := []string{}
+	for _, word := range strings.Split(list, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
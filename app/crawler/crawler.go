@@ -2,11 +2,16 @@ package crawler
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
 	"net/url"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/fluxcapacitor2/easysearch/app/config"
@@ -20,6 +25,8 @@ import (
 	"golang.org/x/net/html"
 )
 
+const userAgent = "Easysearch (+https://github.com/FluxCapacitor2/easysearch)"
+
 type CrawlResult struct {
 	// The URLs discovered while visiting the page which should be added to the crawl queue.
 	URLs []string
@@ -27,8 +34,12 @@ type CrawlResult struct {
 	Canonical string
 	// The content that was extracted from the page
 	Content ExtractedPageContent
-	// The ID of the page that was created or updated in the database
+	// The ID of the page that was created or updated in the database. Pages that loaded but can't be indexed (with an Error status) aren't saved,
+	// so this is 0 for them; the caller records the failure without replacing an indexed copy's content.
 	PageID int64
+	// Whether the page hasn't changed since it was last crawled, because the server responded with 304 Not Modified or sent the same content.
+	// Only the page's crawl time was updated, so its content, links, and embeddings don't need to be processed again.
+	Unchanged bool
 }
 
 type ExtractedPageContent struct {
@@ -38,6 +49,17 @@ type ExtractedPageContent struct {
 	Description string
 	Content     string
 	ErrorInfo   string
+	// One of the database.Kind constants
+	Kind         string
+	PublishedAt  *time.Time
+	ModifiedAt   *time.Time
+	LastModified *time.Time
+	// The page's language code, or an empty string if it couldn't be determined
+	Language string
+	// The canonical URLs of versions of the page in other languages
+	Alternates []string
+	// The response's ETag and Last-Modified headers and a hash of its body
+	Validators database.CacheValidators
 }
 
 func Crawl(ctx context.Context, source config.Source, currentDepth int32, referrers []int64, db database.Database, pageURL string) (*CrawlResult, error) {
@@ -58,30 +80,108 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 
 	slogctx.Info(ctx, "Crawling URL", "canonical", page.Canonical, "original", pageURL)
 
+	// If the page was crawled before, the server can skip sending it again if it hasn't changed
+	requestURL := page.Canonical
+	previous, err := db.GetCacheValidators(ctx, source.ID, requestURL)
+	if err != nil {
+		return nil, err
+	}
+
 	collector := colly.NewCollector()
-	collector.UserAgent = "Easysearch (+https://github.com/FluxCapacitor2/easysearch)"
+	collector.UserAgent = userAgent
 	collector.IgnoreRobotsTxt = false
 	collector.AllowedDomains = source.AllowedDomains
 
 	urls := map[string]struct{}{}
 
-	add := func(urlStr string) error {
+	// Adds a URL to the list of discovered URLs and returns its canonical form, or an empty string if it couldn't be canonicalized
+	addURL := func(urlStr string) (string, error) {
 		parsed, err := url.Parse(urlStr)
 		if err != nil {
-			return err
+			return "", err
 		}
 		url, err := Canonicalize(ctx, source.ID, db, parsed)
-		if err == nil {
-			urls[url.String()] = struct{}{}
+		if err != nil {
+			return "", nil
+		}
+		urls[url.String()] = struct{}{}
+		return url.String(), nil
+	}
+
+	add := func(urlStr string) error {
+		_, err := addURL(urlStr)
+		return err
+	}
+
+	// Adds the URLs of a feed's items to the list of discovered URLs and records them as feed items
+	addFeedItems := func(feed *gofeed.Feed, resp *colly.Response) {
+		items := []database.FeedItem{}
+		for _, item := range feed.Items {
+			for _, link := range item.Links {
+				if canonical, _ := addURL(resp.Request.AbsoluteURL(link)); canonical != "" {
+					items = append(items, database.FeedItem{
+						URL:         canonical,
+						PublishedAt: validDate(item.PublishedParsed),
+						ModifiedAt:  validDate(item.UpdatedParsed),
+					})
+				}
+			}
+		}
+		if err := db.AddFeedItems(ctx, source.ID, items); err != nil {
+			slogctx.Warn(ctx, "Failed to record feed items", "error", err)
 		}
-		return nil
 	}
 
 	cancelled := false
+	unchanged := false
+
+	// The request waits for its turn to crawl the host. If it would wait too long, the crawl is cancelled.
+	var waitErr error
+	var requested *url.URL
+	var requestStart time.Time
+	collector.OnRequest(func(req *colly.Request) {
+		if waitErr = Hosts.Wait(ctx, req.URL, maxHostWait); waitErr != nil {
+			req.Abort()
+			return
+		}
+		requested, requestStart = req.URL, time.Now()
+	})
+
+	collector.OnRequest(func(req *colly.Request) {
+		if previous == nil {
+			return
+		}
+		if previous.ETag != "" {
+			req.Headers.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Headers.Set("If-Modified-Since", previous.LastModified)
+		}
+	})
+
+	var statusErr *StatusError
+	collector.OnError(func(resp *colly.Response, err error) {
+		if !requestStart.IsZero() && (resp.StatusCode == 0 || resp.StatusCode >= 203) {
+			// Errors from the server (or no response at all) can mean that it's overloaded
+			retryAfter := time.Duration(0)
+			if resp.StatusCode != 0 {
+				retryAfter = parseRetryAfter(resp.Headers.Get("Retry-After"))
+			}
+			Hosts.Observe(requested, resp.StatusCode, time.Since(requestStart), retryAfter)
+		}
+
+		if resp.StatusCode == http.StatusNotModified {
+			unchanged = true
+			page.Validators = database.CacheValidators{ETag: resp.Headers.Get("ETag"), LastModified: resp.Headers.Get("Last-Modified")}
+		} else if resp.StatusCode >= 203 {
+			// Colly treats these status codes as errors
+			statusErr = &StatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Headers.Get("Retry-After"))}
+		}
+	})
 
 	collector.OnHTML("html", func(element *colly.HTMLElement) {
 
-		if cancelled {
+		if cancelled || unchanged {
 			return
 		}
 
@@ -105,6 +205,16 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 		linkTags := element.DOM.Find("link[rel=alternate]")
 		linkTags.Each(func(i int, link *goquery.Selection) {
 
+			// Links with an hreflang attribute point to translations of this page
+			if _, exists := link.Attr("hreflang"); exists {
+				if href, exists := link.Attr("href"); exists {
+					if canonical, _ := addURL(element.Request.AbsoluteURL(href)); canonical != "" {
+						page.Alternates = append(page.Alternates, canonical)
+					}
+				}
+				return
+			}
+
 			linkType, exists := link.Attr("type")
 
 			if exists && (linkType == "application/atom+xml" || linkType == "application/rss+xml" || linkType == "text/html") {
@@ -122,8 +232,10 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 		}
 
 		page.Status = database.Finished
+		page.Kind = database.KindPage
 		page.Title = strings.TrimSpace(element.DOM.Find("title").Text())
 		page.Description = description
+		page.PublishedAt, page.ModifiedAt = extractDates(element.DOM)
 
 		if err != nil || article.TextContent == "" {
 			// Readability couldn't parse the document. Instead,
@@ -139,9 +251,13 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 			}
 			page.Content = article.TextContent
 		}
+
+		page.Language = extractLanguage(element.DOM, element.Response.Headers.Get("Content-Language"), page.Title+"\n"+page.Content)
 	})
 
 	collector.OnResponse(func(resp *colly.Response) {
+		Hosts.Observe(requested, resp.StatusCode, time.Since(requestStart), 0)
+
 		// The crawler follows redirects, so the canonical should be updated to match the final URL.
 		page.Canonical = resp.Request.URL.String()
 
@@ -154,14 +270,54 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 			}
 		}
 
+		hash := sha256.Sum256(resp.Body)
+		page.Validators = database.CacheValidators{
+			ETag:         resp.Headers.Get("ETag"),
+			LastModified: resp.Headers.Get("Last-Modified"),
+			ContentHash:  hex.EncodeToString(hash[:]),
+		}
+		// Servers that don't support conditional requests still send the same content if the page didn't change
+		if previous != nil && previous.ContentHash == page.Validators.ContentHash && page.Canonical == requestURL {
+			unchanged = true
+			return
+		}
+
+		page.LastModified = parseLastModified(resp.Headers.Get("Last-Modified"))
+
 		ct := resp.Headers.Get("Content-Type")
-		// XML files could be sitemaps
-		if strings.HasPrefix(ct, "application/xml") || strings.HasPrefix(ct, "text/xml") {
+		if extractor := extractorFor(ct, resp.Request.URL.Path); extractor != nil {
+			// Documents like PDFs are indexed like HTML pages, using their metadata in place of meta tags
+			doc, err := extractor.Extract(resp.Body)
+			if err != nil {
+				page.Status = database.Error
+				page.ErrorInfo = fmt.Sprintf("Failed to extract text from document: %v", err)
+				return
+			}
+			page.Status = database.Finished
+			page.Kind = database.KindDocument
+			page.Title = cmp.Or(doc.Title, fileName(resp.Request.URL.Path))
+			page.Description = doc.Description
+			page.Content = doc.Content
+			page.PublishedAt, page.ModifiedAt = validDate(doc.PublishedAt), validDate(doc.ModifiedAt)
+			page.Language = documentLanguage(doc.Language, resp.Headers.Get("Content-Language"), page.Title+"\n"+page.Content)
+		} else if strings.HasPrefix(ct, "application/xml") || strings.HasPrefix(ct, "text/xml") {
 			// Attempt to parse this response as a sitemap or sitemap index
 			reader := bytes.NewReader(resp.Body)
+			entries := []database.SitemapEntry{}
 			sitemap.Parse(reader, func(entry sitemap.Entry) error {
-				return add(resp.Request.AbsoluteURL(entry.GetLocation()))
+				canonical, err := addURL(resp.Request.AbsoluteURL(entry.GetLocation()))
+				if canonical != "" {
+					if lastModified := validDate(entry.GetLastModified()); lastModified != nil {
+						entries = append(entries, database.SitemapEntry{URL: canonical, LastModified: lastModified})
+					}
+				}
+				return err
 			})
+			if len(entries) > 0 {
+				if err := db.AddSitemapEntries(ctx, source.ID, entries); err != nil {
+					slogctx.Warn(ctx, "Failed to record sitemap entries", "error", err)
+				}
+			}
 			reader.Reset(resp.Body)
 			sitemap.ParseIndex(reader, func(entry sitemap.IndexEntry) error {
 				return add(resp.Request.AbsoluteURL(entry.GetLocation()))
@@ -173,11 +329,8 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 			if err != nil {
 				page.Status = database.Error
 				page.ErrorInfo = "Invalid feed content"
-			}
-			for _, item := range res.Items {
-				for _, link := range item.Links {
-					add(resp.Request.AbsoluteURL(link))
-				}
+			} else {
+				addFeedItems(res, resp)
 			}
 		}
 	})
@@ -192,8 +345,14 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 	})
 
 	err = collector.Visit(page.Canonical)
+	if waitErr != nil {
+		return nil, waitErr
+	}
 
-	if err != nil {
+	if err != nil && !unchanged {
+		if statusErr != nil {
+			err = statusErr
+		}
 		page.Status = database.Error
 		page.ErrorInfo = err.Error()
 	}
@@ -213,9 +372,28 @@ func Crawl(ctx context.Context, source config.Source, currentDepth int32, referr
 		}
 	}
 
-	if !cancelled {
+	if unchanged {
+		slogctx.Info(ctx, "Page hasn't changed since it was last crawled", "canonical", page.Canonical)
+		result.Unchanged = true
+		result.PageID, err = db.MarkUnchanged(ctx, source.ID, page.Canonical, page.Validators)
+	} else if !cancelled && err == nil && page.Status != database.Error {
+		// Pages that failed to load or can't be indexed aren't saved here, so that the caller can decide whether to try again (see IsRetryable)
+		// and an indexed copy of the page keeps its content until it fails too many times in a row.
+		// Validators are only stored for indexed pages, so that pages which failed or couldn't be indexed are always processed again
+		validators := database.CacheValidators{}
+		if page.Status == database.Finished {
+			validators = page.Validators
+		}
 		text := Truncate(source.SizeLimit, page.Title, page.Description, page.Content)
-		id, addDocErr := db.AddDocument(ctx, source.ID, currentDepth, referrers, page.Canonical, page.Status, text[0], text[1], text[2], page.ErrorInfo)
+		id, addDocErr := db.AddDocument(ctx, source.ID, currentDepth, referrers, page.Canonical, page.Status, text[0], text[1], text[2], page.ErrorInfo, database.PageMetadata{
+			Kind:         page.Kind,
+			PublishedAt:  page.PublishedAt,
+			ModifiedAt:   page.ModifiedAt,
+			LastModified: page.LastModified,
+			Language:     page.Language,
+			Alternates:   page.Alternates,
+			Validators:   validators,
+		})
 		result.PageID = id
 		if addDocErr != nil {
 			err = addDocErr
@@ -2,9 +2,13 @@ package crawler
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"path"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/fluxcapacitor2/easysearch/app/config"
 	"github.com/fluxcapacitor2/easysearch/app/database"
@@ -116,6 +120,91 @@ func TestCrawlWithPageNotFound(t *testing.T) {
 	}
 }
 
+func TestCrawlUnchanged(t *testing.T) {
+	const body = "<html><head><title>Unchanged page</title></head><body><p>This page never changes.</p></body></html>"
+	tests := []struct {
+		name string
+		// Whether the server supports conditional requests with ETags
+		etag bool
+	}{
+		{"not modified", true},
+		{"same content", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/page" {
+					http.NotFound(w, r)
+					return
+				}
+				requests++
+				if test.etag {
+					w.Header().Set("ETag", `"v1"`)
+					if r.Header.Get("If-None-Match") == `"v1"` {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte(body))
+			}))
+			defer server.Close()
+
+			parsed, _ := url.Parse(server.URL)
+			db := createDB(t)
+			source := config.Source{ID: "example", AllowedDomains: []string{parsed.Host}, SizeLimit: 1000}
+			pageURL := server.URL + "/page"
+
+			first, err := Crawl(context.Background(), source, 1, []int64{}, db, pageURL)
+			if err != nil || first.Unchanged {
+				t.Fatalf("unexpected result of first crawl: %+v, %v", first, err)
+			}
+
+			second, err := Crawl(context.Background(), source, 1, []int64{}, db, pageURL)
+			if err != nil {
+				t.Fatalf("error crawling URL again: %v", err)
+			}
+			if !second.Unchanged || second.PageID != first.PageID || requests != 2 {
+				t.Fatalf("expected the page to be unchanged: %+v after %v requests", second, requests)
+			}
+
+			page, err := db.GetDocument(context.Background(), source.ID, pageURL)
+			if err != nil || page.Title != "Unchanged page" || page.Status != database.Finished {
+				t.Fatalf("unexpected page after recrawling: %+v, %v", page, err)
+			}
+		})
+	}
+}
+
+// Pages that fail to load aren't saved by Crawl, so that the queue can retry them
+func TestCrawlWithRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	parsed, _ := url.Parse(server.URL)
+	db := createDB(t)
+	source := config.Source{ID: "example", AllowedDomains: []string{parsed.Host}}
+
+	_, err := Crawl(context.Background(), source, 1, []int64{}, db, server.URL+"/page")
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != http.StatusServiceUnavailable || statusErr.RetryAfter != 2*time.Minute || !IsRetryable(err) {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	exists, err := db.HasDocument(context.Background(), source.ID, server.URL+"/page")
+	if err != nil {
+		t.Fatalf("error checking whether the page was saved: %v", err)
+	}
+	if *exists {
+		t.Fatalf("expected the page not to be saved")
+	}
+}
+
 func TestSitemap(t *testing.T) {
 	db := createDB(t)
 	source := config.Source{
@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The formats that dates in HTML attributes and JSON-LD are commonly written in, from most to least specific
+var dateFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Parses a date written in one of the common formats used on web pages. Dates without a time zone are assumed to be in UTC.
+// Returns nil if the date can't be parsed or is unreasonably far in the future.
+func parseDate(str string) *time.Time {
+	str = strings.TrimSpace(str)
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, str); err == nil {
+			return validDate(&t)
+		}
+	}
+	return nil
+}
+
+// Returns nil for dates that are clearly wrong, like dates in the future or the zero time
+func validDate(t *time.Time) *time.Time {
+	if t == nil || t.IsZero() || t.After(time.Now().Add(24*time.Hour)) {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// Finds the dates that a page was published and last modified using its meta tags, JSON-LD, and <time> elements.
+// Either date can be nil if it isn't specified.
+func extractDates(doc *goquery.Selection) (published *time.Time, modified *time.Time) {
+	if content, ok := doc.Find(`meta[property="article:published_time"]`).Attr("content"); ok {
+		published = parseDate(content)
+	}
+	if content, ok := doc.Find(`meta[property="article:modified_time"]`).Attr("content"); ok {
+		modified = parseDate(content)
+	}
+
+	if published == nil || modified == nil {
+		doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(i int, script *goquery.Selection) bool {
+			var data any
+			if err := json.Unmarshal([]byte(script.Text()), &data); err != nil {
+				return true
+			}
+			if published == nil {
+				published = parseDate(findJSONString(data, "datePublished"))
+			}
+			if modified == nil {
+				modified = parseDate(findJSONString(data, "dateModified"))
+			}
+			return published == nil || modified == nil
+		})
+	}
+
+	if published == nil {
+		// Pages often have many <time> elements (for example, in comments or lists of related posts), so prefer the ones that are inside the main article
+		times := doc.Find("article time[datetime]")
+		if times.Length() == 0 {
+			times = doc.Find("time[datetime]")
+		}
+		if datetime, ok := times.First().Attr("datetime"); ok {
+			published = parseDate(datetime)
+		}
+	}
+
+	return published, modified
+}
+
+// Searches JSON-LD data (which can contain nested objects, arrays, and `@graph`s) for the first string with the given key
+func findJSONString(data any, key string) string {
+	switch v := data.(type) {
+	case map[string]any:
+		if str, ok := v[key].(string); ok {
+			return str
+		}
+		// Sort the keys so that the result doesn't depend on map iteration order
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			if str := findJSONString(v[k], key); str != "" {
+				return str
+			}
+		}
+	case []any:
+		for _, child := range v {
+			if str := findJSONString(child, key); str != "" {
+				return str
+			}
+		}
+	}
+	return ""
+}
+
+// Parses the value of an HTTP Last-Modified header. Returns nil if the header is missing or invalid.
+func parseLastModified(header string) *time.Time {
+	if header == "" {
+		return nil
+	}
+	t, err := http.ParseTime(header)
+	if err != nil {
+		return nil
+	}
+	return validDate(&t)
+}
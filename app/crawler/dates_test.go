@@ -0,0 +1,72 @@
+package crawler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestExtractDates(t *testing.T) {
+	tests := []struct {
+		html      string
+		published string
+		modified  string
+	}{
+		{
+			html:      `<meta property="article:published_time" content="2024-01-02T03:04:05+02:00"><meta property="article:modified_time" content="2024-02-03">`,
+			published: "2024-01-02T01:04:05Z",
+			modified:  "2024-02-03T00:00:00Z",
+		},
+		{
+			// Meta tags take precedence over JSON-LD
+			html: `<meta property="article:published_time" content="2024-01-01">
+				<script type="application/ld+json">{"@context": "https://schema.org", "@graph": [{"@type": "WebPage"}, {"@type": "Article", "datePublished": "2023-01-01", "dateModified": "2023-05-06T07:08:09Z"}]}</script>`,
+			published: "2024-01-01T00:00:00Z",
+			modified:  "2023-05-06T07:08:09Z",
+		},
+		{
+			// <time> elements inside an article are preferred
+			html:      `<time datetime="2020-01-01">Header</time><article><h1>Post</h1><time datetime="2022-03-04T05:06">March 4</time></article>`,
+			published: "2022-03-04T05:06:00Z",
+		},
+		{
+			// Invalid and future dates are ignored
+			html: `<meta property="article:published_time" content="yesterday"><meta property="article:modified_time" content="2999-01-01">`,
+		},
+		{
+			html: `<p>No dates here</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(test.html))
+		if err != nil {
+			t.Fatalf("failed to parse HTML: %v", err)
+		}
+		published, modified := extractDates(doc.Selection)
+		if got := formatTestDate(published); got != test.published {
+			t.Errorf("incorrect published date for %q - expected %q, got %q", test.html, test.published, got)
+		}
+		if got := formatTestDate(modified); got != test.modified {
+			t.Errorf("incorrect modified date for %q - expected %q, got %q", test.html, test.modified, got)
+		}
+	}
+}
+
+func TestParseLastModified(t *testing.T) {
+	if got := formatTestDate(parseLastModified("Wed, 21 Oct 2015 07:28:00 GMT")); got != "2015-10-21T07:28:00Z" {
+		t.Fatalf("incorrect Last-Modified date - expected %q, got %q", "2015-10-21T07:28:00Z", got)
+	}
+	if got := parseLastModified("not a date"); got != nil {
+		t.Fatalf("expected invalid Last-Modified header to be ignored, got %v", got)
+	}
+}
+
+func formatTestDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
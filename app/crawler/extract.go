@@ -0,0 +1,337 @@
+package crawler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"gitlab.com/golang-commonmark/markdown"
+	"golang.org/x/net/html"
+)
+
+// Extracts the text of a document that isn't HTML, like a PDF or a Markdown file
+type Extractor interface {
+	Extract(body []byte) (*ExtractedDocument, error)
+}
+
+type ExtractedDocument struct {
+	// The title from the document's metadata, or an empty string if it doesn't have one
+	Title       string
+	Description string
+	Content     string
+	PublishedAt *time.Time
+	ModifiedAt  *time.Time
+	// The language from the document's metadata, or an empty string if it doesn't specify one
+	Language string
+}
+
+// The extractors for each media type, which are used instead of the HTML parser
+var extractors = map[string]Extractor{
+	"application/pdf": PDFExtractor{},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": DOCXExtractor{},
+	"application/vnd.oasis.opendocument.text":                                 ODTExtractor{},
+	"text/plain":      TextExtractor{},
+	"text/markdown":   MarkdownExtractor{},
+	"text/x-markdown": MarkdownExtractor{},
+}
+
+// Servers often send documents as `application/octet-stream` (or with no type), so their media types are guessed from these file extensions
+var extractorExtensions = map[string]string{
+	".pdf":      "application/pdf",
+	".docx":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".odt":      "application/vnd.oasis.opendocument.text",
+	".txt":      "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+}
+
+// Adds or replaces the extractor for a media type, like "application/pdf". This must be called before crawling starts.
+func RegisterExtractor(mediaType string, extractor Extractor) {
+	extractors[mediaType] = extractor
+}
+
+// Returns the extractor for a response's Content-Type header, or nil if the response should be parsed as HTML (or isn't a document)
+func extractorFor(contentType string, urlPath string) Extractor {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if extractor, ok := extractors[mediaType]; ok {
+		return extractor
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		return extractors[extractorExtensions[strings.ToLower(path.Ext(urlPath))]]
+	}
+	return nil
+}
+
+// Returns the last segment of a URL's path, which is used as the title of documents that don't have one
+func fileName(urlPath string) string {
+	name := path.Base(urlPath)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+type PDFExtractor struct{}
+
+func (PDFExtractor) Extract(body []byte) (doc *ExtractedDocument, err error) {
+	// The PDF library panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			doc, err = nil, fmt.Errorf("invalid PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	text, err := reader.GetPlainText()
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(text)
+	if err != nil {
+		return nil, err
+	}
+
+	info := reader.Trailer().Key("Info")
+	return &ExtractedDocument{
+		Title:       strings.TrimSpace(info.Key("Title").Text()),
+		Description: strings.TrimSpace(info.Key("Subject").Text()),
+		Content:     strings.TrimSpace(string(content)),
+		PublishedAt: parsePDFDate(info.Key("CreationDate").Text()),
+		ModifiedAt:  parsePDFDate(info.Key("ModDate").Text()),
+	}, nil
+}
+
+// Parses a date in a PDF's metadata, like "D:20240131120000+01'00'". Only the year is required.
+func parsePDFDate(str string) *time.Time {
+	str = strings.TrimPrefix(str, "D:")
+	digits := 0
+	for digits < len(str) && digits < 14 && str[digits] >= '0' && str[digits] <= '9' {
+		digits++
+	}
+	for _, layout := range []string{"20060102150405", "200601021504", "2006010215", "20060102", "200601", "2006"} {
+		if len(layout) == digits {
+			t, err := time.Parse(layout, str[:digits])
+			if err != nil {
+				return nil
+			}
+			return validDate(&t)
+		}
+	}
+	return nil
+}
+
+// Extracts Word documents (.docx)
+type DOCXExtractor struct{}
+
+func (DOCXExtractor) Extract(body []byte) (*ExtractedDocument, error) {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := xmlText(archive, "word/document.xml", map[string]string{"p": "\n", "tab": "\t", "br": "\n"})
+	if err != nil {
+		return nil, err
+	}
+	doc := &ExtractedDocument{Content: content}
+	// The metadata is optional
+	readDocumentMetadata(archive, "docProps/core.xml", doc)
+	return doc, nil
+}
+
+// Extracts OpenDocument text documents (.odt)
+type ODTExtractor struct{}
+
+func (ODTExtractor) Extract(body []byte) (*ExtractedDocument, error) {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := xmlText(archive, "content.xml", map[string]string{"p": "\n", "h": "\n", "tab": "\t", "line-break": "\n", "s": " "})
+	if err != nil {
+		return nil, err
+	}
+	doc := &ExtractedDocument{Content: content}
+	readDocumentMetadata(archive, "meta.xml", doc)
+	return doc, nil
+}
+
+// The largest file that's decompressed from a document archive. Compressed files can be much larger than the archive
+// that contains them, so larger files are rejected instead of being read into memory.
+const maxArchiveFileSize = 32 * 1024 * 1024
+
+// Opens a file inside a document archive, returning an error if it's larger than `maxArchiveFileSize`
+func openArchiveFile(archive *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		if f.UncompressedSize64 > maxArchiveFileSize {
+			return nil, fmt.Errorf("%s is too large to extract (%d bytes)", name, f.UncompressedSize64)
+		}
+		file, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		// The size in the archive's header could be wrong, so the amount of data that's read is limited too
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(file, maxArchiveFileSize), file}, nil
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+// Returns the text in an XML file inside a document archive. `separators` maps element names (without namespaces) to the text that's added after them,
+// so that paragraphs are separated.
+func xmlText(archive *zip.Reader, name string, separators map[string]string) (string, error) {
+	file, err := openArchiveFile(archive, name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var text strings.Builder
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			text.WriteString(separators[t.Name.Local])
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// Reads the Dublin Core metadata that both DOCX and ODT files use, ignoring missing or invalid files
+func readDocumentMetadata(archive *zip.Reader, name string, doc *ExtractedDocument) {
+	file, err := openArchiveFile(archive, name)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var field *string
+		var date **time.Time
+		switch start.Name.Local {
+		case "title":
+			field = &doc.Title
+		case "description", "subject":
+			field = &doc.Description
+		case "language":
+			field = &doc.Language
+		case "created", "creation-date":
+			date = &doc.PublishedAt
+		case "modified", "date":
+			date = &doc.ModifiedAt
+		default:
+			// Other elements, like the root element, are skipped without reading their contents
+			continue
+		}
+		var value string
+		if err := decoder.DecodeElement(&value, &start); err != nil {
+			return
+		}
+		if field != nil {
+			*field = strings.TrimSpace(value)
+		} else {
+			*date = parseDate(strings.TrimSpace(value))
+		}
+	}
+}
+
+// Extracts plain text files, which don't have any metadata
+type TextExtractor struct{}
+
+func (TextExtractor) Extract(body []byte) (*ExtractedDocument, error) {
+	if !utf8.Valid(body) {
+		return nil, fmt.Errorf("text is not valid UTF-8")
+	}
+	return &ExtractedDocument{Content: strings.TrimSpace(string(body))}, nil
+}
+
+// Extracts Markdown files. The title comes from the `title` in the file's front matter, or its first top-level heading.
+type MarkdownExtractor struct{}
+
+func (MarkdownExtractor) Extract(body []byte) (*ExtractedDocument, error) {
+	if !utf8.Valid(body) {
+		return nil, fmt.Errorf("text is not valid UTF-8")
+	}
+	source := string(body)
+	doc := &ExtractedDocument{}
+
+	// YAML front matter is between two `---` lines at the start of the file
+	if rest, ok := strings.CutPrefix(source, "---\n"); ok {
+		if frontMatter, after, ok := strings.Cut(rest, "\n---\n"); ok {
+			source = after
+			for _, line := range strings.Split(frontMatter, "\n") {
+				key, value, _ := strings.Cut(line, ":")
+				value = strings.Trim(strings.TrimSpace(value), `"'`)
+				switch strings.TrimSpace(key) {
+				case "title":
+					doc.Title = value
+				case "description":
+					doc.Description = value
+				case "date":
+					doc.PublishedAt = parseDate(value)
+				}
+			}
+		}
+	}
+
+	rendered := markdown.New(markdown.HTML(false)).RenderToString([]byte(source))
+	node, err := html.Parse(strings.NewReader(rendered))
+	if err != nil {
+		return nil, err
+	}
+	if doc.Title == "" {
+		if h1 := findElement(node, "h1"); h1 != nil {
+			doc.Title = getText(h1.FirstChild)
+		}
+	}
+	doc.Content = getText(node)
+	return doc, nil
+}
+
+// Returns the first element with the given tag name, searching depth-first
+func findElement(node *html.Node, tag string) *html.Node {
+	if node.Type == html.ElementNode && node.Data == tag {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Creates a ZIP archive with the given files, like a DOCX or ODT document
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("error creating %v: %v", name, err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error creating archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractorFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		path        string
+		want        Extractor
+	}{
+		{"application/pdf", "/report", PDFExtractor{}},
+		{"text/plain; charset=utf-8", "/notes", TextExtractor{}},
+		{"application/octet-stream", "/docs/README.md", MarkdownExtractor{}},
+		{"", "/letter.DOCX", DOCXExtractor{}},
+		{"text/html; charset=utf-8", "/page.md", nil},
+		{"application/octet-stream", "/archive.zip", nil},
+	}
+
+	for _, test := range tests {
+		if got := extractorFor(test.contentType, test.path); got != test.want {
+			t.Errorf("unexpected extractor for %q at %q: %T", test.contentType, test.path, got)
+		}
+	}
+}
+
+func TestDOCXExtractor(t *testing.T) {
+	body := zipFiles(t, map[string]string{
+		"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+			<w:p><w:r><w:t>First paragraph.</w:t></w:r></w:p><w:p><w:r><w:t>Second </w:t></w:r><w:r><w:t>paragraph.</w:t></w:r></w:p>
+		</w:body></w:document>`,
+		"docProps/core.xml": `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/">
+			<dc:title>Quarterly report</dc:title><dc:language>en-US</dc:language><dcterms:created>2024-01-02T03:04:05Z</dcterms:created>
+		</cp:coreProperties>`,
+	})
+
+	doc, err := DOCXExtractor{}.Extract(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if doc.Title != "Quarterly report" || doc.Language != "en-US" || doc.PublishedAt == nil || doc.PublishedAt.Year() != 2024 {
+		t.Errorf("unexpected metadata: %+v", doc)
+	}
+	if doc.Content != "First paragraph.\n\t\t\tSecond paragraph." && doc.Content != "First paragraph.\nSecond paragraph." {
+		t.Errorf("unexpected content: %q", doc.Content)
+	}
+}
+
+func TestODTExtractor(t *testing.T) {
+	body := zipFiles(t, map[string]string{
+		"content.xml": `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"><office:body><office:text><text:h>Heading</text:h><text:p>Some<text:s/>text.</text:p></office:text></office:body></office:document-content>`,
+		"meta.xml":    `<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><office:meta><dc:title>Meeting notes</dc:title></office:meta></office:document-meta>`,
+	})
+
+	doc, err := ODTExtractor{}.Extract(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if doc.Title != "Meeting notes" || doc.Content != "Heading\nSome text." {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+}
+
+func TestDOCXExtractorSizeLimit(t *testing.T) {
+	// Repeated text compresses well, so a small archive can contain a much larger file
+	body := zipFiles(t, map[string]string{
+		"word/document.xml": "<w:document>" + strings.Repeat(" ", maxArchiveFileSize) + "</w:document>",
+	})
+	if len(body) >= maxArchiveFileSize/100 {
+		t.Fatalf("expected the archive to be compressed, got %d bytes", len(body))
+	}
+
+	if _, err := (DOCXExtractor{}).Extract(body); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected an error for a file larger than the limit, got %v", err)
+	}
+}
+
+func TestMarkdownExtractor(t *testing.T) {
+	doc, err := MarkdownExtractor{}.Extract([]byte("# Getting started\n\nInstall **Easysearch** with [Docker](https://docker.com).\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if doc.Title != "Getting started" || strings.Join(strings.Fields(doc.Content), " ") != "Getting started Install Easysearch with Docker ." {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+
+	// Front matter takes precedence over headings
+	doc, err = MarkdownExtractor{}.Extract([]byte("---\ntitle: \"Front matter title\"\ndate: 2024-05-06\n---\n# Heading\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if doc.Title != "Front matter title" || doc.PublishedAt == nil || doc.PublishedAt.Format("2006-01-02") != "2024-05-06" {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+}
+
+func TestParsePDFDate(t *testing.T) {
+	for input, want := range map[string]string{
+		"D:20240131120000+01'00'": "2024-01-31T12:00:00Z",
+		"D:2023":                  "2023-01-01T00:00:00Z",
+		"":                        "",
+		"not a date":              "",
+	} {
+		got := parsePDFDate(input)
+		if (got == nil && want != "") || (got != nil && got.Format("2006-01-02T15:04:05Z07:00") != want) {
+			t.Errorf("unexpected date for %q: %v", input, got)
+		}
+	}
+}
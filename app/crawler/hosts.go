@@ -0,0 +1,243 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+	slogctx "github.com/veqryn/slog-context"
+)
+
+const (
+	// The minimum time between requests to a host if its robots.txt doesn't specify a longer Crawl-delay
+	defaultHostInterval = time.Second
+	// The longest time between requests to a host, no matter how slow it gets or what its robots.txt or Retry-After headers say
+	maxHostInterval = 5 * time.Minute
+	// How long Crawl waits for its turn to send a request before giving up with a HostBusyError
+	maxHostWait = 10 * time.Second
+	// How often each host's robots.txt is checked for a new Crawl-delay
+	robotsTTL = 24 * time.Hour
+	// Responses that take more than this many times a host's average response time make the crawler slow down
+	slowResponseFactor = 2
+	// Responses faster than this never count as slow, so that small changes in response times from fast hosts are ignored
+	minSlowResponse = 500 * time.Millisecond
+)
+
+// The rate limiter shared by every crawl, so that sources that crawl the same host share its limits
+var Hosts = NewHostLimiter()
+
+// Limits how often each host is crawled. The time between requests starts at the host's Crawl-delay (or defaultHostInterval).
+// It grows when the host responds with 429 Too Many Requests or 503 Service Unavailable, fails to respond, or responds much slower than usual,
+// and gradually shrinks back as the host recovers.
+type HostLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostState
+	// The time between requests for hosts without a Crawl-delay
+	minInterval time.Duration
+	// Used to download robots.txt files
+	client *http.Client
+}
+
+type hostState struct {
+	// The time between requests from the host's Crawl-delay, or the limiter's minimum interval
+	base       time.Duration
+	crawlDelay time.Duration
+	// The current time between requests, which is at least `base`
+	interval time.Duration
+	// When the next request can be sent
+	next time.Time
+	// A moving average of the host's response times
+	latency  time.Duration
+	requests int
+	// When robots.txt was last downloaded
+	robotsCheckedAt time.Time
+}
+
+// The rate limit that applies to a host, which is shown in the admin API
+type HostStatus struct {
+	Host string `json:"host"`
+	// The most requests per minute that the limiter currently allows
+	MaxRequestsPerMinute float64 `json:"maxRequestsPerMinute"`
+	// The Crawl-delay from the host's robots.txt, in seconds, or 0 if it doesn't have one
+	CrawlDelay float64 `json:"crawlDelay"`
+	// Whether requests are being slowed down because the host is overloaded
+	Throttled bool `json:"throttled"`
+	// The host's average response time, in seconds
+	AverageResponseTime float64 `json:"averageResponseTime"`
+	// The number of responses received from the host since Easysearch started
+	Requests int `json:"requests"`
+	// When the next request to the host can be sent
+	NextRequestAt time.Time `json:"nextRequestAt"`
+}
+
+// Returned by Crawl instead of waiting a long time for the page's host to allow another request
+type HostBusyError struct {
+	Host string
+	// How long until the host can be crawled again
+	Delay time.Duration
+}
+
+func (e *HostBusyError) Error() string {
+	return fmt.Sprintf("host %v can't be crawled for another %v", e.Host, e.Delay.Round(time.Second))
+}
+
+func NewHostLimiter() *HostLimiter {
+	return &HostLimiter{
+		hosts:       map[string]*hostState{},
+		minInterval: defaultHostInterval,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Returns the state of a host, creating it if it hasn't been seen before. The limiter must be locked.
+func (l *HostLimiter) host(host string) *hostState {
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostState{base: l.minInterval, interval: l.minInterval}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// Waits until a request can be sent to the URL's host and reserves the host's next slot for it.
+// If the host won't allow another request for longer than `maxWait`, a HostBusyError is returned immediately instead.
+func (l *HostLimiter) Wait(ctx context.Context, u *url.URL, maxWait time.Duration) error {
+	host := strings.ToLower(u.Host)
+
+	l.mu.Lock()
+	h := l.host(host)
+	checkRobots := time.Since(h.robotsCheckedAt) > robotsTTL
+	if checkRobots {
+		// Set this before downloading the file so that other requests to the host don't download it too
+		h.robotsCheckedAt = time.Now()
+	}
+	l.mu.Unlock()
+
+	if checkRobots {
+		delay, err := l.fetchCrawlDelay(ctx, u)
+		if err != nil {
+			slogctx.Debug(ctx, "Failed to read Crawl-delay from robots.txt", "host", host, "error", err)
+		}
+		l.mu.Lock()
+		h.crawlDelay = delay
+		h.base = min(max(delay, l.minInterval), maxHostInterval)
+		h.interval = max(h.interval, h.base)
+		l.mu.Unlock()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	if wait := start.Sub(now); wait > maxWait {
+		l.mu.Unlock()
+		return &HostBusyError{Host: host, Delay: wait}
+	}
+	h.next = start.Add(h.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Records how a host responded to a request, which changes how often it's crawled. `status` is 0 if the host didn't respond.
+func (l *HostLimiter) Observe(u *url.URL, status int, latency time.Duration, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.host(strings.ToLower(u.Host)).observe(time.Now(), status, latency, retryAfter)
+}
+
+func (h *hostState) observe(now time.Time, status int, latency time.Duration, retryAfter time.Duration) {
+	switch {
+	case status == 0 || status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		// The host is overloaded or asked the crawler to slow down
+		h.interval = min(h.interval*2, maxHostInterval)
+	case h.latency > 0 && latency > h.latency*slowResponseFactor && latency > minSlowResponse:
+		h.interval = min(h.interval*3/2, maxHostInterval)
+	default:
+		// Speed back up gradually as the host recovers
+		h.interval = max(h.interval*9/10, h.base)
+	}
+
+	// Requests that were already scheduled are pushed back to use the new interval
+	next := now.Add(h.interval)
+	if retryAfter > 0 {
+		next = now.Add(max(min(retryAfter, maxHostInterval), h.interval))
+	}
+	if next.After(h.next) {
+		h.next = next
+	}
+
+	if status != 0 {
+		h.requests++
+		if h.latency == 0 {
+			h.latency = latency
+		} else {
+			h.latency = (h.latency*4 + latency) / 5
+		}
+	}
+}
+
+// Downloads the robots.txt file for the URL's host and returns the Crawl-delay that applies to Easysearch, or 0 if it doesn't specify one
+func (l *HostLimiter) fetchCrawlDelay(ctx context.Context, u *url.URL) (time.Duration, error) {
+	robotsURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(ctx, "GET", robotsURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 512*1024))
+	if err != nil {
+		return 0, err
+	}
+	robots, err := robotstxt.FromStatusAndBytes(resp.StatusCode, body)
+	if err != nil {
+		return 0, err
+	}
+	if group := robots.FindGroup(userAgent); group != nil {
+		return group.CrawlDelay, nil
+	}
+	return 0, nil
+}
+
+// Returns the current limits of every host that has been crawled, sorted by hostname
+func (l *HostLimiter) Status() []HostStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := make([]HostStatus, 0, len(l.hosts))
+	for host, h := range l.hosts {
+		statuses = append(statuses, HostStatus{
+			Host:                 host,
+			MaxRequestsPerMinute: time.Minute.Seconds() / h.interval.Seconds(),
+			CrawlDelay:           h.crawlDelay.Seconds(),
+			Throttled:            h.interval > h.base,
+			AverageResponseTime:  h.latency.Seconds(),
+			Requests:             h.requests,
+			NextRequestAt:        h.next,
+		})
+	}
+	slices.SortFunc(statuses, func(a, b HostStatus) int { return strings.Compare(a.Host, b.Host) })
+	return statuses
+}
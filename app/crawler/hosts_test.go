@@ -0,0 +1,93 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHostObserve(t *testing.T) {
+	now := time.Now()
+	h := &hostState{base: time.Second, interval: time.Second}
+
+	h.observe(now, http.StatusOK, 100*time.Millisecond, 0)
+	if h.interval != time.Second || h.latency != 100*time.Millisecond || h.requests != 1 {
+		t.Errorf("unexpected state after a normal response: %+v", h)
+	}
+
+	// Rate limiting doubles the interval
+	h.observe(now, http.StatusTooManyRequests, 100*time.Millisecond, 0)
+	h.observe(now, http.StatusServiceUnavailable, 100*time.Millisecond, 0)
+	if h.interval != 4*time.Second {
+		t.Errorf("unexpected interval after rate limiting: %v", h.interval)
+	}
+
+	// A slow response increases it by half
+	h.observe(now, http.StatusOK, 2*time.Second, 0)
+	if h.interval != 6*time.Second {
+		t.Errorf("unexpected interval after a slow response: %v", h.interval)
+	}
+
+	// The interval shrinks back to the base as the host recovers
+	for range 50 {
+		h.observe(now, http.StatusOK, 100*time.Millisecond, 0)
+	}
+	if h.interval != h.base {
+		t.Errorf("interval didn't recover: %v", h.interval)
+	}
+
+	// Failures to respond don't count towards the average response time
+	latency := h.latency
+	h.observe(now, 0, 10*time.Second, 0)
+	if h.latency != latency || h.interval != 2*time.Second {
+		t.Errorf("unexpected state after a failed request: %+v", h)
+	}
+
+	// Retry-After pushes back the next request, up to the maximum interval
+	h.observe(now, http.StatusTooManyRequests, 0, time.Minute)
+	if !h.next.Equal(now.Add(time.Minute)) {
+		t.Errorf("unexpected next request time: %v", h.next.Sub(now))
+	}
+	h.observe(now, http.StatusTooManyRequests, 0, 24*time.Hour)
+	if !h.next.Equal(now.Add(maxHostInterval)) {
+		t.Errorf("unexpected next request time: %v", h.next.Sub(now))
+	}
+}
+
+func TestHostWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nCrawl-delay: 2\n"))
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/page")
+	limiter := NewHostLimiter()
+	limiter.minInterval = 10 * time.Millisecond
+
+	if err := limiter.Wait(context.Background(), u, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status := limiter.Status()
+	if len(status) != 1 || status[0].Host != u.Host || status[0].CrawlDelay != 2 || status[0].MaxRequestsPerMinute != 30 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// The next slot is 2 seconds away because of the Crawl-delay
+	var busy *HostBusyError
+	if err := limiter.Wait(context.Background(), u, time.Second); !errors.As(err, &busy) || busy.Delay <= time.Second {
+		t.Errorf("expected a HostBusyError, got %v", err)
+	}
+
+	// Cancelling the context stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, u, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context to be canceled, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+)
+
+// Finds the language that a page declares in its `lang` attribute, its `Content-Language` meta tag, or the `Content-Language` HTTP header.
+// If none of them specify a valid language, it's detected from the page's text. Returns an empty string if the language is unknown.
+func extractLanguage(doc *goquery.Selection, header string, text string) string {
+	if lang, ok := doc.Attr("lang"); ok {
+		if normalized := database.NormalizeLanguage(lang); normalized != "" {
+			return normalized
+		}
+	}
+
+	declared := ""
+	doc.Find("meta[http-equiv]").EachWithBreak(func(i int, meta *goquery.Selection) bool {
+		if equiv, _ := meta.Attr("http-equiv"); strings.EqualFold(equiv, "content-language") {
+			content, _ := meta.Attr("content")
+			declared = content
+			return false
+		}
+		return true
+	})
+	if declared == "" {
+		declared = header
+	}
+
+	// Content-Language can list multiple languages if the page is written for several audiences. The first one is used.
+	first, _, _ := strings.Cut(declared, ",")
+	if normalized := database.NormalizeLanguage(first); normalized != "" {
+		return normalized
+	}
+
+	return detectLanguage(text)
+}
+
+// Finds the language of a document that isn't HTML from its metadata or the `Content-Language` HTTP header, or detects it from the document's text
+func documentLanguage(metadata string, header string, text string) string {
+	for _, declared := range []string{metadata, header} {
+		first, _, _ := strings.Cut(declared, ",")
+		if normalized := database.NormalizeLanguage(first); normalized != "" {
+			return normalized
+		}
+	}
+	return detectLanguage(text)
+}
+
+// Scripts that are mostly used to write a single language
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// Common words in languages that are written with the Latin alphabet. Each language's words are chosen to be rare in the others.
+var stopWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "it", "for", "with", "was", "are", "this", "you", "be", "have"},
+	"es": {"el", "los", "las", "del", "que", "y", "por", "para", "con", "una", "es", "se", "como", "pero", "su"},
+	"fr": {"le", "les", "des", "et", "est", "du", "une", "pour", "dans", "pas", "qui", "sur", "au", "avec", "ce"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "den", "von", "zu", "ein", "eine", "sich", "auch", "auf"},
+	"it": {"il", "di", "che", "della", "per", "non", "sono", "gli", "del", "nel", "alla", "questo", "anche", "come", "più"},
+	"pt": {"o", "os", "do", "da", "em", "um", "uma", "não", "com", "para", "que", "mais", "dos", "das", "ao"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "met", "voor", "ook", "bij"},
+}
+
+// The number of words that are checked when detecting a language from stop words
+const detectionWords = 1000
+
+// Detects the language of text from the scripts that its letters are written in, and for the Latin alphabet, its most common words.
+// Returns an empty string if the language can't be determined with reasonable confidence.
+func detectLanguage(text string) string {
+	letters := 0
+	han, kana, latin := 0, 0, 0
+	scripts := make([]int, len(scriptLanguages))
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		default:
+			for i, s := range scriptLanguages {
+				if unicode.Is(s.script, r) {
+					scripts[i]++
+					break
+				}
+			}
+		}
+	}
+
+	if letters == 0 {
+		return ""
+	}
+	// Japanese is written with a mix of kanji (Han characters) and kana, while Chinese only uses Han characters
+	if (han+kana)*2 > letters {
+		if kana*10 > han+kana {
+			return "ja"
+		}
+		return "zh"
+	}
+	for i, count := range scripts {
+		if count*2 > letters {
+			return scriptLanguages[i].language
+		}
+	}
+	if latin*2 <= letters {
+		return ""
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	words = words[:min(len(words), detectionWords)]
+
+	counts := map[string]int{}
+	for language, list := range stopWords {
+		for _, word := range words {
+			if slices.Contains(list, word) {
+				counts[language]++
+			}
+		}
+	}
+
+	best, bestCount, secondCount := "", 0, 0
+	for language, count := range counts {
+		if count > bestCount || (count == bestCount && language < best) {
+			best, bestCount, secondCount = language, count, bestCount
+		} else if count > secondCount {
+			secondCount = count
+		}
+	}
+
+	// Require a few stop words, and a clear winner, so that short or ambiguous text isn't assigned a language
+	if bestCount < 3 || bestCount*10 < len(words) || bestCount < secondCount*3/2 {
+		return ""
+	}
+	return best
+}
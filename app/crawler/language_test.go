@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestExtractLanguage(t *testing.T) {
+	tests := []struct {
+		html   string
+		header string
+		want   string
+	}{
+		{html: `<html lang="en-GB"><head><meta http-equiv="Content-Language" content="fr"></head></html>`, header: "de", want: "en"},
+		{html: `<html><head><meta http-equiv="content-language" content="fr-CA, en"></head></html>`, header: "de", want: "fr"},
+		{html: `<html lang=""></html>`, header: "de-AT", want: "de"},
+		// Undeclared languages are detected from the text
+		{html: `<html lang="invalid!"><body>Die Katze ist nicht auf dem Tisch, und der Hund schläft auch in der Küche.</body></html>`, want: "de"},
+	}
+
+	for _, test := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(test.html))
+		if err != nil {
+			t.Fatalf("failed to parse HTML: %v", err)
+		}
+		html := doc.Find("html")
+		if got := extractLanguage(html, test.header, html.Text()); got != test.want {
+			t.Errorf("unexpected language for %v: wanted %q, got %q", test.html, test.want, got)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := map[string]string{
+		"The quick brown fox jumps over the lazy dog, and it was happy with this for the rest of the day.": "en",
+		"El perro y el gato viven en la casa con los niños, pero el gato es más tranquilo que el perro.":   "es",
+		"Le chat est sur la table et les enfants jouent dans le jardin avec le chien pour la journée.":     "fr",
+		"Il gatto è sulla tavola e gli altri animali sono nel giardino, perché non piove più della notte.": "it",
+		"De kat zit op de tafel en het is niet duidelijk waarom de hond ook in de tuin is.":                "nl",
+		"東京は日本の首都です。たくさんの人が住んでいます。":                                                                        "ja",
+		"北京是中国的首都，有很多历史悠久的建筑。":                                                                             "zh",
+		"Москва является столицей России.":                                                                 "ru",
+		"서울은 한국의 수도입니다.":                                                                                   "ko",
+		// Text that's too short or ambiguous isn't assigned a language
+		"Easysearch": "",
+		"12345 !!!":  "",
+	}
+
+	for text, want := range tests {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("unexpected language for %q: wanted %q, got %q", text, want, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+const (
+	// The delay before the first retry of a page that failed with a temporary error. It doubles with each attempt.
+	initialRetryDelay = time.Minute
+	// The longest that a page waits between attempts, even if the server asks for a longer delay with a Retry-After header
+	maxRetryDelay = 24 * time.Hour
+)
+
+// The error returned by Crawl when the server responds with an error status code
+type StatusError struct {
+	StatusCode int
+	// How long the server asked the crawler to wait before trying again, from the Retry-After header, or zero if it didn't send one
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return http.StatusText(e.StatusCode)
+}
+
+// Errors that happen before a request is sent because the URL isn't allowed. Trying again won't help.
+var permanentCollectorErrors = []error{
+	colly.ErrForbiddenDomain,
+	colly.ErrForbiddenURL,
+	colly.ErrMissingURL,
+	colly.ErrNoURLFiltersMatch,
+	colly.ErrRobotsTxtBlocked,
+}
+
+// Reports whether a crawl error is likely to be temporary, so crawling the page again soon might succeed.
+// Server errors, rate limiting, timeouts, and other network errors are temporary. Other error statuses (like 404 Not Found or 410 Gone)
+// and URLs that aren't allowed to be crawled are permanent.
+func IsRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+			return true
+		}
+		return statusErr.StatusCode >= 500
+	}
+	for _, permanent := range permanentCollectorErrors {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns how long to wait before crawling a page again after its `attempts`th consecutive temporary failure.
+// The delay doubles with each attempt and is randomized by up to half, so that pages that failed at the same time (like during an outage)
+// aren't all retried at once. If the server sent a Retry-After header, the delay is at least that long.
+func RetryDelay(attempts int32, err error) time.Duration {
+	delay := initialRetryDelay
+	for i := int32(1); i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+	delay = delay/2 + rand.N(delay/2+1)
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = min(statusErr.RetryAfter, maxRetryDelay)
+	}
+	return delay
+}
+
+// Parses a Retry-After header, which is either a number of seconds or an HTTP date. Returns zero if the header is missing or invalid.
+func parseRetryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}, true},
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{&StatusError{StatusCode: http.StatusGone}, false},
+		{colly.ErrRobotsTxtBlocked, false},
+		{colly.ErrForbiddenDomain, false},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("wrapped: %w", &StatusError{StatusCode: http.StatusBadGateway}), true},
+	}
+
+	for _, test := range tests {
+		if got := IsRetryable(test.err); got != test.want {
+			t.Errorf("unexpected result for %v: %v", test.err, got)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int32
+		err      error
+		min, max time.Duration
+	}{
+		{1, errors.New("timeout"), 30 * time.Second, time.Minute},
+		{3, errors.New("timeout"), 2 * time.Minute, 4 * time.Minute},
+		{100, errors.New("timeout"), 12 * time.Hour, 24 * time.Hour},
+		// The server's Retry-After header is respected, up to the maximum delay
+		{1, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}, time.Hour, time.Hour},
+		{1, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 48 * time.Hour}, 24 * time.Hour, 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		for range 20 {
+			if got := RetryDelay(test.attempts, test.err); got < test.min || got > test.max {
+				t.Fatalf("delay after %v attempts with error %v is out of range: %v", test.attempts, test.err, got)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("unexpected delay for seconds: %v", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("unexpected delay for a date: %v", got)
+	}
+	for _, header := range []string{"", "soon", "-5", "Wed, 21 Oct 2015 07:28:00 GMT"} {
+		if got := parseRetryAfter(header); got != 0 {
+			t.Errorf("unexpected delay for %q: %v", header, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	slogctx "github.com/veqryn/slog-context"
+)
+
+const (
+	// The default number of pages that each source crawls at the same time
+	defaultConcurrency = 4
+	// The default number of pages on the same host that each source crawls at the same time
+	defaultHostConcurrency = 2
+	// How long a page can take to crawl and process, including the time spent waiting for its host
+	crawlTimeout = 60 * time.Second
+	// How long a worker waits for another crawl of the same host to finish before putting the page back in the queue
+	maxHostSlotWait = 10 * time.Second
+)
+
+// The cause of the context cancellation when Easysearch shuts down, which tells workers to put their pages back in the queue
+var errShuttingDown = errors.New("shutting down")
+
+// Crawls the queues of all sources with a pool of workers for each source
+type crawlPool struct {
+	db     database.Database
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+}
+
+func startCrawlPool(db database.Database, config *config.Config) *crawlPool {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	pool := &crawlPool{db: db, ctx: ctx, cancel: cancel}
+
+	for _, src := range config.Sources {
+		// The workers share a ticker, so the source never sends more than `speed` requests per minute no matter how many workers it has.
+		// Ticks are dropped while every worker is busy, so they don't build up and cause a burst of requests later.
+		ticker := time.NewTicker(time.Duration(60.0 / float64(src.Speed) * float64(time.Second)))
+		concurrency := cmp.Or(src.Concurrency, defaultConcurrency)
+		hostConcurrency := cmp.Or(src.HostConcurrency, defaultHostConcurrency)
+		slots := newHostSlots(int(hostConcurrency))
+		slog.Info("Starting crawl workers", "sourceId", src.ID, "concurrency", concurrency, "hostConcurrency", hostConcurrency)
+
+		for range concurrency {
+			pool.wg.Add(1)
+			go func() {
+				defer pool.wg.Done()
+				pool.work(src, ticker.C, slots)
+			}()
+		}
+
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+	}
+
+	return pool
+}
+
+// Crawls one page from the source's queue every time it receives a tick, until the pool is stopped
+func (p *crawlPool) work(src config.Source, ticks <-chan time.Time, slots *hostSlots) {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticks:
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, crawlTimeout)
+		processCrawlQueue(ctx, p.db, src, slots)
+		cancel()
+	}
+}
+
+// Stops all workers and waits for them to put the pages they were crawling back in the queue
+func (p *crawlPool) Stop() {
+	p.cancel(errShuttingDown)
+	p.wg.Wait()
+}
+
+// Reports whether the context was cancelled because Easysearch is shutting down
+func shuttingDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShuttingDown)
+}
+
+// Puts a page that was being crawled during shutdown back in the queue, so it's crawled the next time Easysearch starts
+func returnToQueue(ctx context.Context, db database.Database, item *database.QueueItem) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := db.UpdateQueueEntry(ctx, item.ID, database.Pending); err != nil {
+		slogctx.Error(ctx, "Failed to return queue item to Pending", "url", item.URL, "error", err)
+	} else {
+		slogctx.Debug(ctx, "Returned queue item to Pending for shutdown", "url", item.URL)
+	}
+}
+
+// Limits how many pages on each host a source crawls at the same time
+type hostSlots struct {
+	mu    sync.Mutex
+	limit int
+	hosts map[string]chan struct{}
+}
+
+func newHostSlots(limit int) *hostSlots {
+	return &hostSlots{limit: limit, hosts: map[string]chan struct{}{}}
+}
+
+// Waits until fewer than the limit of pages on the URL's host are being crawled, and then reserves a slot for the URL.
+// Returns false if the context is cancelled first. Otherwise, the returned function must be called to release the slot.
+func (s *hostSlots) acquire(ctx context.Context, rawURL string) (func(), bool) {
+	host := ""
+	if parsed, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(parsed.Host)
+	}
+
+	s.mu.Lock()
+	sem, ok := s.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, s.limit)
+		s.hosts[host] = sem
+	}
+	s.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
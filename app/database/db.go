@@ -1,6 +1,14 @@
 package database
 
-import "context"
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fluxcapacitor2/easysearch/app/query"
+	"golang.org/x/text/language"
+)
 
 type Database interface {
 	// Create necessary tables
@@ -12,12 +20,21 @@ type Database interface {
 	Cleanup(ctx context.Context) error
 
 	// Add a page to the search index.
-	AddDocument(ctx context.Context, source string, depth int32, referrers []int64, url string, status QueueItemStatus, title string, description string, content string, errorInfo string) (int64, error)
+	AddDocument(ctx context.Context, source string, depth int32, referrers []int64, url string, status QueueItemStatus, title string, description string, content string, errorInfo string, metadata PageMetadata) (int64, error)
 	// Returns whether the given URL (or the URL's canonical) is indexed
 	HasDocument(ctx context.Context, source string, url string) (*bool, error)
 	// Fetch the document by URL (or the URL's canonical)
 	GetDocument(ctx context.Context, source string, url string) (*Page, error)
 	GetDocumentByID(ctx context.Context, id int64) (*Page, error)
+	// Returns the validators from the last time the page (found by its URL or the URL's canonical) was crawled, or nil if it isn't indexed
+	GetCacheValidators(ctx context.Context, source string, url string) (*CacheValidators, error)
+	// Records that a page was crawled again and hasn't changed, and returns its ID. Only its crawl time and validators are updated,
+	// so the search index and embeddings aren't rewritten. Returns ErrPageNotFound if the page isn't indexed.
+	MarkUnchanged(ctx context.Context, source string, url string, validators CacheValidators) (int64, error)
+	// Records that crawling a page failed without changing its content or status, so it stays in search results. Returns the number of times in a row
+	// that it failed permanently (for example, with a 404 status), which only increases if `permanent` is true.
+	// The count is reset when the page is crawled successfully. Returns ErrPageNotFound if the page isn't indexed.
+	AddFailure(ctx context.Context, source string, url string, errorInfo string, permanent bool) (int32, error)
 	// Delete a document by its URL and remove all canonicals pointing to it
 	RemoveDocument(ctx context.Context, source string, url string) error
 
@@ -33,19 +50,36 @@ type Database interface {
 	GetReferrers(ctx context.Context, pageID int64) ([]int64, error)
 	// Lists pages with no referrers. These pages should usually be deleted, unless they're the source's base URL.
 	ListOrphanPages(ctx context.Context) ([]int64, error)
+	// Computes a PageRank score for each page in the source using the links between its pages.
+	// The scores are stored and used to rank search results according to SearchOptions.Ranking.
+	UpdateAuthority(ctx context.Context, source string) error
 
 	// Run a fulltext search with the given query
-	Search(ctx context.Context, sources []string, query string, page uint32, pageSize uint32) ([]FTSResult, *uint32, error)
+	Search(ctx context.Context, sources []string, query string, options SearchOptions, page uint32, pageSize uint32) ([]FTSResult, *uint32, error)
+	// Count the results of a fulltext search, grouped by source, host, section, and kind.
+	// Each group's counts ignore the filter for that group, so that users can see how many results they would get if they changed it.
+	Facets(ctx context.Context, sources []string, query string, options SearchOptions) (*Facets, error)
 
 	// Add an item to the crawl queue
 	AddToQueue(ctx context.Context, source string, referrer string, urls []string, depth int32, isRefresh bool) error
 	// Update the status of the item in the queue by its ID
 	UpdateQueueEntry(ctx context.Context, id int64, status QueueItemStatus) error
 	// Sets the first item in the queue to `Processing` and returns it. If both the item and `error` is nil, the queue is empty OR another worker already claimed the row.
+	// Items that are waiting to be retried are skipped until their next attempt is due.
 	PopQueue(ctx context.Context, source string) (*QueueItem, error)
+	// Marks a queue item as Pending again after a temporary failure and increments its number of attempts. PopQueue skips it until `delay` has passed.
+	RetryQueueEntry(ctx context.Context, id int64, delay time.Duration) error
+	// Marks a queue item as Pending again without counting a failed attempt, like when its host is being crawled too often. PopQueue skips it until `delay` has passed.
+	PostponeQueueEntry(ctx context.Context, id int64, delay time.Duration) error
 	// Add pages older than `daysAgo` to the queue to be recrawled.
 	QueuePagesOlderThan(ctx context.Context, source string, daysAgo int32) error
 
+	// Records that the URLs were linked from a feed (like RSS or Atom), so that their pages are assigned the KindFeedItem kind
+	// and the dates from the feed can be used if the pages don't specify their own
+	AddFeedItems(ctx context.Context, source string, items []FeedItem) error
+	// Records the last modified dates of URLs in a sitemap, to be used if the pages don't specify their own
+	AddSitemapEntries(ctx context.Context, source string, entries []SitemapEntry) error
+
 	GetCanonical(ctx context.Context, source string, url string) (*Canonical, error)
 	SetCanonical(ctx context.Context, source string, url string, canonical string) error
 
@@ -60,16 +94,46 @@ type Database interface {
 	// Adds pages with no embeddings to the embed queue
 	StartEmbeddings(ctx context.Context, source string, chunkSize int, chunkOverlap int) error
 
-	SimilaritySearch(ctx context.Context, sourceID string, query []float32, limit int) ([]SimilarityResult, error)
-	HybridSearch(ctx context.Context, sources []string, queryString string, embeddedQueries map[string][]float32, limit int) ([]HybridResult, error)
+	// Returns the pages closest to the query embedding, from most to least similar, with each page's closest chunk, and an estimate of the number of matching pages
+	SimilaritySearch(ctx context.Context, sourceID string, query []float32, limit int) ([]SimilarityResult, *uint32, error)
+	// Find pages that are similar to an indexed page (found by its URL or the URL's canonical) using the page's stored embeddings.
+	// The page and its near-duplicates are excluded. Returns ErrPageNotFound if the page isn't indexed, or ErrNoEmbeddings if it hasn't been embedded yet.
+	SimilarPages(ctx context.Context, sourceID string, url string, aggregation ChunkAggregation, limit int) ([]SimilarityResult, error)
+	// Returns up to `perPage` chunks from each of the pages with the given URLs, keyed by URL, ordered from closest to furthest from the query embedding.
+	// Pages that haven't been embedded aren't included.
+	RelevantChunks(ctx context.Context, sourceID string, query []float32, urls []string, perPage int) (map[string][]string, error)
+	// Like SimilarPages, but searches for the page's most distinctive terms with a full-text search. This works for sources without embeddings.
+	// Each result's similarity is relative to the best result, which has a similarity of 1. Terms are weighted with the source's vocabulary,
+	// which is updated with its spelling correction dictionary (see UpdateSpellfixIndex), so recently added pages may not be counted yet.
+	RelatedPages(ctx context.Context, sourceID string, url string, limit int) ([]SimilarityResult, error)
+	// Combine the results of a fulltext search and a vector similarity search. The filters and ranking in `options` apply to both, but `Sort` and `Collapse` are ignored.
+	// The total is approximate, since only the top results of each search are combined.
+	HybridSearch(ctx context.Context, sources []string, queryString string, options SearchOptions, embeddedQueries map[string][]float32, page uint32, pageSize uint32) ([]HybridResult, *uint32, error)
 
-	// Use vocabulary from the search corpus to set up spelling correction
-	CreateSpellfixIndex(ctx context.Context) error
+	// Use vocabulary from the search corpus to set up spelling correction. Each source has its own dictionary,
+	// which is only updated if the source's pages changed since the last call.
+	UpdateSpellfixIndex(ctx context.Context) error
 	// Remove any created indexes for spell checking to free up disk space
 	DropSpellfixIndex(ctx context.Context) error
 
-	// Attempt to fix spelling errors using a dictionary
-	Spellfix(ctx context.Context, query string) (string, error)
+	// Attempt to fix spelling errors using the dictionaries of the given sources
+	Spellfix(ctx context.Context, sources []string, query string) (string, error)
+
+	// Record that a query was searched so that it can be suggested to other users
+	RecordQuery(ctx context.Context, sources []string, query string) error
+	// Get completions for a partially-typed query from popular past queries, words in the index, and page titles
+	Suggest(ctx context.Context, sources []string, prefix string, limit int) ([]Suggestion, error)
+
+	// Curations pin pages to the top of the results for certain queries and hide other pages. They're applied by Search and HybridSearch.
+
+	// List the curations for a source, ordered by ID
+	ListCurations(ctx context.Context, source string) ([]Curation, error)
+	// Get a curation by its ID. Returns ErrCurationNotFound if it doesn't exist.
+	GetCuration(ctx context.Context, id int64) (*Curation, error)
+	// Create a curation (if its ID is 0) or replace an existing one, and return its ID. Returns ErrCurationNotFound if the ID doesn't exist.
+	SaveCuration(ctx context.Context, curation Curation) (int64, error)
+	// Delete a curation by its ID. Returns ErrCurationNotFound if it doesn't exist.
+	DeleteCuration(ctx context.Context, id int64) error
 }
 
 type Page struct {
@@ -83,6 +147,225 @@ type Page struct {
 	CrawledAt   string          `json:"crawledAt"`
 	Status      QueueItemStatus `json:"status"`
 	ErrorInfo   string          `json:"error"`
+	Kind        string          `json:"kind"`
+	// Timestamps in RFC 3339 format, if known
+	PublishedAt *string `json:"publishedAt"`
+	ModifiedAt  *string `json:"modifiedAt"`
+}
+
+// Information about a page that isn't searched directly, but can be used to filter and group results
+type PageMetadata struct {
+	// One of the Kind constants
+	Kind string
+	// When the page was published and last modified, according to the page itself
+	PublishedAt *time.Time
+	ModifiedAt  *time.Time
+	// The value of the HTTP Last-Modified header. This is only used if the page, its feed, and its sitemap don't specify when it was modified.
+	LastModified *time.Time
+	// The page's language code (see NormalizeLanguage), or an empty string if it's unknown
+	Language string
+	// The canonical URLs of versions of this page in other languages, from its hreflang links
+	Alternates []string
+	// The response's validators, which are sent with the next request for the page to check whether it changed
+	Validators CacheValidators
+}
+
+// The information used to tell whether a page changed since it was last crawled
+type CacheValidators struct {
+	// The ETag and Last-Modified headers, exactly as the server sent them. They're sent back in If-None-Match and If-Modified-Since headers.
+	ETag         string
+	LastModified string
+	// A hex-encoded SHA-256 hash of the response body
+	ContentHash string
+}
+
+// Converts a language tag, like "en-US", into the lowercase ISO 639 code that pages are filtered by, like "en".
+// Returns an empty string if the tag isn't valid.
+func NormalizeLanguage(tag string) string {
+	parsed, err := language.Parse(strings.TrimSpace(tag))
+	if err != nil {
+		return ""
+	}
+	base, confidence := parsed.Base()
+	if confidence == language.No || base.String() == "und" {
+		return ""
+	}
+	return base.String()
+}
+
+// A link from an RSS, Atom, or JSON feed
+type FeedItem struct {
+	URL         string
+	PublishedAt *time.Time
+	ModifiedAt  *time.Time
+}
+
+// A URL listed in a sitemap
+type SitemapEntry struct {
+	URL          string
+	LastModified *time.Time
+}
+
+// The kinds of content that pages can contain
+const (
+	// An HTML page
+	KindPage = "page"
+	// An HTML page that was linked from an RSS, Atom, or JSON feed, like a blog post
+	KindFeedItem = "feed-item"
+	// A document that isn't HTML, like a PDF or a Markdown file
+	KindDocument = "document"
+)
+
+// Narrows down and orders search results. Empty fields don't filter anything.
+type SearchOptions struct {
+	// The lowercase hostname of the page's URL
+	Host string
+	// The first segment of the page's URL path, like "blog" for "https://example.com/blog/post"
+	Section string
+	// One of the Kind constants
+	Kind string
+	// Only include pages dated after this time. A page's date is when it was published, or if that's unknown, when it was last modified.
+	// Pages without a date are excluded.
+	After *time.Time
+	// Only include pages dated before this time
+	Before *time.Time
+	// The order of the results. Defaults to SortRelevance.
+	Sort SortOrder
+	// How to rank each source's pages, keyed by source ID. Sources that aren't included use DefaultRanking.
+	// To customize part of a source's ranking, start with a copy of DefaultRanking.
+	Ranking map[string]Ranking
+	// Only include pages in this language (see NormalizeLanguage)
+	Language string
+	// Languages that the user can read, which are ranked higher. See LanguagePreference.
+	PreferredLanguages []LanguagePreference
+	// Whether to include an explanation of how each result's score was calculated
+	Explain bool
+	// Each source's synonyms, keyed by source ID. When multiple sources are searched, all of their synonyms apply.
+	Synonyms map[string]*query.Synonyms
+	// How results are grouped. Only the best result in each group is returned, and FTSResult.Collapsed counts the others.
+	// Defaults to CollapseNone.
+	Collapse CollapseMode
+	// Only include the page with this URL and its near-duplicates. This lists the results that CollapseDuplicates hid.
+	DuplicatesOf string
+	// The length and number of each result's snippets
+	Snippets SnippetOptions
+}
+
+// How the snippets of search results are generated. Fields that are zero use the defaults.
+type SnippetOptions struct {
+	// The maximum number of tokens in the description snippet. Defaults to 8.
+	DescriptionTokens int
+	// The maximum number of tokens in each content snippet. Defaults to 24.
+	ContentTokens int
+	// The number of separate parts of the content that are shown, joined by "…". Parts with more matches are preferred. Defaults to 1.
+	ContentSnippets int
+}
+
+type CollapseMode string
+
+const (
+	// Return every matching page
+	CollapseNone CollapseMode = "none"
+	// Return the best result from each host
+	CollapseHost CollapseMode = "host"
+	// Return the best result from each group of near-duplicates, like a page and its print view
+	CollapseDuplicates CollapseMode = "duplicates"
+)
+
+// Controls how a source's pages are ranked. A page's full-text search score is its bm25 score (where lower is better)
+// multiplied by its authority, depth, and URL boosts.
+type Ranking struct {
+	// The weights of matches in each field when calculating bm25 scores
+	FieldWeights FieldWeights
+	// How much a page's authority (see UpdateAuthority) affects its full-text search rank.
+	// A page's bm25 score is multiplied by `1 + AuthorityWeight * authority`, where `authority` is between 0 and 1.
+	AuthorityWeight float64
+	// How much shallower pages (ones that are fewer links away from the source's base URL) are preferred.
+	// A page's bm25 score is multiplied by `1 + DepthBoost / (1 + depth)`.
+	DepthBoost float64
+	// Multipliers for pages whose URLs match a pattern
+	URLRules []URLRule
+	// How much pages in the user's preferred languages (see SearchOptions.PreferredLanguages) are preferred.
+	// A page's bm25 score is multiplied by `1 + LanguageBoost * weight`, where `weight` is the user's preference for the page's language.
+	LanguageBoost float64
+	// The constant `k` in reciprocal rank fusion, which is used to combine full-text and vector search results in HybridSearch.
+	// Larger values reduce the difference between highly-ranked and lower-ranked results.
+	RRFConstant float64
+	// How much vector search results count in HybridSearch, compared to full-text search results (which have a weight of 1)
+	VectorWeight float64
+}
+
+type FieldWeights struct {
+	URL         float64
+	Title       float64
+	Description float64
+	Content     float64
+}
+
+type URLRule struct {
+	// The rule applies to pages whose URLs contain this string, like "/blog/"
+	Contains string
+	// The page's bm25 score is multiplied by this value. Values above 1 boost matching pages, and values between 0 and 1 bury them.
+	Multiplier float64
+}
+
+// The ranking used for sources that aren't included in SearchOptions.Ranking
+var DefaultRanking = Ranking{
+	FieldWeights:  FieldWeights{URL: 1.0, Title: 3.0, Description: 0.8, Content: 1.0},
+	RRFConstant:   60,
+	VectorWeight:  0.5,
+	LanguageBoost: 1,
+}
+
+// Controls how text is split into terms in the full-text search index
+type Tokenizer struct {
+	// One of the Tokenizer constants
+	Name string
+	// Whether diacritics are removed from letters, so that "cafe" matches "café"
+	RemoveDiacritics bool
+}
+
+// The tokenizers that the full-text search index can use. See https://sqlite.org/fts5.html#tokenizers
+const (
+	// Splits text into words at spaces and punctuation
+	TokenizerUnicode61 = "unicode61"
+	// Splits text into words like TokenizerUnicode61 and reduces English words to their stems, so that "running" matches "run"
+	TokenizerPorter = "porter"
+	// Indexes every sequence of three characters, so that queries match any part of a word.
+	// This allows searching languages that aren't written with spaces, like Chinese and Japanese.
+	TokenizerTrigram = "trigram"
+)
+
+var DefaultTokenizer = Tokenizer{Name: TokenizerUnicode61, RemoveDiacritics: true}
+
+// A language that the user can read, from the Accept-Language header
+type LanguagePreference struct {
+	// A language code (see NormalizeLanguage)
+	Language string
+	// How much the user prefers this language, between 0 and 1. Pages in the language are boosted in proportion to this.
+	Weight float64
+}
+
+type SortOrder string
+
+const (
+	// Sort results by how well they match the query
+	SortRelevance SortOrder = "relevance"
+	// Sort results from newest to oldest. Pages without a date are shown last.
+	SortDate SortOrder = "date"
+)
+
+type Facets struct {
+	Sources   []FacetValue `json:"sources"`
+	Hosts     []FacetValue `json:"hosts"`
+	Sections  []FacetValue `json:"sections"`
+	Kinds     []FacetValue `json:"kinds"`
+	Languages []FacetValue `json:"languages"`
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count uint32 `json:"count"`
 }
 
 type FTSResult struct {
@@ -91,15 +374,81 @@ type FTSResult struct {
 	Description []Match `json:"description"`
 	Content     []Match `json:"content"`
 	Rank        float64 `json:"rank"`
+	// Timestamps in RFC 3339 format, if known
+	PublishedAt *string `json:"publishedAt,omitempty"`
+	ModifiedAt  *string `json:"modifiedAt,omitempty"`
+	// The page's language code, if known
+	Language *string `json:"language,omitempty"`
+	// Only included if SearchOptions.Explain is set
+	Explanation *ScoreExplanation `json:"explanation,omitempty"`
+	// Whether a curation placed this result at the top of the results
+	Pinned bool `json:"pinned,omitempty"`
+	// The number of other results in this result's group that were hidden because of SearchOptions.Collapse
+	Collapsed uint32 `json:"collapsed,omitempty"`
+	// Only included if the result was reranked after the search
+	Rerank *RerankExplanation `json:"rerank,omitempty"`
+}
+
+// How a reranker changed a result's position
+type RerankExplanation struct {
+	// The result's position before reranking, starting at 1
+	OriginalRank int `json:"originalRank"`
+	// The reranker's score. Results are sorted by this score from highest to lowest.
+	Score float64 `json:"score"`
+}
+
+// How a page's full-text search score was calculated. The score is the product of the bm25 score and the boosts.
+type ScoreExplanation struct {
+	BM25 float64 `json:"bm25"`
+	// The page's authority, between 0 and 1
+	Authority      float64 `json:"authority"`
+	AuthorityBoost float64 `json:"authorityBoost"`
+	DepthBoost     float64 `json:"depthBoost"`
+	URLBoost       float64 `json:"urlBoost"`
+	LanguageBoost  float64 `json:"languageBoost"`
+	Score          float64 `json:"score"`
+}
+
+// How a hybrid search result's rank was calculated. The rank is the sum of the full-text and vector search contributions.
+type HybridExplanation struct {
+	// Only included if the page matched the full-text search
+	FTS *ScoreExplanation `json:"fts,omitempty"`
+	// The page's reciprocal rank in the full-text search results, `1 / (k + ftsRank)`
+	FTSContribution float64 `json:"ftsContribution"`
+	// The page's weighted reciprocal rank in the vector search results, `vectorWeight / (k + vecRank)`
+	VecContribution float64 `json:"vecContribution"`
 }
 
 type SimilarityResult struct {
-	URL        string  `json:"url"`
-	Title      string  `json:"title"`
-	Chunk      string  `json:"chunk"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	Chunk string `json:"chunk"`
+	// Higher is more similar. For vector searches, this is the cosine similarity, `1 - distance`.
 	Similarity float32 `json:"similarity"`
+	// The cosine distance between the embeddings, between 0 and 2. Only included for vector searches.
+	Distance *float32 `json:"distance,omitempty"`
+	// The source that the result came from. Only included for similarity searches, which can search multiple sources.
+	Source string `json:"source,omitempty"`
 }
 
+// The deepest result that vector searches can show, since they have to fetch every result up to the requested page
+const MaxVectorResults = 1000
+
+// How the embeddings of a page's chunks are combined to find similar pages
+type ChunkAggregation string
+
+const (
+	// Search for pages near the average of the page's chunk embeddings, which represents the page as a whole
+	AggregateMean ChunkAggregation = "mean"
+	// Search for pages near any of the page's chunks, ranking each page by its closest chunk. This finds pages that are related to one part of a long page.
+	AggregateBest ChunkAggregation = "best"
+)
+
+var (
+	ErrPageNotFound = errors.New("page not found")
+	ErrNoEmbeddings = errors.New("page has no embeddings")
+)
+
 type HybridResult struct {
 	URL         string   `json:"url"`
 	Title       []Match  `json:"title"`
@@ -109,8 +458,58 @@ type HybridResult struct {
 	VecRank     *int     `json:"vecRank"`
 	VecDistance *float64 `json:"vecDistance"`
 	HybridRank  float64  `json:"rank"`
+	// The page's language code, if known
+	Language *string `json:"language,omitempty"`
+	// Only included if SearchOptions.Explain is set
+	Explanation *HybridExplanation `json:"explanation,omitempty"`
+	// Whether a curation placed this result at the top of the results
+	Pinned bool `json:"pinned,omitempty"`
+	// Only included if the result was reranked after the search
+	Rerank *RerankExplanation `json:"rerank,omitempty"`
+}
+
+type Curation struct {
+	ID     int64  `json:"id"`
+	Source string `json:"source"`
+	// The query that this curation applies to. Queries are compared case-insensitively, ignoring extra whitespace.
+	Pattern string        `json:"pattern"`
+	Match   CurationMatch `json:"match"`
+	// URLs to show at the top of the results in this order, even if they don't match the query
+	Pinned []string `json:"pinned"`
+	// URLs to remove from the results
+	Hidden []string `json:"hidden"`
 }
 
+type CurationMatch string
+
+const (
+	// The curation applies to queries that are the same as its pattern
+	MatchExact CurationMatch = "exact"
+	// The curation applies to queries that contain its pattern's words in order. For example, "pricing" matches "enterprise pricing".
+	MatchContains CurationMatch = "contains"
+)
+
+var ErrCurationNotFound = errors.New("curation not found")
+
+type Suggestion struct {
+	// The completed query, or the title of a page for SuggestionPage
+	Text string         `json:"text"`
+	Kind SuggestionKind `json:"kind"`
+	// For SuggestionPage, the URL of the page
+	URL string `json:"url,omitempty"`
+}
+
+type SuggestionKind string
+
+const (
+	// A query that other users searched for
+	SuggestionQuery SuggestionKind = "query"
+	// The input with its last word completed using a word from the index
+	SuggestionTerm SuggestionKind = "term"
+	// A page whose title starts with the input
+	SuggestionPage SuggestionKind = "page"
+)
+
 type Match struct {
 	Highlighted bool   `json:"highlighted"`
 	Content     string `json:"content"`
@@ -139,6 +538,8 @@ type QueueItem struct {
 	IsRefresh bool
 	Referrers []int64
 	Status    QueueItemStatus
+	// The number of times in a row that crawling this item failed with a temporary error
+	Attempts int32
 }
 
 type Canonical struct {
@@ -2,19 +2,21 @@ package database
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"database/sql"
 	"fmt"
-	"maps"
-	"regexp"
+	"math"
 	"slices"
 	"strings"
 	"text/template"
+	"time"
 
 	_ "embed"
 
 	vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
 	"github.com/fluxcapacitor2/easysearch/app/embedding"
+	"github.com/fluxcapacitor2/easysearch/app/query"
 	"github.com/fluxcapacitor2/easysearch/app/spellfix"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
@@ -25,6 +27,8 @@ import (
 // official documentation: https://sqlite.org/fts5.html
 type SQLiteDatabase struct {
 	conn *sql.DB
+	// The tokenizer used by the full-text search index. See UseTokenizer.
+	tokenizer Tokenizer
 }
 
 //go:embed db_sqlite_setup.sql
@@ -34,8 +38,14 @@ var setupCommands string
 var embedSetupCommands string
 
 func (db *SQLiteDatabase) Setup(ctx context.Context) error {
-	_, err := db.conn.ExecContext(ctx, setupCommands)
-	return err
+	_, err := db.conn.ExecContext(ctx, fmt.Sprintf(setupCommands, db.tokenizer.args()))
+	if err != nil {
+		return err
+	}
+	if err := db.migrate(ctx); err != nil {
+		return err
+	}
+	return db.setupTokenizer(ctx)
 }
 
 func (db *SQLiteDatabase) SetupVectorTables(ctx context.Context, sourceID string, dimensions int) error {
@@ -52,7 +62,7 @@ func (db *SQLiteDatabase) DropVectorTables(ctx context.Context, sourceID string)
 	return err
 }
 
-func (db *SQLiteDatabase) AddDocument(ctx context.Context, source string, depth int32, referrers []int64, url string, status QueueItemStatus, title string, description string, content string, errorInfo string) (int64, error) {
+func (db *SQLiteDatabase) AddDocument(ctx context.Context, source string, depth int32, referrers []int64, url string, status QueueItemStatus, title string, description string, content string, errorInfo string, metadata PageMetadata) (int64, error) {
 	id := int64(-1)
 	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
@@ -62,12 +72,40 @@ func (db *SQLiteDatabase) AddDocument(ctx context.Context, source string, depth
 		return id, err
 	}
 
+	kind := cmp.Or(metadata.Kind, KindPage)
+
+	var pageFingerprint, duplicateGroup sql.Null[int64]
+	if f, ok := fingerprint(content); ok && status == Finished {
+		pageFingerprint = sql.Null[int64]{V: int64(f), Valid: true}
+		duplicateGroup, err = findDuplicateGroup(ctx, tx, source, url, f)
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				return id, err
+			}
+			return id, err
+		}
+	}
+
 	err = tx.QueryRowContext(ctx, `
-	INSERT INTO pages (source, depth, status, url, title, description, content, errorInfo)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT DO UPDATE SET depth = min(depth, excluded.depth), status = excluded.status, title = excluded.title, description = excluded.description, content = excluded.content, errorInfo = excluded.errorInfo, crawledAt = CURRENT_TIMESTAMP
+	INSERT INTO pages (source, depth, status, url, title, description, content, errorInfo, language, alternateGroup, fingerprint, duplicateGroup, etag, lastModifiedHeader, contentHash, kind, publishedAt, modifiedAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+		-- HTML pages that were linked from a feed are feed items
+		CASE WHEN ? = ? AND EXISTS (SELECT 1 FROM feed_items WHERE source = ? AND url = ?) THEN ? ELSE ? END,
+		-- Dates from the page itself take precedence over dates from feeds, then sitemaps, then the Last-Modified header
+		coalesce(?, (SELECT publishedAt FROM feed_items WHERE source = ? AND url = ?)),
+		coalesce(?, (SELECT modifiedAt FROM feed_items WHERE source = ? AND url = ?), (SELECT lastModified FROM sitemap_entries WHERE source = ? AND url = ?), ?)
+	)
+	ON CONFLICT DO UPDATE SET depth = min(depth, excluded.depth), status = excluded.status, title = excluded.title, description = excluded.description, content = excluded.content, errorInfo = excluded.errorInfo, language = excluded.language, alternateGroup = excluded.alternateGroup, fingerprint = excluded.fingerprint, duplicateGroup = excluded.duplicateGroup, etag = excluded.etag, lastModifiedHeader = excluded.lastModifiedHeader, contentHash = excluded.contentHash, kind = excluded.kind, publishedAt = excluded.publishedAt, modifiedAt = excluded.modifiedAt, crawledAt = CURRENT_TIMESTAMP,
+		-- Failures are counted by AddFailure until the page loads successfully
+		failures = CASE WHEN excluded.status = ? THEN failures ELSE 0 END
 	RETURNING id;
-	`, source, depth, status, url, title, description, content, errorInfo).Scan(&id)
+	`, source, depth, status, url, title, description, content, errorInfo, nullString(metadata.Language), alternateGroup(url, metadata.Alternates), pageFingerprint, duplicateGroup,
+		nullString(metadata.Validators.ETag), nullString(metadata.Validators.LastModified), nullString(metadata.Validators.ContentHash),
+		kind, KindPage, source, url, KindFeedItem, kind,
+		formatTime(metadata.PublishedAt), source, url,
+		formatTime(metadata.ModifiedAt), source, url, source, url, formatTime(metadata.LastModified),
+		Error,
+	).Scan(&id)
 	if err != nil {
 		if err := tx.Rollback(); err != nil {
 			return id, err
@@ -170,6 +208,82 @@ func (db *SQLiteDatabase) ListOrphanPages(ctx context.Context) ([]int64, error)
 	return pageIDs, nil
 }
 
+func (db *SQLiteDatabase) UpdateAuthority(ctx context.Context, source string) error {
+	// Only indexed pages are included. Links to pages that failed to load don't count towards the linking page's outgoing links.
+	rows, err := db.conn.QueryContext(ctx, "SELECT id FROM pages WHERE source = ? AND status = ?;", source, Finished)
+	if err != nil {
+		return fmt.Errorf("error listing pages: %v", err)
+	}
+	defer rows.Close()
+	pages := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		pages = append(pages, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	linkRows, err := db.conn.QueryContext(ctx, `
+		SELECT pages_referrers.source, pages_referrers.dest FROM pages_referrers
+		JOIN pages ON pages.id = pages_referrers.source
+		WHERE pages.source = ?;
+	`, source)
+	if err != nil {
+		return fmt.Errorf("error listing links: %v", err)
+	}
+	defer linkRows.Close()
+	links := map[int64][]int64{}
+	for linkRows.Next() {
+		var src, dest int64
+		if err := linkRows.Scan(&src, &dest); err != nil {
+			return err
+		}
+		links[src] = append(links[src], dest)
+	}
+	if err := linkRows.Err(); err != nil {
+		return err
+	}
+
+	scores := normalizeAuthority(pageRank(pages, links))
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM page_authority WHERE page IN (SELECT id FROM pages WHERE source = ?);", source)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO page_authority (page, score) VALUES (?, ?);")
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range pages {
+		if _, err := stmt.ExecContext(ctx, id, scores[id]); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return rbErr
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (db *SQLiteDatabase) RemoveDocument(ctx context.Context, source string, url string) error {
 	_, err := db.conn.ExecContext(ctx, "DELETE FROM pages WHERE source = ? AND url = ?;", source, url)
 	return err
@@ -192,11 +306,53 @@ func (db *SQLiteDatabase) HasDocument(ctx context.Context, source string, url st
 	return &exists, nil
 }
 
+func (db *SQLiteDatabase) GetCacheValidators(ctx context.Context, source string, url string) (*CacheValidators, error) {
+	var etag, lastModified, contentHash sql.Null[string]
+	err := db.conn.QueryRowContext(ctx, "SELECT etag, lastModifiedHeader, contentHash FROM pages WHERE source = ? AND (url = ? OR url IN (SELECT canonical FROM canonicals WHERE url = ?));", source, url, url).
+		Scan(&etag, &lastModified, &contentHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CacheValidators{ETag: etag.V, LastModified: lastModified.V, ContentHash: contentHash.V}, nil
+}
+
+func (db *SQLiteDatabase) MarkUnchanged(ctx context.Context, source string, url string, validators CacheValidators) (int64, error) {
+	id := int64(-1)
+	// Servers can omit validators from 304 responses, so the stored ones are kept unless new ones were sent
+	err := db.conn.QueryRowContext(ctx, `
+	UPDATE pages SET crawledAt = CURRENT_TIMESTAMP, failures = 0, etag = coalesce(?, etag), lastModifiedHeader = coalesce(?, lastModifiedHeader), contentHash = coalesce(?, contentHash)
+	WHERE source = ? AND (url = ? OR url IN (SELECT canonical FROM canonicals WHERE url = ?))
+	RETURNING id;
+	`, nullString(validators.ETag), nullString(validators.LastModified), nullString(validators.ContentHash), source, url, url).Scan(&id)
+	if err == sql.ErrNoRows {
+		return id, ErrPageNotFound
+	}
+	return id, err
+}
+
+func (db *SQLiteDatabase) AddFailure(ctx context.Context, source string, url string, errorInfo string, permanent bool) (int32, error) {
+	increment := 0
+	if permanent {
+		increment = 1
+	}
+	// The crawl time is updated so that the page isn't refreshed again until its next refresh interval
+	var failures int32
+	err := db.conn.QueryRowContext(ctx, "UPDATE pages SET failures = failures + ?, errorInfo = ?, crawledAt = CURRENT_TIMESTAMP WHERE source = ? AND (url = ? OR url IN (SELECT canonical FROM canonicals WHERE url = ?)) RETURNING failures;",
+		increment, errorInfo, source, url, url).Scan(&failures)
+	if err == sql.ErrNoRows {
+		return 0, ErrPageNotFound
+	}
+	return failures, err
+}
+
 func (db *SQLiteDatabase) GetDocument(ctx context.Context, source string, url string) (*Page, error) {
-	cursor := db.conn.QueryRowContext(ctx, "SELECT id, source, url, title, description, content, depth, crawledAt, status, errorInfo FROM pages WHERE source = ? AND (url = ? OR url IN (SELECT canonical FROM canonicals WHERE url = ?));", source, url, url)
+	cursor := db.conn.QueryRowContext(ctx, "SELECT id, source, url, title, description, content, depth, crawledAt, status, errorInfo, kind, publishedAt, modifiedAt FROM pages WHERE source = ? AND (url = ? OR url IN (SELECT canonical FROM canonicals WHERE url = ?));", source, url, url)
 
 	page := Page{}
-	err := cursor.Scan(&page.ID, &page.Source, &page.URL, &page.Title, &page.Description, &page.Content, &page.Depth, &page.CrawledAt, &page.Status, &page.ErrorInfo)
+	err := cursor.Scan(&page.ID, &page.Source, &page.URL, &page.Title, &page.Description, &page.Content, &page.Depth, &page.CrawledAt, &page.Status, &page.ErrorInfo, &page.Kind, &page.PublishedAt, &page.ModifiedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -210,10 +366,10 @@ func (db *SQLiteDatabase) GetDocument(ctx context.Context, source string, url st
 }
 
 func (db *SQLiteDatabase) GetDocumentByID(ctx context.Context, id int64) (*Page, error) {
-	cursor := db.conn.QueryRowContext(ctx, "SELECT id, source, url, title, description, content, depth, crawledAt, status, errorInfo FROM pages WHERE id = ?;", id)
+	cursor := db.conn.QueryRowContext(ctx, "SELECT id, source, url, title, description, content, depth, crawledAt, status, errorInfo, kind, publishedAt, modifiedAt FROM pages WHERE id = ?;", id)
 
 	page := Page{}
-	err := cursor.Scan(&page.ID, &page.Source, &page.URL, &page.Title, &page.Description, &page.Content, &page.Depth, &page.CrawledAt, &page.Status, &page.ErrorInfo)
+	err := cursor.Scan(&page.ID, &page.Source, &page.URL, &page.Title, &page.Description, &page.Content, &page.Depth, &page.CrawledAt, &page.Status, &page.ErrorInfo, &page.Kind, &page.PublishedAt, &page.ModifiedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -227,68 +383,137 @@ func (db *SQLiteDatabase) GetDocumentByID(ctx context.Context, id int64) (*Page,
 }
 
 type RawResult struct {
-	Rank        float64
 	URL         string
 	Title       string
 	Description string
 	Content     string
+	PublishedAt *string
+	ModifiedAt  *string
 }
 
-var re = regexp.MustCompile(`\W`)
+func (db *SQLiteDatabase) Search(ctx context.Context, sources []string, search string, options SearchOptions, page uint32, pageSize uint32) ([]FTSResult, *uint32, error) {
 
-func escape(searchTerm string) string {
-	// Split the search term into individual words (this step also removes double quotes from the input)
-	words := re.Split(searchTerm, -1)
-
-	var filtered = make([]string, 0, len(words))
-	for _, word := range words {
-		if len(word) != 0 {
-			filtered = append(filtered, word)
-		}
+	compiled, err := compileQuery(search, db.tokenizer, synonymsFor(sources, options)...)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Surround each word with double quotes and add a * to match partial words at the end of the query
-	quoted := fmt.Sprintf("\"%s\"*", strings.Join(filtered, "\" \""))
-	return quoted
-}
-
-func (db *SQLiteDatabase) Search(ctx context.Context, sources []string, search string, page uint32, pageSize uint32) ([]FTSResult, *uint32, error) {
-
 	start := uuid.New().String()
 	end := uuid.New().String()
 
-	query := fmt.Sprintf(`
-		SELECT 
-			pages_fts.rank,
-			pages_fts.url,
-			highlight(pages_fts, 1, ?, ?) AS title,
-			snippet(pages_fts, 2, ?, ?, '…', 8) AS description,
-			snippet(pages_fts, 3, ?, ?, '…', 24) AS content
-		FROM pages
-		JOIN pages_fts ON pages.id = pages_fts.rowid
+	filter, filterArgs := filterConditions("pages.", options, "")
+
+	pinned, hidden, err := db.findCurations(ctx, sources, search)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error finding curations: %v", err)
+	}
+	pinnedPages, err := db.getPinnedPages(ctx, pinned, compiled.filter+filter, slices.Concat(compiled.filterArgs, filterArgs))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error finding pinned pages: %v", err)
+	}
+	// Pinned pages are shown before the other results, so they're excluded from the full-text search to avoid duplicates
+	exclude, excludeArgs := excludeURLs(slices.Concat(hidden, pinned))
+
+	where := fmt.Sprintf(`
 		WHERE pages.source IN (%s)
 			AND pages.status = ?
 			AND pages_fts MATCH ?
-		ORDER BY bm25(pages_fts, 1.0, 3.0, 0.8, 1.0) LIMIT ? OFFSET ?;
-		`, strings.Repeat("?, ", len(sources)-1)+"?")
+			%s %s %s`, strings.Repeat("?, ", len(sources)-1)+"?", compiled.filter, filter, exclude)
 
-	// Convert the sources (a []string) into a slice of type []any by manually copying each element
-	var args []any = make([]any, 0, len(sources)+8)
+	order := ""
+	if options.Sort == SortDate {
+		// Pages without a date are sorted last, and pages with the same date are sorted by relevance
+		order = "date DESC NULLS LAST, "
+	}
 
-	// The "start" and "end" tokens are used to highlight search results. They're used in the `highlight` and `snippet` functions in the query.
-	args = append(args, start, end, start, end, start, end)
+	scoreColumns, scoreArgs := sqlScoreColumns(sources, options)
+
+	collapseKey := "id"
+	switch options.Collapse {
+	case CollapseHost:
+		collapseKey = "host"
+	case CollapseDuplicates:
+		collapseKey = "duplicate_group"
+	}
+
+	// Pages that are translations of each other are grouped, and only the best one in each group is shown.
+	// Since the score includes the language boost, this is usually the one in the user's preferred language.
+	// The remaining pages are then collapsed according to `options.Collapse`, keeping the best one in each group.
+	ranked := `
+		WITH scored AS (
+			SELECT
+				pages.id,
+				pages.host,
+				coalesce(pages.alternateGroup, pages.id) AS alternate_group,
+				coalesce(pages.duplicateGroup, pages.id) AS duplicate_group,
+				coalesce(pages.publishedAt, pages.modifiedAt) AS date,
+				` + scoreColumns + `
+			FROM pages
+			JOIN pages_fts ON pages.id = pages_fts.rowid
+			LEFT JOIN page_authority ON page_authority.page = pages.id
+			` + where + `
+		), matches AS (
+			SELECT *, row_number() OVER (PARTITION BY alternate_group ORDER BY ` + order + sqlScore + `) AS alternate_rank
+			FROM scored
+		), collapsed AS (
+			SELECT
+				*,
+				row_number() OVER (PARTITION BY ` + collapseKey + ` ORDER BY ` + order + sqlScore + `) AS collapse_rank,
+				count(*) OVER (PARTITION BY ` + collapseKey + `) - 1 AS collapsed_count
+			FROM matches
+			WHERE alternate_rank = 1
+		)`
+
+	snippets := options.Snippets.withDefaults()
+	snippetColumns, snippetArgs := snippets.columns(start, end)
+
+	// Snippets are only generated for the page of results that's returned, so the pages are matched again in the outer query.
+	query := ranked + `
+		SELECT
+			collapsed.bm25_score,
+			collapsed.authority,
+			collapsed.authority_boost,
+			collapsed.depth_boost,
+			collapsed.url_boost,
+			collapsed.language_boost,
+			collapsed.collapsed_count,
+			pages_fts.url,
+			` + snippetColumns + `,
+			pages.publishedAt,
+			pages.modifiedAt,
+			pages.language
+		FROM collapsed
+		JOIN pages ON pages.id = collapsed.id
+		JOIN pages_fts ON pages_fts.rowid = collapsed.id
+		WHERE collapsed.collapse_rank = 1 AND pages_fts MATCH ?
+		ORDER BY ` + order + sqlScore + ` LIMIT ? OFFSET ?;
+		`
+
+	// Convert the sources (a []string) into a slice of type []any by manually copying each element
+	var whereArgs []any = make([]any, 0, len(sources)+2+len(compiled.filterArgs)+len(filterArgs))
 
 	for _, src := range sources {
-		args = append(args, src)
+		whereArgs = append(whereArgs, src)
 	}
 
 	// Add the required status and search term as parameters
-	args = append(args,
+	whereArgs = append(whereArgs,
 		Finished, // (as opposed to the Error or Unindexable states)
-		escape(search),
-		pageSize,
-		(page-1)*pageSize,
+		compiled.match,
 	)
+	whereArgs = append(whereArgs, compiled.filterArgs...)
+	whereArgs = append(whereArgs, filterArgs...)
+	whereArgs = append(whereArgs, excludeArgs...)
+
+	// Pinned pages come first, so they shift the other results back
+	offset := (page - 1) * pageSize
+	pinnedCount := uint32(len(pinnedPages))
+	firstPinned, lastPinned := min(offset, pinnedCount), min(offset+pageSize, pinnedCount)
+
+	// The "start" and "end" tokens are used to highlight search results. They're used in the `highlight` and `snippet` functions in the query.
+	args := slices.Concat(scoreArgs, whereArgs)
+	args = append(args, snippetArgs...)
+	args = append(args, compiled.match, pageSize-(lastPinned-firstPinned), offset-firstPinned)
 
 	rows, err := db.conn.QueryContext(ctx, query, args...)
 
@@ -298,20 +523,43 @@ func (db *SQLiteDatabase) Search(ctx context.Context, sources []string, search s
 
 	var results []FTSResult
 
+	for _, p := range pinnedPages[firstPinned:lastPinned] {
+		results = append(results, FTSResult{
+			URL:         p.url,
+			Title:       []Match{{Content: p.title}},
+			Description: []Match{{Content: p.description}},
+			Content:     pinnedSnippet(p.content),
+			PublishedAt: p.publishedAt,
+			ModifiedAt:  p.modifiedAt,
+			Pinned:      true,
+		})
+	}
+
 	for rows.Next() {
 		item := &RawResult{}
-		err := rows.Scan(&item.Rank, &item.URL, &item.Title, &item.Description, &item.Content)
+		score := &ScoreExplanation{}
+		var language *string
+		var collapsed uint32
+		err := rows.Scan(&score.BM25, &score.Authority, &score.AuthorityBoost, &score.DepthBoost, &score.URLBoost, &score.LanguageBoost, &collapsed, &item.URL, &item.Title, &item.Description, &item.Content, &item.PublishedAt, &item.ModifiedAt, &language)
 		if err != nil {
 			return nil, nil, err
 		}
+		score.Score = score.BM25 * score.AuthorityBoost * score.DepthBoost * score.URLBoost * score.LanguageBoost
 
 		// Process the result to convert strings into `Match` instances
 		res := &FTSResult{
-			Rank:        item.Rank,
+			Rank:        score.Score,
 			URL:         item.URL,
 			Title:       processResult(item.Title, start, end),
 			Description: processResult(item.Description, start, end),
-			Content:     processResult(item.Content, start, end),
+			Content:     snippets.contentMatches(item.Content, start, end),
+			PublishedAt: item.PublishedAt,
+			ModifiedAt:  item.ModifiedAt,
+			Language:    language,
+			Collapsed:   collapsed,
+		}
+		if options.Explain {
+			res.Explanation = score
 		}
 
 		results = append(results, *res)
@@ -319,17 +567,155 @@ func (db *SQLiteDatabase) Search(ctx context.Context, sources []string, search s
 
 	var total *uint32
 	{
-		cursor := db.conn.QueryRowContext(
-			ctx, fmt.Sprintf("SELECT COUNT(*) FROM pages JOIN pages_fts ON pages.rowid = pages_fts.rowid WHERE pages.source IN (%s) AND pages.status = ? AND pages_fts MATCH ?", strings.Repeat("?, ", len(sources)-1)+"?"), args[6:8+len(sources)]...)
+		// Each group of translations and collapsed results is counted once
+		cursor := db.conn.QueryRowContext(ctx, ranked+" SELECT count(*) FROM collapsed WHERE collapse_rank = 1;", slices.Concat(scoreArgs, whereArgs)...)
 		err := cursor.Scan(&total)
 		if err != nil {
 			return nil, nil, err
 		}
+		*total += pinnedCount
 	}
 
 	return results, total, nil
 }
 
+// Returns SQL conditions (starting with "AND") that apply the filters in `options` to a query.
+// `prefix` is prepended to each column name, and the filter for the column named by `omit` is skipped.
+func filterConditions(prefix string, options SearchOptions, omit string) (string, []any) {
+	conditions := ""
+	args := []any{}
+	for _, f := range []struct {
+		column string
+		value  string
+	}{
+		{"host", options.Host},
+		{"section", options.Section},
+		{"kind", options.Kind},
+		{"language", options.Language},
+	} {
+		if f.value == "" || f.column == omit {
+			continue
+		}
+		conditions += " AND " + prefix + f.column + " = ?"
+		args = append(args, f.value)
+	}
+	if options.DuplicatesOf != "" {
+		// Group IDs are page IDs, so they're unique across sources
+		conditions += " AND coalesce(" + prefix + "duplicateGroup, " + prefix + "id) IN (SELECT coalesce(duplicateGroup, id) FROM pages AS original WHERE original.url = ?)"
+		args = append(args, options.DuplicatesOf)
+	}
+	// Date ranges aren't shown as facets, so they're never omitted
+	date := "coalesce(" + prefix + "publishedAt, " + prefix + "modifiedAt)"
+	if options.After != nil {
+		conditions += " AND " + date + " >= ?"
+		args = append(args, formatTime(options.After))
+	}
+	if options.Before != nil {
+		conditions += " AND " + date + " < ?"
+		args = append(args, formatTime(options.Before))
+	}
+	return conditions, args
+}
+
+// The maximum number of values returned for facets that can have many values, like hosts and sections
+const maxFacetValues = 10
+
+func (db *SQLiteDatabase) Facets(ctx context.Context, sources []string, search string, options SearchOptions) (*Facets, error) {
+	compiled, err := compileQuery(search, db.tokenizer, synonymsFor(sources, options)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hidden pages aren't counted. Pinned pages are only counted if they match the query.
+	_, hidden, err := db.findCurations(ctx, sources, search)
+	if err != nil {
+		return nil, fmt.Errorf("error finding curations: %v", err)
+	}
+	exclude, excludeArgs := excludeURLs(hidden)
+
+	args := make([]any, 0, len(sources)+2+len(compiled.filterArgs)+len(excludeArgs))
+	for _, src := range sources {
+		args = append(args, src)
+	}
+	args = append(args, Finished, compiled.match)
+	args = append(args, compiled.filterArgs...)
+	args = append(args, excludeArgs...)
+
+	conditions := make([]string, 0, 5)
+	for _, group := range []string{"source", "host", "section", "kind", "language"} {
+		condition, conditionArgs := filterConditions("", options, group)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	// Find all matching pages once, and then count them in each group. Each group ignores its own filter.
+	query := fmt.Sprintf(`
+		WITH matches AS MATERIALIZED (
+			SELECT pages.id, pages.source, pages.host, pages.section, pages.kind, pages.language, pages.publishedAt, pages.modifiedAt, pages.duplicateGroup
+			FROM pages
+			JOIN pages_fts ON pages.id = pages_fts.rowid
+			WHERE pages.source IN (%s)
+				AND pages.status = ?
+				AND pages_fts MATCH ?
+				%s %s
+		)
+		SELECT 'source', source, count(*) FROM matches WHERE true %s GROUP BY source
+		UNION ALL SELECT 'host', host, count(*) FROM matches WHERE true %s GROUP BY host
+		UNION ALL SELECT 'section', section, count(*) FROM matches WHERE true %s GROUP BY section
+		UNION ALL SELECT 'kind', kind, count(*) FROM matches WHERE true %s GROUP BY kind
+		UNION ALL SELECT 'language', language, count(*) FROM matches WHERE language IS NOT NULL %s GROUP BY language;
+	`, strings.Repeat("?, ", len(sources)-1)+"?", compiled.filter, exclude, conditions[0], conditions[1], conditions[2], conditions[3], conditions[4])
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := &Facets{
+		Sources:   []FacetValue{},
+		Hosts:     []FacetValue{},
+		Sections:  []FacetValue{},
+		Kinds:     []FacetValue{},
+		Languages: []FacetValue{},
+	}
+
+	for rows.Next() {
+		var group string
+		var value FacetValue
+		if err := rows.Scan(&group, &value.Value, &value.Count); err != nil {
+			return nil, err
+		}
+		switch group {
+		case "source":
+			facets.Sources = append(facets.Sources, value)
+		case "host":
+			facets.Hosts = append(facets.Hosts, value)
+		case "section":
+			facets.Sections = append(facets.Sections, value)
+		case "kind":
+			facets.Kinds = append(facets.Kinds, value)
+		case "language":
+			facets.Languages = append(facets.Languages, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, values := range []*[]FacetValue{&facets.Sources, &facets.Hosts, &facets.Sections, &facets.Kinds, &facets.Languages} {
+		// Show the most common values first
+		slices.SortStableFunc(*values, func(a, b FacetValue) int {
+			return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Value, b.Value))
+		})
+	}
+	facets.Hosts = facets.Hosts[:min(len(facets.Hosts), maxFacetValues)]
+	facets.Sections = facets.Sections[:min(len(facets.Sections), maxFacetValues)]
+	facets.Languages = facets.Languages[:min(len(facets.Languages), maxFacetValues)]
+
+	return facets, nil
+}
+
 // SQLite FTS5 queries support a `highlight` function which surrounds exact matches with strings.
 // This function converts the string representation into a struct so that the caller does not have to perform any manual parsing.
 func processResult(input string, start string, end string) []Match {
@@ -373,13 +759,16 @@ func processResult(input string, start string, end string) []Match {
 	}
 }
 
-func (db *SQLiteDatabase) SimilaritySearch(ctx context.Context, sourceID string, query []float32, limit int) ([]SimilarityResult, error) {
+func (db *SQLiteDatabase) SimilaritySearch(ctx context.Context, sourceID string, query []float32, limit int) ([]SimilarityResult, *uint32, error) {
 
 	serialized, err := vec.SerializeFloat32(query)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	// Enough chunks are fetched to find `limit` pages, plus `similarityLookahead` more pages to estimate the total.
+	// sqlite-vec can't return more than `maxKNN` chunks, so very deep pages may have fewer results.
+	k := min((limit+similarityLookahead)*chunksPerResult, maxKNN)
 	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
 	SELECT pages_vec_%s.distance, pages.url, pages.title, vec_chunks.chunk FROM pages_vec_%s
 	JOIN vec_chunks USING (id)
@@ -388,28 +777,52 @@ func (db *SQLiteDatabase) SimilaritySearch(ctx context.Context, sourceID string,
 		pages_vec_%s.embedding MATCH ? AND
 		pages.status = ? AND
 		k = ?
-	ORDER BY pages_vec_%s.distance
-	LIMIT ?;
-	`, sourceID, sourceID, sourceID, sourceID), serialized, Finished, limit, limit)
+	ORDER BY pages_vec_%s.distance, pages.url, vec_chunks.id;
+	`, sourceID, sourceID, sourceID, sourceID), serialized, Finished, k)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer rows.Close()
 
+	// Each page is returned once, with its closest chunk, which is the first one because the rows are ordered by distance
 	results := make([]SimilarityResult, 0)
+	pages := make(map[string]struct{})
 
 	for rows.Next() {
-		res := SimilarityResult{}
-		err := rows.Scan(&res.Similarity, &res.URL, &res.Title, &res.Chunk)
+		res := SimilarityResult{Source: sourceID}
+		var distance float32
+		err := rows.Scan(&distance, &res.URL, &res.Title, &res.Chunk)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if _, ok := pages[res.URL]; ok {
+			continue
 		}
+		pages[res.URL] = struct{}{}
+		if len(results) >= limit {
+			continue
+		}
+		res.Similarity = 1 - distance
+		res.Distance = &distance
 		results = append(results, res)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	return results, err
+	// The total is the number of pages among the fetched chunks. It's approximate, since pages whose chunks weren't fetched aren't counted,
+	// but every page that it counts can be reached, because later pages fetch more chunks.
+	total := uint32(len(pages))
+	return results, &total, nil
 }
 
+// The number of pages past the requested ones that similarity searches look for, so that the total shows whether there are more results
+const similarityLookahead = 100
+
+// The largest number of nearest neighbors that sqlite-vec returns from one KNN query
+const maxKNN = 4096
+
 var tmpl *template.Template = template.Must(template.New("hybrid-search").Parse(`
 WITH {{ range $index, $value := .VecSources -}}
 	vec_subquery_{{ $value }} AS (
@@ -420,7 +833,8 @@ WITH {{ range $index, $value := .VecSources -}}
 			distance
 		FROM pages_vec_{{ $value }}
 		JOIN vec_chunks USING (id)
-		WHERE embedding MATCH ? AND k = ?
+		JOIN pages ON pages.id = vec_chunks.page
+		WHERE embedding MATCH ? AND k = ? {{- $.Filter }}
 		-- Select only the most relevant chunk for each page
 		GROUP BY vec_chunks.page
 		HAVING MIN(distance)
@@ -428,12 +842,11 @@ WITH {{ range $index, $value := .VecSources -}}
 ), {{ end }}fts_subquery AS (
 	SELECT
 		pages_fts.rowid AS page,
-		highlight(pages_fts, 1, ?, ?) AS title,
-		snippet(pages_fts, 2, ?, ?, '…', 8) AS description,
-		snippet(pages_fts, 3, ?, ?, '…', 24) AS content,
-		rank
+		{{ .SnippetColumns }},
+		{{ .ScoreColumns }}
 	FROM pages_fts
 	JOIN pages ON pages.id = pages_fts.rowid
+	LEFT JOIN page_authority ON page_authority.page = pages.id
 	WHERE
 		pages.source IN (
 			{{- range $index, $value := .FTSSources -}}
@@ -442,10 +855,11 @@ WITH {{ range $index, $value := .VecSources -}}
 			{{- end -}}
 		)
 		AND pages.status = ?
-		AND pages_fts MATCH ?
+		AND pages_fts MATCH ? {{- .Filter }}
+	ORDER BY {{ .Score }}
 	LIMIT ?
 ), fts_ordered AS (
-	SELECT *, row_number() OVER (ORDER BY rank) AS rank_number
+	SELECT *, row_number() OVER (ORDER BY {{ .Score }}) AS rank_number
 	FROM fts_subquery
 )
 SELECT
@@ -485,12 +899,21 @@ SELECT
 	{{ end -}}
 
 	fts_ordered.rank_number AS fts_rank,
+	fts_ordered.bm25_score,
+	fts_ordered.authority,
+	fts_ordered.authority_boost,
+	fts_ordered.depth_boost,
+	fts_ordered.url_boost,
+	fts_ordered.language_boost,
+	pages.language,
+	pages.alternateGroup,
+	coalesce(1.0 / ({{ .FTSConstant }} + fts_ordered.rank_number), 0.0) AS fts_contribution,
 	(
 		{{ range $index, $value := .VecSources -}}
-			coalesce(1.0 / (60 + vec_subquery_{{ $value }}.rank_number) * 0.5, 0.0) +
+			coalesce(? / (? + vec_subquery_{{ $value }}.rank_number), 0.0) +
 		{{ end -}}
-	coalesce(1.0 / (60 + fts_ordered.rank_number), 0.0)
-	) AS combined_rank
+	0.0
+	) AS vec_contribution
 FROM fts_ordered
 {{ range $index, $value := .VecSources -}}
 	FULL OUTER JOIN vec_subquery_{{ $value }} USING (page)
@@ -505,10 +928,14 @@ JOIN pages ON pages.id = {{ if eq (len .VecSources) 0 -}}
 		{{- end -}}
 	)
 {{ end }}
-ORDER BY combined_rank DESC;
+ORDER BY fts_contribution + vec_contribution DESC, pages.url;
 `))
 
-func (db *SQLiteDatabase) HybridSearch(ctx context.Context, sources []string, queryString string, embeddedQueries map[string][]float32, limit int) ([]HybridResult, error) {
+// The number of results that HybridSearch fetches from the full-text and vector searches before combining them.
+// It's the same for every page of results, so that the combined ranking (and therefore the order of the pages) doesn't change between requests.
+const hybridCandidates = 100
+
+func (db *SQLiteDatabase) HybridSearch(ctx context.Context, sources []string, queryString string, options SearchOptions, embeddedQueries map[string][]float32, page uint32, pageSize uint32) ([]HybridResult, *uint32, error) {
 
 	// Convert the query vectors to a blob format that `sqlite-vec` will accept
 	serializedQueries := make(map[string][]byte)
@@ -516,7 +943,7 @@ func (db *SQLiteDatabase) HybridSearch(ctx context.Context, sources []string, qu
 	for sourceID, query := range embeddedQueries {
 		serialized, err := vec.SerializeFloat32(query)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		serializedQueries[sourceID] = serialized
 	}
@@ -526,59 +953,170 @@ func (db *SQLiteDatabase) HybridSearch(ctx context.Context, sources []string, qu
 		FTSSources []string
 		// The sources to use in the vector search
 		VecSources []string
+		// Extra conditions that apply to both searches
+		Filter string
+		// The columns that make up each page's full-text search score (see `sqlScoreColumns`), and the expression that combines them
+		ScoreColumns string
+		Score        string
+		// An expression for the reciprocal rank fusion constant of each page's source
+		FTSConstant string
+		// The title, description, and content columns (see SnippetOptions.columns)
+		SnippetColumns string
 	}
 
-	var query bytes.Buffer
+	compiled, err := compileQuery(queryString, db.tokenizer, synonymsFor(sources, options)...)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// FTSSources and VecSources are separated to allow combining searches from sources that do and don't contain vector indexes
-	err := tmpl.Execute(&query, TemplateData{FTSSources: sources, VecSources: slices.Collect(maps.Keys(embeddedQueries))})
+	filter, filterArgs := filterConditions("pages.", options, "")
+
+	pinned, hidden, err := db.findCurations(ctx, sources, queryString)
 	if err != nil {
-		return nil, fmt.Errorf("error formatting query: %v", err)
+		return nil, nil, fmt.Errorf("error finding curations: %v", err)
 	}
+	pinnedPages, err := db.getPinnedPages(ctx, pinned, compiled.filter+filter, slices.Concat(compiled.filterArgs, filterArgs))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error finding pinned pages: %v", err)
+	}
+	// Pinned pages are shown before the other results, so they're excluded from both searches to avoid duplicates
+	exclude, excludeArgs := excludeURLs(slices.Concat(hidden, pinned))
+	filter += exclude
+	filterArgs = append(filterArgs, excludeArgs...)
 
-	args := []any{}
+	scoreColumns, scoreArgs := sqlScoreColumns(sources, options)
+	ftsConstant, ftsConstantArgs := sqlPerSource(sources, options.Ranking, func(r Ranking) float64 { return r.RRFConstant })
 
-	// Vector query args
+	// Keep the vector sources in the same order as `sources` so that they line up with the query args
+	vecSources := make([]string, 0, len(serializedQueries))
 	for _, src := range sources {
 		if serializedQueries[src] != nil {
-			args = append(args, serializedQueries[src], limit)
+			vecSources = append(vecSources, src)
 		}
 	}
 
-	// FTS query args
+	// The "start" and "end" tokens are used to highlight search results
 	start := uuid.New().String()
 	end := uuid.New().String()
+	snippets := options.Snippets.withDefaults()
+	snippetColumns, snippetArgs := snippets.columns(start, end)
+
+	var query bytes.Buffer
+
+	// FTSSources and VecSources are separated to allow combining searches from sources that do and don't contain vector indexes
+	err = tmpl.Execute(&query, TemplateData{
+		FTSSources:     sources,
+		VecSources:     vecSources,
+		Filter:         compiled.filter + filter,
+		ScoreColumns:   scoreColumns,
+		Score:          sqlScore,
+		FTSConstant:    ftsConstant,
+		SnippetColumns: snippetColumns,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error formatting query: %v", err)
+	}
+
+	args := []any{}
 
-	args = append(args, start, end, start, end, start, end)
+	// Later pages are still ranked among the same candidates, unless they go past them
+	limit := max(hybridCandidates, page*pageSize)
+
+	// Vector query args
+	for _, src := range vecSources {
+		args = append(args, serializedQueries[src], limit)
+		args = append(args, compiled.filterArgs...)
+		args = append(args, filterArgs...)
+	}
+
+	// FTS query args
+	args = append(args, snippetArgs...)
+	args = append(args, scoreArgs...)
 
 	for _, src := range sources {
 		args = append(args, src)
 	}
 
-	args = append(args, Finished, escape(queryString), limit)
+	args = append(args, Finished, compiled.match)
+	args = append(args, compiled.filterArgs...)
+	args = append(args, filterArgs...)
+	args = append(args, limit)
+
+	// Reciprocal rank fusion args
+	args = append(args, ftsConstantArgs...)
+	for _, src := range vecSources {
+		r := rankingFor(options.Ranking, src)
+		args = append(args, r.VectorWeight, r.RRFConstant)
+	}
 
 	rows, err := db.conn.QueryContext(ctx, query.String(), args...)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	results := make([]HybridResult, 0, len(pinnedPages))
+
+	for _, p := range pinnedPages {
+		results = append(results, HybridResult{
+			URL:         p.url,
+			Title:       []Match{{Content: p.title}},
+			Description: []Match{{Content: p.description}},
+			Content:     pinnedSnippet(p.content),
+			Pinned:      true,
+		})
 	}
 
-	results := make([]HybridResult, 0)
-
+	seenGroups := map[string]struct{}{}
 	for rows.Next() {
 		res := HybridResult{}
 		var title, description, content string
-		err := rows.Scan(&res.URL, &title, &description, &content, &res.VecDistance, &res.VecRank, &res.FTSRank, &res.HybridRank)
+		// The score columns are NULL for pages that only matched the vector search
+		var bm25, authority, authorityBoost, depthBoost, urlBoost, languageBoost *float64
+		var group *string
+		explanation := &HybridExplanation{}
+		err := rows.Scan(&res.URL, &title, &description, &content, &res.VecDistance, &res.VecRank, &res.FTSRank, &bm25, &authority, &authorityBoost, &depthBoost, &urlBoost, &languageBoost, &res.Language, &group, &explanation.FTSContribution, &explanation.VecContribution)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		// Only the best-ranked page in each group of translations is shown
+		if group != nil {
+			if _, ok := seenGroups[*group]; ok {
+				continue
+			}
+			seenGroups[*group] = struct{}{}
+		}
+		res.HybridRank = explanation.FTSContribution + explanation.VecContribution
+		if options.Explain {
+			if bm25 != nil {
+				explanation.FTS = &ScoreExplanation{
+					BM25:           *bm25,
+					Authority:      *authority,
+					AuthorityBoost: *authorityBoost,
+					DepthBoost:     *depthBoost,
+					URLBoost:       *urlBoost,
+					LanguageBoost:  *languageBoost,
+					Score:          *bm25 * *authorityBoost * *depthBoost * *urlBoost * *languageBoost,
+				}
+			}
+			res.Explanation = explanation
 		}
 		res.Title = processResult(title, start, end)
 		res.Description = processResult(description, start, end)
-		res.Content = processResult(content, start, end)
+		res.Content = snippets.contentMatches(content, start, end)
 		results = append(results, res)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	return results, err
+	// Every candidate is ranked to combine the searches, so the page of results is taken from the combined list.
+	// The total is approximate, since pages that weren't among the candidates aren't counted.
+	total := uint32(len(results))
+	first := min((page-1)*pageSize, total)
+	last := min(page*pageSize, total)
+	return results[first:last], &total, nil
 }
 
 func (db *SQLiteDatabase) AddToQueue(ctx context.Context, source string, referrer string, urls []string, depth int32, isRefresh bool) error {
@@ -662,12 +1200,12 @@ func (db *SQLiteDatabase) PopQueue(ctx context.Context, source string) (*QueueIt
 	// Find the first item in the queue and update it in one step. If the row isn't returned, another process must have updated it at the same time.
 	row := db.conn.QueryRowContext(ctx, `
 	  UPDATE crawl_queue SET status = ?, updatedAt = CURRENT_TIMESTAMP WHERE rowid = (
-	    SELECT rowid FROM crawl_queue WHERE status = ? AND source = ? ORDER BY addedAt LIMIT 1
-	  ) RETURNING id, source, url, status, depth, isRefresh, addedAt, updatedAt;
+	    SELECT rowid FROM crawl_queue WHERE status = ? AND source = ? AND (nextAttemptAt IS NULL OR nextAttemptAt <= CURRENT_TIMESTAMP) ORDER BY addedAt LIMIT 1
+	  ) RETURNING id, source, url, status, depth, isRefresh, addedAt, updatedAt, attempts;
 	`, Processing, Pending, source)
 
 	item := &QueueItem{}
-	err := row.Scan(&item.ID, &item.Source, &item.URL, &item.Status, &item.Depth, &item.IsRefresh, &item.AddedAt, &item.UpdatedAt)
+	err := row.Scan(&item.ID, &item.Source, &item.URL, &item.Status, &item.Depth, &item.IsRefresh, &item.AddedAt, &item.UpdatedAt, &item.Attempts)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -785,6 +1323,19 @@ func (db *SQLiteDatabase) UpdateQueueEntry(ctx context.Context, id int64, status
 	}
 }
 
+func (db *SQLiteDatabase) RetryQueueEntry(ctx context.Context, id int64, delay time.Duration) error {
+	// The time is in the same format as CURRENT_TIMESTAMP so that PopQueue can compare them
+	_, err := db.conn.ExecContext(ctx, "UPDATE crawl_queue SET status = ?, attempts = attempts + 1, nextAttemptAt = datetime('now', ?), updatedAt = CURRENT_TIMESTAMP WHERE id = ?;",
+		Pending, delayModifier(delay), id)
+	return err
+}
+
+func (db *SQLiteDatabase) PostponeQueueEntry(ctx context.Context, id int64, delay time.Duration) error {
+	_, err := db.conn.ExecContext(ctx, "UPDATE crawl_queue SET status = ?, nextAttemptAt = datetime('now', ?), updatedAt = CURRENT_TIMESTAMP WHERE id = ?;",
+		Pending, delayModifier(delay), id)
+	return err
+}
+
 func (db *SQLiteDatabase) UpdateEmbedQueueEntry(ctx context.Context, id int64, status QueueItemStatus) error {
 	if status == Finished {
 		// If the item is finished, it can be immediately deleted
@@ -811,7 +1362,13 @@ func (db *SQLiteDatabase) Cleanup(ctx context.Context) error {
 		-- Remove embeddings which aren't linked to a page
 		-- This should never happen because of the foreign key, but it seems to occur on rare occasion
 		DELETE FROM embed_queue WHERE page NOT IN (SELECT id FROM pages);
-		`, Finished, Pending, Processing, Finished, Pending, Error, Processing)
+
+		-- Remove authority scores of pages that no longer exist
+		DELETE FROM page_authority WHERE page NOT IN (SELECT id FROM pages);
+
+		-- Forget queries that haven't become popular after a month
+		DELETE FROM query_log WHERE count < ? AND unixepoch() - unixepoch(lastSearchedAt) > 30 * 24 * 60 * 60;
+		`, Finished, Pending, Processing, Finished, Pending, Error, Processing, minSuggestedQueryCount)
 
 	return err
 }
@@ -852,48 +1409,416 @@ func (db *SQLiteDatabase) StartEmbeddings(ctx context.Context, source string, ch
 	return nil
 }
 
-func (db *SQLiteDatabase) CreateSpellfixIndex(ctx context.Context) error {
+func (db *SQLiteDatabase) UpdateSpellfixIndex(ctx context.Context) error {
 	_, err := db.conn.ExecContext(ctx, `
-		DROP TABLE IF EXISTS tmp_fts_vocab;
-		DROP TABLE IF EXISTS spellfix;
-		CREATE VIRTUAL TABLE tmp_fts_vocab USING fts5vocab('pages_fts', 'row');
-		CREATE VIRTUAL TABLE spellfix USING spellfix1;
-		INSERT INTO spellfix(word, rank) SELECT term, doc FROM tmp_fts_vocab;
-		DROP TABLE tmp_fts_vocab;
+		CREATE VIRTUAL TABLE IF NOT EXISTS spellfix USING spellfix1;
+		-- spellfix1 stores its words in a shadow table, which is indexed so that words whose rank changed can be found without a full scan
+		CREATE INDEX IF NOT EXISTS spellfix_vocab_langid_word ON spellfix_vocab(langid, word);
+
+		-- Make sure every source has a dictionary. This is necessary for databases that were created before per-source dictionaries were added.
+		INSERT OR IGNORE INTO spellfix_sources (source) SELECT DISTINCT source FROM pages;
+
+		-- Remove words that don't belong to any source's dictionary (for example, from a source that was removed)
+		DELETE FROM spellfix WHERE rowid IN (SELECT id FROM spellfix_vocab WHERE langid NOT IN (SELECT langid FROM spellfix_sources));
+		DELETE FROM vocabulary WHERE langid NOT IN (SELECT langid FROM spellfix_sources);
+		DELETE FROM vocabulary_changes WHERE source NOT IN (SELECT source FROM spellfix_sources);
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.conn.QueryContext(ctx, "SELECT langid, source, rebuild FROM spellfix_sources WHERE stale = 1;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type staleSource struct {
+		langid  int64
+		source  string
+		rebuild bool
+	}
+
+	stale := []staleSource{}
+	for rows.Next() {
+		var s staleSource
+		if err := rows.Scan(&s.langid, &s.source, &s.rebuild); err != nil {
+			return err
+		}
+		stale = append(stale, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, s := range stale {
+		update := db.updateVocabulary
+		if s.rebuild {
+			update = db.rebuildVocabulary
+		}
+		if err := update(ctx, s.langid, s.source); err != nil {
+			return fmt.Errorf("error updating spellfix dictionary for source %v: %v", s.source, err)
+		}
+	}
+
+	return nil
+}
+
+// Updates one source's spelling correction dictionary and autocomplete vocabulary with the pages that changed since the last update (see `vocabulary_changes`).
+// The old and new text of the changed pages are tokenized in temporary indexes, so the rest of the source's pages aren't read, and only words whose
+// frequency changed are written to the `spellfix` and `vocabulary` tables.
+func (db *SQLiteDatabase) updateVocabulary(ctx context.Context, langid int64, source string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Temporary tables are only visible to the connection that created them, so all of these statements must run in the same transaction.
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		-- Mark the dictionary as up-to-date first, so that if pages change while it's being updated, it will be marked as stale again
+		UPDATE spellfix_sources SET stale = 0 WHERE langid = ?;
+
+		CREATE VIRTUAL TABLE IF NOT EXISTS temp.vocabulary_old USING fts5(url, title, description, content, detail=none, tokenize='%[1]s');
+		CREATE VIRTUAL TABLE IF NOT EXISTS temp.vocabulary_new USING fts5(url, title, description, content, detail=none, tokenize='%[1]s');
+		CREATE VIRTUAL TABLE IF NOT EXISTS temp.vocabulary_old_terms USING fts5vocab(temp, vocabulary_old, row);
+		CREATE VIRTUAL TABLE IF NOT EXISTS temp.vocabulary_new_terms USING fts5vocab(temp, vocabulary_new, row);
+		CREATE TEMP TABLE IF NOT EXISTS vocabulary_deltas(term TEXT PRIMARY KEY, delta INTEGER NOT NULL);
+		DELETE FROM temp.vocabulary_old;
+		DELETE FROM temp.vocabulary_new;
+		DELETE FROM temp.vocabulary_deltas;
+
+		-- The text that the dictionary counted before the pages changed, and the text that it should count now
+		INSERT INTO temp.vocabulary_old (url, title, description, content)
+			SELECT url, title, description, content FROM vocabulary_changes WHERE source = ? AND counted = 1;
+		INSERT INTO temp.vocabulary_new (url, title, description, content)
+			SELECT pages.url, pages.title, pages.description, pages.content FROM vocabulary_changes JOIN pages ON pages.id = vocabulary_changes.page
+			WHERE vocabulary_changes.source = ? AND pages.status = ?;
+
+		-- A word's rank is the number of this source's pages that contain it, so it changes by the number of changed pages that gained or lost it
+		INSERT INTO temp.vocabulary_deltas (term, delta)
+			SELECT term, sum(docs) FROM (
+				SELECT term, doc AS docs FROM temp.vocabulary_new_terms
+				UNION ALL
+				SELECT term, -doc AS docs FROM temp.vocabulary_old_terms
+			) GROUP BY term HAVING sum(docs) != 0;
+
+		INSERT INTO vocabulary (langid, term, docs)
+			SELECT ?, term, delta FROM temp.vocabulary_deltas WHERE true
+			ON CONFLICT DO UPDATE SET docs = docs + excluded.docs;
+		DELETE FROM vocabulary WHERE langid = ? AND term IN (SELECT term FROM temp.vocabulary_deltas) AND docs <= 0;
+
+		-- Words whose rank changed are removed from the spelling correction dictionary and re-added with their new rank
+		DELETE FROM spellfix WHERE rowid IN (SELECT id FROM spellfix_vocab WHERE langid = ? AND word IN (SELECT term FROM temp.vocabulary_deltas));
+		INSERT INTO spellfix (word, rank, langid)
+			SELECT term, docs, ? FROM vocabulary WHERE langid = ? AND term IN (SELECT term FROM temp.vocabulary_deltas);
+
+		DELETE FROM vocabulary_changes WHERE source = ?;
+		DELETE FROM temp.vocabulary_old;
+		DELETE FROM temp.vocabulary_new;
+		DELETE FROM temp.vocabulary_deltas;
+	`, db.vocabularyTokenizer()), langid, source, source, Finished, langid, langid, langid, langid, langid, source)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Rebuilds one source's spelling correction dictionary and autocomplete vocabulary from all of its pages.
+// This is needed when the changes since the last update weren't recorded, like when the dictionary is first built or the tokenizer changes.
+// Only words that were added, removed, or changed in frequency are written to the `spellfix` and `vocabulary` tables.
+func (db *SQLiteDatabase) rebuildVocabulary(ctx context.Context, langid int64, source string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Temporary tables are only visible to the connection that created them, so all of these statements must run in the same transaction.
+	_, err = tx.ExecContext(ctx, `
+		-- Mark the dictionary as up-to-date first, so that if pages change while it's being updated, it will be marked as stale again
+		UPDATE spellfix_sources SET stale = 0, rebuild = 0 WHERE langid = ?;
+		-- Changes up to this point are included in the rebuilt dictionary
+		DELETE FROM vocabulary_changes WHERE source = ?;
+
+		CREATE TEMP TABLE IF NOT EXISTS spellfix_terms(word TEXT PRIMARY KEY, rank INTEGER NOT NULL);
+		CREATE TEMP TABLE IF NOT EXISTS spellfix_existing(word TEXT PRIMARY KEY, rank INTEGER, id INTEGER NOT NULL);
+		DELETE FROM temp.spellfix_terms;
+		DELETE FROM temp.spellfix_existing;
+
+		-- A word's rank is the number of this source's pages that contain it
+		INSERT INTO temp.spellfix_terms (word, rank)
+			SELECT term, count(DISTINCT doc) FROM pages_vocab_instance
+			WHERE doc IN (SELECT id FROM pages WHERE source = ? AND status = ?)
+			GROUP BY term;
+
+		-- spellfix1 stores its words in a shadow table, which can be read directly to avoid a full scan of the virtual table for each word
+		INSERT OR IGNORE INTO temp.spellfix_existing (word, rank, id) SELECT word, rank, id FROM spellfix_vocab WHERE langid = ?;
+
+		-- Remove words that are no longer used and words whose rank changed
+		DELETE FROM spellfix WHERE rowid IN (
+			SELECT existing.id FROM temp.spellfix_existing existing
+			LEFT JOIN temp.spellfix_terms terms USING (word)
+			WHERE terms.rank IS NOT existing.rank
+		);
+
+		-- Add new words and re-add words whose rank changed
+		INSERT INTO spellfix (word, rank, langid)
+			SELECT terms.word, terms.rank, ? FROM temp.spellfix_terms terms
+			LEFT JOIN temp.spellfix_existing existing USING (word)
+			WHERE terms.rank IS NOT existing.rank;
+
+		-- Apply the same changes to the vocabulary used for autocomplete
+		DELETE FROM vocabulary WHERE langid = ? AND term NOT IN (SELECT word FROM temp.spellfix_terms);
+		INSERT INTO vocabulary (langid, term, docs)
+			SELECT ?, word, rank FROM temp.spellfix_terms WHERE true
+			ON CONFLICT DO UPDATE SET docs = excluded.docs WHERE docs != excluded.docs;
+
+		DELETE FROM temp.spellfix_terms;
+		DELETE FROM temp.spellfix_existing;
+	`, langid, source, source, Finished, langid, langid, langid, langid)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (db *SQLiteDatabase) DropSpellfixIndex(ctx context.Context) error {
 	_, err := db.conn.ExecContext(ctx, `
-		DROP TABLE IF EXISTS tmp_fts_vocab;
 		DROP TABLE IF EXISTS spellfix;
+		UPDATE spellfix_sources SET stale = 1, rebuild = 1;
 	`)
 	return err
 }
 
-func (db *SQLiteDatabase) Spellfix(ctx context.Context, query string) (string, error) {
-	// Split the query into individual words
-	words := re.Split(query, -1)
-	checked := make([]string, 0, len(words))
-	for _, word := range words {
-		trimmed := strings.TrimSpace(word)
-		if len(trimmed) == 0 {
+func (db *SQLiteDatabase) Spellfix(ctx context.Context, sources []string, search string) (string, error) {
+	langids, err := db.getLangIDs(ctx, sources)
+	if err != nil {
+		return "", err
+	}
+
+	// Replace each word in the query while leaving punctuation, operators, and filters intact, so that the corrected query has the same structure as the original
+	checked := query.ReplaceWords(search, func(word string) string {
+		if err != nil {
+			return word
+		}
+
+		// Find the best match in each source's dictionary. A lower score means the word is more similar to the query and/or more common.
+		best := word
+		bestScore := -1
+		for _, langid := range langids {
+			var res string
+			var score int
+			row := db.conn.QueryRowContext(ctx, "SELECT word, score FROM spellfix WHERE word MATCH ? AND langid = ? AND top=1;", word, langid)
+			scanErr := row.Scan(&res, &score)
+			if scanErr == sql.ErrNoRows {
+				continue
+			} else if scanErr != nil {
+				err = scanErr
+				return word
+			}
+			if bestScore == -1 || score < bestScore {
+				best, bestScore = res, score
+			}
+		}
+		return best
+	})
+	if err != nil {
+		return "", err
+	}
+	return checked, nil
+}
+
+// Returns the IDs of the given sources' dictionaries in the `spellfix` and `vocabulary` tables.
+// Sources that don't have any pages yet are omitted.
+func (db *SQLiteDatabase) getLangIDs(ctx context.Context, sources []string) ([]int64, error) {
+	langids := make([]int64, 0, len(sources))
+	for _, src := range sources {
+		var langid int64
+		err := db.conn.QueryRowContext(ctx, "SELECT langid FROM spellfix_sources WHERE source = ?;", src).Scan(&langid)
+		if err == sql.ErrNoRows {
 			continue
+		} else if err != nil {
+			return nil, err
 		}
-		var res string
-		row := db.conn.QueryRowContext(ctx, "SELECT word FROM spellfix WHERE word MATCH ? AND top=1;", trimmed)
-		err := row.Scan(&res)
+		langids = append(langids, langid)
+	}
+	return langids, nil
+}
+
+func (db *SQLiteDatabase) RecordQuery(ctx context.Context, sources []string, search string) error {
+	// Normalize whitespace so that queries that only differ in spacing are counted together
+	search = strings.Join(strings.Fields(search), " ")
+	if search == "" {
+		return nil
+	}
+
+	for _, src := range sources {
+		_, err := db.conn.ExecContext(ctx, `
+			INSERT INTO query_log (source, query) VALUES (?, ?)
+			ON CONFLICT DO UPDATE SET count = count + 1, lastSearchedAt = CURRENT_TIMESTAMP;
+		`, src, search)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				checked = append(checked, trimmed)
-				continue
+			return err
+		}
+	}
+	return nil
+}
+
+// A query must be searched at least this many times before it's suggested to other users.
+// This keeps queries that were only searched once, like typos, out of suggestions. Searches aren't attributed to users, so it
+// doesn't stop one user from making a query popular by searching for it repeatedly.
+const minSuggestedQueryCount = 2
+
+// The maximum number of rows that are read from each index range when suggesting completions and pages.
+// Short prefixes can match most of the index, so the matching rows are capped before they're sorted. When a prefix matches more rows
+// than this, only the first ones in alphabetical order are ranked; the range shrinks and becomes exact as the user types more.
+const maxSuggestionCandidates = 500
+
+// The largest Unicode code point. Appending it to a prefix creates an upper bound for all strings that start with the prefix.
+const maxRune = "\U0010FFFF"
+
+func (db *SQLiteDatabase) Suggest(ctx context.Context, sources []string, prefix string, limit int) ([]Suggestion, error) {
+	prefix = strings.TrimLeft(prefix, " \t")
+	suggestions := []Suggestion{}
+	if prefix == "" || len(sources) == 0 || limit <= 0 {
+		return suggestions, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sources)), ", ")
+	sourceArgs := make([]any, len(sources))
+	for i, src := range sources {
+		sourceArgs[i] = src
+	}
+
+	// Each of these queries reads at most `maxSuggestionCandidates` rows from a range of an index before sorting them, so they stay fast
+	// regardless of how many pages or queries there are.
+	// `query` and the `pages_source_title` index use the NOCASE collation, so the range comparisons are case-insensitive.
+	queries, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT query, sum(count) AS total FROM (
+			SELECT query, count FROM query_log
+			WHERE source IN (%s) AND query >= ? AND query < ? AND query != ?
+			LIMIT ?
+		)
+		GROUP BY query HAVING total >= ?
+		ORDER BY total DESC, query LIMIT ?;
+	`, placeholders), append(sourceArgs, prefix, prefix+maxRune, prefix, maxSuggestionCandidates, minSuggestedQueryCount, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer queries.Close()
+
+	completions := []Suggestion{}
+	for queries.Next() {
+		var text string
+		var count int
+		if err := queries.Scan(&text, &count); err != nil {
+			return nil, err
+		}
+		completions = append(completions, Suggestion{Text: text, Kind: SuggestionQuery})
+	}
+	if err := queries.Err(); err != nil {
+		return nil, err
+	}
+
+	// Complete the last word using the most common words in the sources' pages that start with it
+	if before, word, ok := query.SplitLastWord(prefix); ok {
+		langids, err := db.getLangIDs(ctx, sources)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(langids) > 0 {
+			langidArgs := make([]any, 0, len(langids)+3)
+			for _, id := range langids {
+				langidArgs = append(langidArgs, id)
+			}
+			// The FTS tokenizer stores terms in lowercase
+			word = strings.ToLower(word)
+
+			terms, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+				SELECT term, sum(docs) AS total FROM (
+					SELECT term, docs FROM vocabulary
+					WHERE langid IN (%s) AND term > ? AND term < ?
+					LIMIT ?
+				)
+				GROUP BY term ORDER BY total DESC, term LIMIT ?;
+			`, strings.TrimSuffix(strings.Repeat("?, ", len(langids)), ", ")), append(langidArgs, word, word+maxRune, maxSuggestionCandidates, limit)...)
+			if err != nil {
+				return nil, err
+			}
+			defer terms.Close()
+
+			for terms.Next() {
+				var term string
+				var count int
+				if err := terms.Scan(&term, &count); err != nil {
+					return nil, err
+				}
+				completions = append(completions, Suggestion{Text: before + term, Kind: SuggestionTerm})
+			}
+			if err := terms.Err(); err != nil {
+				return nil, err
 			}
-			return "", err
 		}
-		checked = append(checked, res)
 	}
-	return strings.Join(checked, " "), nil
+
+	pages, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT title, url FROM (
+			SELECT title, url, depth FROM pages
+			WHERE source IN (%s) AND title COLLATE NOCASE >= ? AND title COLLATE NOCASE < ? AND status = ?
+			LIMIT ?
+		)
+		ORDER BY depth, title LIMIT ?;
+	`, placeholders), append(sourceArgs, prefix, prefix+maxRune, Finished, maxSuggestionCandidates, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer pages.Close()
+
+	titles := []Suggestion{}
+	for pages.Next() {
+		var title, url string
+		if err := pages.Scan(&title, &url); err != nil {
+			return nil, err
+		}
+		titles = append(titles, Suggestion{Text: title, Kind: SuggestionPage, URL: url})
+	}
+	if err := pages.Err(); err != nil {
+		return nil, err
+	}
+
+	// Completions are shown first, but some space is reserved for pages so that they aren't crowded out by completions
+	pageCount := min(len(titles), max(limit/3, 1))
+	seen := make(map[string]bool)
+	for _, s := range completions {
+		if len(suggestions) >= limit-pageCount {
+			break
+		}
+		key := strings.ToLower(s.Text)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		suggestions = append(suggestions, s)
+	}
+	for _, s := range titles {
+		if len(suggestions) >= limit {
+			break
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	return suggestions, nil
 }
 
 func (db *SQLiteDatabase) QueuePagesOlderThan(ctx context.Context, source string, daysAgo int32) error {
@@ -924,6 +1849,127 @@ func (db *SQLiteDatabase) QueuePagesOlderThan(ctx context.Context, source string
 	return nil
 }
 
+func (db *SQLiteDatabase) AddFeedItems(ctx context.Context, source string, items []FeedItem) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		publishedAt := formatTime(item.PublishedAt)
+		modifiedAt := formatTime(item.ModifiedAt)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO feed_items (source, url, publishedAt, modifiedAt) VALUES (?, ?, ?, ?)
+				ON CONFLICT DO UPDATE SET publishedAt = coalesce(excluded.publishedAt, publishedAt), modifiedAt = coalesce(excluded.modifiedAt, modifiedAt);
+			-- If the page was crawled before the feed, update its kind now
+			UPDATE pages SET kind = ? WHERE source = ? AND url = ? AND kind = ?;
+			-- Fill in dates that the page didn't specify itself
+			UPDATE pages SET publishedAt = coalesce(publishedAt, ?), modifiedAt = coalesce(modifiedAt, ?) WHERE source = ? AND url = ?;
+		`, source, item.URL, publishedAt, modifiedAt, KindFeedItem, source, item.URL, KindPage, publishedAt, modifiedAt, source, item.URL)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return rbErr
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *SQLiteDatabase) AddSitemapEntries(ctx context.Context, source string, entries []SitemapEntry) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.LastModified == nil {
+			continue
+		}
+		lastModified := formatTime(entry.LastModified)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO sitemap_entries (source, url, lastModified) VALUES (?, ?, ?)
+				ON CONFLICT DO UPDATE SET lastModified = excluded.lastModified;
+			-- Fill in the date if the page was crawled before the sitemap and didn't specify it
+			UPDATE pages SET modifiedAt = ? WHERE source = ? AND url = ? AND modifiedAt IS NULL;
+		`, source, entry.URL, lastModified, lastModified, source, entry.URL)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return rbErr
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Formats a time as an RFC 3339 timestamp in UTC, which sorts chronologically when compared as a string.
+// Returns nil (which is stored as NULL) if the time is nil.
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	str := t.UTC().Format(time.RFC3339)
+	return &str
+}
+
+// Formats a delay as an SQLite date modifier, like "+90 seconds", rounded up to the next second
+func delayModifier(delay time.Duration) string {
+	return fmt.Sprintf("+%d seconds", int64(math.Ceil(delay.Seconds())))
+}
+
+// Returns a string that's stored as NULL if it's empty
+func nullString(s string) sql.Null[string] {
+	return sql.Null[string]{V: s, Valid: s != ""}
+}
+
+// Returns the URL that identifies a group of pages that are translations of each other, which is the first of their URLs in alphabetical order.
+// If every page in the group links to the others, they all get the same group. Returns nil if the page doesn't have any alternates.
+func alternateGroup(url string, alternates []string) *string {
+	if len(alternates) == 0 || (len(alternates) == 1 && alternates[0] == url) {
+		return nil
+	}
+	group := slices.Min(append(slices.Clone(alternates), url))
+	return &group
+}
+
+// Finds an indexed page in the source whose content is a near-duplicate of a page with the given fingerprint,
+// and returns the ID that identifies their group of duplicates (the ID of the first page in the group).
+func findDuplicateGroup(ctx context.Context, tx *sql.Tx, source string, url string, f uint64) (sql.Null[int64], error) {
+	// Each band is looked up separately so that the query can use the band's index
+	bands := fingerprintBandValues(f)
+	queries := make([]string, len(bands))
+	args := []any{}
+	for i, band := range bands {
+		queries[i] = fmt.Sprintf("SELECT id FROM pages WHERE source = ? AND fingerprintBand%d = ?", i)
+		args = append(args, source, band)
+	}
+	args = append(args, url, Finished)
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT coalesce(duplicateGroup, id), fingerprint FROM pages
+		WHERE id IN (`+strings.Join(queries, " UNION ")+`) AND url != ? AND status = ? AND fingerprint IS NOT NULL
+		ORDER BY id;
+	`, args...)
+	if err != nil {
+		return sql.Null[int64]{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group, other int64
+		if err := rows.Scan(&group, &other); err != nil {
+			return sql.Null[int64]{}, err
+		}
+		if isNearDuplicate(f, uint64(other)) {
+			return sql.Null[int64]{V: group, Valid: true}, nil
+		}
+	}
+	return sql.Null[int64]{}, rows.Err()
+}
+
 func (db *SQLiteDatabase) GetCanonical(ctx context.Context, source string, url string) (*Canonical, error) {
 	cursor := db.conn.QueryRowContext(ctx, "SELECT id, url, canonical, crawledAt FROM canonicals WHERE source = ? AND url = ?", source, url)
 
@@ -958,5 +2004,5 @@ func SQLiteFromFile(fileName string) (*SQLiteDatabase, error) {
 }
 
 func SQLite(conn *sql.DB) (*SQLiteDatabase, error) {
-	return &SQLiteDatabase{conn}, nil
+	return &SQLiteDatabase{conn: conn, tokenizer: DefaultTokenizer}, nil
 }
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Converts a query or pattern into the form that curations are compared in
+func normalizeCurationQuery(str string) string {
+	return strings.Join(strings.Fields(strings.ToLower(str)), " ")
+}
+
+func (db *SQLiteDatabase) ListCurations(ctx context.Context, source string) ([]Curation, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT id FROM curations WHERE source = ? ORDER BY id;", source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	curations := make([]Curation, 0, len(ids))
+	for _, id := range ids {
+		curation, err := db.GetCuration(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		curations = append(curations, *curation)
+	}
+	return curations, nil
+}
+
+func (db *SQLiteDatabase) GetCuration(ctx context.Context, id int64) (*Curation, error) {
+	curation := &Curation{ID: id, Pinned: []string{}, Hidden: []string{}}
+	err := db.conn.QueryRowContext(ctx, "SELECT source, pattern, matchType FROM curations WHERE id = ?;", id).Scan(&curation.Source, &curation.Pattern, &curation.Match)
+	if err == sql.ErrNoRows {
+		return nil, ErrCurationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.QueryContext(ctx, "SELECT url, pinned FROM curation_urls WHERE curation = ? ORDER BY position;", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url string
+		var pinned bool
+		if err := rows.Scan(&url, &pinned); err != nil {
+			return nil, err
+		}
+		if pinned {
+			curation.Pinned = append(curation.Pinned, url)
+		} else {
+			curation.Hidden = append(curation.Hidden, url)
+		}
+	}
+
+	return curation, nil
+}
+
+func (db *SQLiteDatabase) SaveCuration(ctx context.Context, curation Curation) (int64, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	rollback := func(err error) (int64, error) {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return 0, rbErr
+		}
+		return 0, err
+	}
+
+	id := curation.ID
+	pattern := normalizeCurationQuery(curation.Pattern)
+	if id == 0 {
+		err := tx.QueryRowContext(ctx, "INSERT INTO curations (source, pattern, matchType) VALUES (?, ?, ?) RETURNING id;", curation.Source, pattern, curation.Match).Scan(&id)
+		if err != nil {
+			return rollback(err)
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, "UPDATE curations SET source = ?, pattern = ?, matchType = ? WHERE id = ?;", curation.Source, pattern, curation.Match, id)
+		if err != nil {
+			return rollback(err)
+		}
+		if count, err := res.RowsAffected(); err != nil {
+			return rollback(err)
+		} else if count == 0 {
+			return rollback(ErrCurationNotFound)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM curation_urls WHERE curation = ?;", id); err != nil {
+			return rollback(err)
+		}
+	}
+
+	position := 0
+	for _, list := range []struct {
+		urls   []string
+		pinned bool
+	}{{curation.Pinned, true}, {curation.Hidden, false}} {
+		for _, url := range list.urls {
+			_, err := tx.ExecContext(ctx, "INSERT INTO curation_urls (curation, url, pinned, position) VALUES (?, ?, ?, ?);", id, url, list.pinned, position)
+			if err != nil {
+				return rollback(fmt.Errorf("error adding URL %v to curation: %v", url, err))
+			}
+			position++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (db *SQLiteDatabase) DeleteCuration(ctx context.Context, id int64) error {
+	res, err := db.conn.ExecContext(ctx, `
+		DELETE FROM curation_urls WHERE curation = ?;
+		DELETE FROM curations WHERE id = ?;
+	`, id, id)
+	if err != nil {
+		return err
+	}
+	// For multi-statement queries, this is the number of rows affected by the last statement
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrCurationNotFound
+	}
+	return nil
+}
+
+// A page that a curation pins or hides
+type curatedURL struct {
+	source string
+	url    string
+}
+
+// Finds the curations that apply to a query and returns the URLs that they pin (in order, without duplicates) and hide.
+// URLs are converted to their canonical forms so that they match the URLs of indexed pages.
+func (db *SQLiteDatabase) findCurations(ctx context.Context, sources []string, search string) (pinned []curatedURL, hidden []curatedURL, err error) {
+	query := normalizeCurationQuery(search)
+
+	args := []any{}
+	for _, src := range sources {
+		args = append(args, src)
+	}
+	args = append(args, MatchExact, query, MatchContains, " "+query+" ")
+
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			curations.source,
+			coalesce((SELECT canonical FROM canonicals WHERE canonicals.source = curations.source AND canonicals.url = curation_urls.url), curation_urls.url),
+			curation_urls.pinned
+		FROM curations
+		JOIN curation_urls ON curation_urls.curation = curations.id
+		WHERE curations.source IN (%s)
+			AND ((curations.matchType = ? AND curations.pattern = ?) OR (curations.matchType = ? AND instr(?, ' ' || curations.pattern || ' ') > 0))
+		ORDER BY curations.id, curation_urls.position;
+	`, strings.Repeat("?, ", len(sources)-1)+"?"), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	seen := map[curatedURL]struct{}{}
+	for rows.Next() {
+		var u curatedURL
+		var isPinned bool
+		if err := rows.Scan(&u.source, &u.url, &isPinned); err != nil {
+			return nil, nil, err
+		}
+		if isPinned {
+			if _, ok := seen[u]; !ok {
+				pinned = append(pinned, u)
+				seen[u] = struct{}{}
+			}
+		} else {
+			hidden = append(hidden, u)
+		}
+	}
+
+	return pinned, hidden, nil
+}
+
+// Returns an SQL condition (starting with "AND") that excludes the given pages
+func excludeURLs(urls []curatedURL) (string, []any) {
+	if len(urls) == 0 {
+		return "", []any{}
+	}
+	args := make([]any, 0, len(urls)*2)
+	for _, u := range urls {
+		args = append(args, u.source, u.url)
+	}
+	return " AND (pages.source, pages.url) NOT IN (VALUES " + strings.Repeat("(?, ?), ", len(urls)-1) + "(?, ?))", args
+}
+
+// A pinned page, which is shown whether or not it matches the query
+type pinnedPage struct {
+	url         string
+	title       string
+	description string
+	content     string
+	publishedAt *string
+	modifiedAt  *string
+}
+
+// Looks up the pinned pages that have been indexed and pass the filters, in order
+func (db *SQLiteDatabase) getPinnedPages(ctx context.Context, pinned []curatedURL, filter string, filterArgs []any) ([]pinnedPage, error) {
+	pages := make([]pinnedPage, 0, len(pinned))
+	for _, u := range pinned {
+		args := []any{u.source, u.url, Finished}
+		args = append(args, filterArgs...)
+		page := pinnedPage{}
+		err := db.conn.QueryRowContext(ctx, "SELECT url, title, description, content, publishedAt, modifiedAt FROM pages WHERE source = ? AND url = ? AND status = ?"+filter+";", args...).
+			Scan(&page.url, &page.title, &page.description, &page.content, &page.publishedAt, &page.modifiedAt)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// The number of words included in the content of pinned results, to match the length of the snippets of other results
+const pinnedSnippetWords = 24
+
+// Returns the start of a pinned page's content, since pinned pages don't necessarily match the query and can't be highlighted
+func pinnedSnippet(content string) []Match {
+	words := strings.Fields(content)
+	if len(words) > pinnedSnippetWords {
+		return []Match{{Content: strings.Join(words[:pinnedSnippetWords], " ") + "…"}}
+	}
+	return []Match{{Content: strings.Join(words, " ")}}
+}
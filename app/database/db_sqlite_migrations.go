@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// A column that was added to a table after the table was first released.
+// `CREATE TABLE IF NOT EXISTS` doesn't change tables that already exist, so these columns are added separately.
+type addedColumn struct {
+	table      string
+	column     string
+	definition string
+}
+
+// Columns are added in order, so new columns should be appended to the end of this list.
+var addedColumns = []addedColumn{
+	// The kind of content that the page contains (see the Kind constants)
+	{table: "pages", column: "kind", definition: "TEXT NOT NULL DEFAULT 'page'"},
+	// The URL's lowercase hostname and first path segment, which are used to group search results into facets
+	{table: "pages", column: "host", definition: "TEXT GENERATED ALWAYS AS (" + sqlURLHost("url") + ") VIRTUAL"},
+	{table: "pages", column: "section", definition: "TEXT GENERATED ALWAYS AS (" + sqlURLSection("url") + ") VIRTUAL"},
+	// When the page was published and last modified, as RFC 3339 timestamps in UTC (which sort chronologically)
+	{table: "pages", column: "publishedAt", definition: "TEXT"},
+	{table: "pages", column: "modifiedAt", definition: "TEXT"},
+	{table: "feed_items", column: "publishedAt", definition: "TEXT"},
+	{table: "feed_items", column: "modifiedAt", definition: "TEXT"},
+	// The page's language code, and the URL that identifies its group of translations (the first URL in alphabetical order among the page and its hreflang alternates)
+	{table: "pages", column: "language", definition: "TEXT"},
+	{table: "pages", column: "alternateGroup", definition: "TEXT"},
+	// A simhash of the page's content (see fingerprint), and the ID of the first page with a near-identical fingerprint, which identifies its group of near-duplicates
+	{table: "pages", column: "fingerprint", definition: "INTEGER"},
+	{table: "pages", column: "duplicateGroup", definition: "INTEGER"},
+	// The ETag and Last-Modified headers of the last response and a hash of its body, which are used to skip refreshing pages that haven't changed (see CacheValidators)
+	{table: "pages", column: "etag", definition: "TEXT"},
+	{table: "pages", column: "lastModifiedHeader", definition: "TEXT"},
+	{table: "pages", column: "contentHash", definition: "TEXT"},
+	// The number of times in a row that crawling a queue item failed with a temporary error, and when it can be crawled again (see RetryQueueEntry)
+	{table: "crawl_queue", column: "attempts", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "crawl_queue", column: "nextAttemptAt", definition: "TEXT"},
+	// The number of times in a row that crawling the page failed permanently (see AddFailure)
+	{table: "pages", column: "failures", definition: "INTEGER NOT NULL DEFAULT 0"},
+	// Each band of the page's fingerprint, which are indexed to find near-duplicates without comparing every fingerprint (see findDuplicateGroup)
+	{table: "pages", column: "fingerprintBand0", definition: "INTEGER GENERATED ALWAYS AS (" + sqlFingerprintBand("fingerprint", 0) + ") VIRTUAL"},
+	{table: "pages", column: "fingerprintBand1", definition: "INTEGER GENERATED ALWAYS AS (" + sqlFingerprintBand("fingerprint", 1) + ") VIRTUAL"},
+	{table: "pages", column: "fingerprintBand2", definition: "INTEGER GENERATED ALWAYS AS (" + sqlFingerprintBand("fingerprint", 2) + ") VIRTUAL"},
+	{table: "pages", column: "fingerprintBand3", definition: "INTEGER GENERATED ALWAYS AS (" + sqlFingerprintBand("fingerprint", 3) + ") VIRTUAL"},
+	// Whether the source's dictionary must be rebuilt from all of its pages, because the changes since its last update weren't recorded (see updateVocabulary)
+	{table: "spellfix_sources", column: "rebuild", definition: "INTEGER NOT NULL DEFAULT 1"},
+}
+
+// Indexes on columns from `addedColumns`. They're created after the columns are added, so they can't be in the setup script.
+var addedIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS pages_fingerprint_band_0 ON pages(source, fingerprintBand0);",
+	"CREATE INDEX IF NOT EXISTS pages_fingerprint_band_1 ON pages(source, fingerprintBand1);",
+	"CREATE INDEX IF NOT EXISTS pages_fingerprint_band_2 ON pages(source, fingerprintBand2);",
+	"CREATE INDEX IF NOT EXISTS pages_fingerprint_band_3 ON pages(source, fingerprintBand3);",
+}
+
+// Adds any columns from `addedColumns` that are missing from the database
+func (db *SQLiteDatabase) migrate(ctx context.Context) error {
+	for _, c := range addedColumns {
+		var exists bool
+		// `table_xinfo` is used instead of `table_info` because it includes generated columns
+		err := db.conn.QueryRowContext(ctx, "SELECT count(*) > 0 FROM pragma_table_xinfo(?) WHERE name = ?;", c.table, c.column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = db.conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", c.table, c.column, c.definition))
+		if err != nil {
+			return fmt.Errorf("error adding column %v to table %v: %v", c.column, c.table, err)
+		}
+	}
+	for _, index := range addedIndexes {
+		if _, err := db.conn.ExecContext(ctx, index); err != nil {
+			return fmt.Errorf("error creating index: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fluxcapacitor2/easysearch/app/query"
+)
+
+// A search query that has been converted into the pieces of an SQLite query
+type compiledQuery struct {
+	// The FTS5 query string, to be used with `pages_fts MATCH ?`
+	match string
+	// Extra conditions for the WHERE clause that filter results based on the `pages` table. Either empty or starting with "AND".
+	filter string
+	// The parameters referenced in `filter`
+	filterArgs []any
+}
+
+// Parses a user-entered search query and converts it into an FTS5 query string and SQL filters for an index that uses the given tokenizer.
+// Words with synonyms are expanded into OR groups, so FTS5 matches and highlights the synonyms like any other term.
+// See https://sqlite.org/fts5.html#full_text_query_syntax for more info.
+func compileQuery(search string, tokenizer Tokenizer, synonyms ...*query.Synonyms) (*compiledQuery, error) {
+	node, err := query.Parse(search)
+	if err != nil {
+		return nil, err
+	}
+
+	terms, included, excluded := query.ExtractSites(node)
+	filter, filterArgs := siteFilter("pages.url", included, excluded)
+
+	terms = query.ExpandSynonyms(terms, synonyms...)
+	if tokenizer.Name == TokenizerTrigram {
+		terms = adaptToTrigrams(terms)
+	}
+
+	return &compiledQuery{
+		match:      compileFTS(terms),
+		filter:     filter,
+		filterArgs: filterArgs,
+	}, nil
+}
+
+// Returns the synonyms of each of the sources being searched
+func synonymsFor(sources []string, options SearchOptions) []*query.Synonyms {
+	synonyms := make([]*query.Synonyms, 0, len(sources))
+	for _, src := range sources {
+		if set, ok := options.Synonyms[src]; ok {
+			synonyms = append(synonyms, set)
+		}
+	}
+	return synonyms
+}
+
+func compileFTS(node query.Node) string {
+	switch n := node.(type) {
+	case *query.And:
+		// FTS5's NOT operator is binary, so negated terms are moved to the end: `(a b) NOT c NOT d`
+		positive := make([]string, 0, len(n.Children))
+		negative := make([]string, 0)
+		// FTS5 only allows an implicit AND between phrases, so groups and column filters need an explicit AND
+		separator := " "
+		for _, child := range n.Children {
+			switch c := child.(type) {
+			case *query.Not:
+				negative = append(negative, groupFTS(c.Child))
+				continue
+			case *query.Term, *query.Phrase:
+			default:
+				separator = " AND "
+			}
+			positive = append(positive, groupFTS(child))
+		}
+		if len(negative) == 0 {
+			return strings.Join(positive, separator)
+		}
+		str := strings.Join(positive, separator)
+		if len(positive) > 1 {
+			str = "(" + str + ")"
+		}
+		return str + " NOT " + strings.Join(negative, " NOT ")
+	case *query.Or:
+		parts := make([]string, 0, len(n.Children))
+		for _, child := range n.Children {
+			parts = append(parts, groupFTS(child))
+		}
+		return strings.Join(parts, " OR ")
+	case *query.Field:
+		// The field names match the column names in `pages_fts`. See https://sqlite.org/fts5.html#fts5_column_filters
+		return n.Name + " : " + groupFTS(n.Child)
+	case *query.Term:
+		// Surround each word with double quotes so that FTS5 doesn't interpret it as a keyword or column name
+		if n.Prefix {
+			return quoteFTS(n.Value) + "*"
+		}
+		return quoteFTS(n.Value)
+	case *query.Phrase:
+		return quoteFTS(strings.Join(n.Words, " "))
+	}
+	return ""
+}
+
+// The trigram tokenizer can't look up terms with fewer characters than this
+const trigramLength = 3
+
+// Adapts a query to an index that uses the trigram tokenizer, where every term already matches any part of a word.
+// Prefix operators are removed because they're unnecessary, and terms that are too short to look up are removed from AND groups
+// as long as the group has another term that results must contain.
+func adaptToTrigrams(node query.Node) query.Node {
+	switch n := node.(type) {
+	case *query.And:
+		isShort := func(child query.Node) bool {
+			if not, ok := child.(*query.Not); ok {
+				child = not.Child
+			}
+			term, ok := child.(*query.Term)
+			return ok && utf8.RuneCountInString(term.Value) < trigramLength
+		}
+		hasLongTerm := slices.ContainsFunc(n.Children, func(child query.Node) bool {
+			_, negated := child.(*query.Not)
+			return !negated && !isShort(child)
+		})
+
+		children := make([]query.Node, 0, len(n.Children))
+		for _, child := range n.Children {
+			if hasLongTerm && isShort(child) {
+				continue
+			}
+			children = append(children, adaptToTrigrams(child))
+		}
+		if len(children) == 1 {
+			return children[0]
+		}
+		return &query.And{Children: children}
+	case *query.Or:
+		children := make([]query.Node, 0, len(n.Children))
+		for _, child := range n.Children {
+			children = append(children, adaptToTrigrams(child))
+		}
+		return &query.Or{Children: children}
+	case *query.Not:
+		return &query.Not{Child: adaptToTrigrams(n.Child)}
+	case *query.Field:
+		return &query.Field{Name: n.Name, Child: adaptToTrigrams(n.Child)}
+	case *query.Term:
+		return &query.Term{Value: n.Value}
+	}
+	return node
+}
+
+// Wraps compound expressions in parentheses so that they can be nested inside other expressions
+func groupFTS(node query.Node) string {
+	switch node.(type) {
+	case *query.And, *query.Or:
+		return "(" + compileFTS(node) + ")"
+	}
+	return compileFTS(node)
+}
+
+func quoteFTS(str string) string {
+	return `"` + strings.ReplaceAll(str, `"`, `""`) + `"`
+}
+
+// An SQL expression that multiplies the columns returned by `sqlScoreColumns` to get each page's full-text search score, where lower is better
+const sqlScore = "bm25_score * authority_boost * depth_boost * url_boost * language_boost"
+
+// Returns SQL result columns for the parts of each page's full-text search score: `bm25_score`, `authority`, `authority_boost`, `depth_boost`, `url_boost`, and `language_boost`.
+// Each source's pages are scored using its entry in `options.Ranking`, or DefaultRanking if it doesn't have one.
+// The query must join `pages_fts` and `page_authority` on `pages.id`.
+func sqlScoreColumns(sources []string, options SearchOptions) (string, []any) {
+	ranking := options.Ranking
+	columns := []string{}
+	args := []any{}
+	// Adds a column, replacing each `%s` in the format string with an expression for the corresponding value
+	add := func(format string, values ...func(Ranking) float64) {
+		exprs := make([]any, 0, len(values))
+		for _, get := range values {
+			expr, exprArgs := sqlPerSource(sources, ranking, get)
+			exprs = append(exprs, expr)
+			args = append(args, exprArgs...)
+		}
+		columns = append(columns, fmt.Sprintf(format, exprs...))
+	}
+
+	add("bm25(pages_fts, %s, %s, %s, %s) AS bm25_score",
+		func(r Ranking) float64 { return r.FieldWeights.URL },
+		func(r Ranking) float64 { return r.FieldWeights.Title },
+		func(r Ranking) float64 { return r.FieldWeights.Description },
+		func(r Ranking) float64 { return r.FieldWeights.Content },
+	)
+	add("coalesce(page_authority.score, 0.0) AS authority")
+	add("1.0 + %s * coalesce(page_authority.score, 0.0) AS authority_boost", func(r Ranking) float64 { return r.AuthorityWeight })
+	add("1.0 + %s / (1.0 + pages.depth) AS depth_boost", func(r Ranking) float64 { return r.DepthBoost })
+
+	urlBoost := "1.0"
+	for _, src := range sources {
+		for _, rule := range rankingFor(ranking, src).URLRules {
+			urlBoost += " * (CASE WHEN pages.source = ? AND instr(pages.url, ?) > 0 THEN ? ELSE 1.0 END)"
+			args = append(args, src, rule.Contains, rule.Multiplier)
+		}
+	}
+	columns = append(columns, urlBoost+" AS url_boost")
+
+	// Pages in the user's preferred languages are boosted by how much the user prefers the language
+	preference := "0.0"
+	preferenceArgs := []any{}
+	if len(options.PreferredLanguages) > 0 {
+		preference = "CASE pages.language"
+		for _, pref := range options.PreferredLanguages {
+			preference += " WHEN ? THEN ?"
+			preferenceArgs = append(preferenceArgs, pref.Language, pref.Weight)
+		}
+		preference += " ELSE 0.0 END"
+	}
+	add("1.0 + %s * "+preference+" AS language_boost", func(r Ranking) float64 { return r.LanguageBoost })
+	args = append(args, preferenceArgs...)
+
+	return strings.Join(columns, ",\n"), args
+}
+
+// Returns the ranking for a source, or DefaultRanking if it doesn't have one
+func rankingFor(ranking map[string]Ranking, source string) Ranking {
+	if r, ok := ranking[source]; ok {
+		return r
+	}
+	return DefaultRanking
+}
+
+// Returns an SQL expression for a value from the ranking of each page's source.
+// If all of the sources have the same value, the expression is a single parameter.
+func sqlPerSource(sources []string, ranking map[string]Ranking, get func(Ranking) float64) (string, []any) {
+	first := get(rankingFor(ranking, sources[0]))
+	same := true
+	for _, src := range sources[1:] {
+		same = same && get(rankingFor(ranking, src)) == first
+	}
+	if same {
+		return "?", []any{first}
+	}
+	expr := "CASE pages.source"
+	args := []any{}
+	for _, src := range sources {
+		expr += " WHEN ? THEN ?"
+		args = append(args, src, get(rankingFor(ranking, src)))
+	}
+	return expr + " END", args
+}
+
+// Returns an SQL expression for everything after the "://" in a URL column
+func sqlURLRest(column string) string {
+	return "substr(" + column + ", instr(" + column + ", '://') + 3)"
+}
+
+// Returns an SQL expression that extracts the lowercase hostname from a URL column.
+// The hostname ends at the first slash, question mark, hash, or colon (before a port number).
+func sqlURLHost(column string) string {
+	delimited := "replace(replace(replace(" + sqlURLRest(column) + ", '?', '/'), '#', '/'), ':', '/') || '/'"
+	return "lower(substr(" + delimited + ", 1, instr(" + delimited + ", '/') - 1))"
+}
+
+// Returns an SQL expression that extracts the path (and anything after it) from a URL column.
+// For URLs without a path, the expression evaluates to an empty string.
+func sqlURLPath(column string) string {
+	rest := sqlURLRest(column)
+	return "CASE WHEN instr(" + rest + ", '/') > 0 THEN substr(" + rest + ", instr(" + rest + ", '/')) ELSE '' END"
+}
+
+// Returns an SQL expression that extracts the first path segment from a URL column, like "blog" for "https://example.com/blog/post".
+// For URLs without a path, the expression evaluates to an empty string.
+func sqlURLSection(column string) string {
+	delimited := "replace(replace(substr(" + sqlURLPath(column) + ", 2), '?', '/'), '#', '/') || '/'"
+	return "substr(" + delimited + ", 1, instr(" + delimited + ", '/') - 1)"
+}
+
+// Escapes the wildcard characters in a LIKE pattern. The query must use `ESCAPE '\'`.
+func escapeLike(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(str)
+}
+
+// Returns an SQL condition that matches URLs on the site's host (or a subdomain) and under its path
+func siteCondition(column string, site *query.Site) (string, []any) {
+	condition := "(" + sqlURLHost(column) + " = ? OR " + sqlURLHost(column) + ` LIKE ? ESCAPE '\')`
+	args := []any{site.Host, "%." + escapeLike(site.Host)}
+
+	if site.Path != "" {
+		path := sqlURLPath(column)
+		condition += " AND (" + path + " = ? OR " + path + ` LIKE ? ESCAPE '\' OR ` + path + ` LIKE ? ESCAPE '\' OR ` + path + ` LIKE ? ESCAPE '\')`
+		escaped := escapeLike(site.Path)
+		args = append(args, site.Path, escaped+"/%", escaped+"?%", escaped+"#%")
+	}
+
+	return "(" + condition + ")", args
+}
+
+// Returns an SQL filter which requires URLs to be on one of the included sites and none of the excluded sites.
+func siteFilter(column string, included []*query.Site, excluded []*query.Site) (string, []any) {
+	filter := ""
+	args := []any{}
+
+	if len(included) > 0 {
+		conditions := make([]string, 0, len(included))
+		for _, site := range included {
+			condition, conditionArgs := siteCondition(column, site)
+			conditions = append(conditions, condition)
+			args = append(args, conditionArgs...)
+		}
+		filter += " AND (" + strings.Join(conditions, " OR ") + ")"
+	}
+
+	for _, site := range excluded {
+		condition, conditionArgs := siteCondition(column, site)
+		filter += " AND NOT " + condition
+		args = append(args, conditionArgs...)
+	}
+
+	return filter, args
+}
@@ -89,7 +89,10 @@ CREATE VIRTUAL TABLE IF NOT EXISTS pages_fts USING fts5(
     content,
 
     -- Specify that this FTS table is contentless and gets its content from the `pages` table
-    content=pages
+    content=pages,
+
+    -- The tokenizer is configurable. If it changes, the table is rebuilt by `setupTokenizer`.
+    tokenize='%s'
 );
 
 -- When a page is deleted, delete its canonicals too
@@ -117,9 +120,20 @@ CREATE TRIGGER IF NOT EXISTS pages_auto_delete AFTER DELETE ON pages BEGIN
   INSERT INTO pages_fts(pages_fts, rowid, url, title, description, content) VALUES('delete', old.rowid, old.url, old.title, old.description, old.content);
 END;
 
-CREATE TRIGGER IF NOT EXISTS pages_auto_update AFTER UPDATE ON pages BEGIN
+-- Older versions re-indexed pages on every update. It's recreated so that pages are only re-indexed when their text changes,
+-- which avoids rewriting the index when a refreshed page hasn't changed.
+DROP TRIGGER IF EXISTS pages_auto_update;
+CREATE TRIGGER pages_auto_update AFTER UPDATE ON pages
+WHEN old.url IS NOT new.url OR old.title IS NOT new.title OR old.description IS NOT new.description OR old.content IS NOT new.content BEGIN
   INSERT INTO pages_fts(pages_fts, rowid, url, title, description, content) VALUES('delete', old.rowid, old.url, old.title, old.description, old.content);
   INSERT INTO pages_fts(rowid, url, title, description, content) VALUES (new.rowid, new.url, new.title, new.description, new.content);
+END;
+
+-- Only updates from crawling the page (which always set its crawl time or status) remove its queue entry.
+-- Metadata from feeds and sitemaps is added without crawling the page, so it shouldn't remove a pending refresh.
+DROP TRIGGER IF EXISTS pages_remove_from_queue_on_update;
+CREATE TRIGGER pages_remove_from_queue_on_update AFTER UPDATE ON pages
+WHEN old.crawledAt IS NOT new.crawledAt OR old.status IS NOT new.status BEGIN
   -- Remove crawl queue entry if it exists
   DELETE FROM crawl_queue WHERE source = new.source AND url = new.url;
 END;
@@ -146,3 +160,122 @@ CREATE TABLE IF NOT EXISTS embed_queue(
 ) STRICT;
 
 CREATE UNIQUE INDEX IF NOT EXISTS embed_queue_page_chunk_unique ON embed_queue(page, chunkIndex);
+
+-- Each source has its own spelling correction dictionary, which is identified by a `langid` in the `spellfix` table.
+-- When a source's pages change, its dictionary is marked as stale so that it can be updated in the background.
+CREATE TABLE IF NOT EXISTS spellfix_sources(
+  langid INTEGER PRIMARY KEY,
+  source TEXT NOT NULL UNIQUE,
+  stale INTEGER NOT NULL DEFAULT 1
+) STRICT;
+
+CREATE TRIGGER IF NOT EXISTS spellfix_mark_stale_on_page_insert AFTER INSERT ON pages BEGIN
+  INSERT INTO spellfix_sources (source) VALUES (new.source) ON CONFLICT DO UPDATE SET stale = 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS spellfix_mark_stale_on_page_update AFTER UPDATE ON pages
+WHEN old.status IS NOT new.status OR old.url IS NOT new.url OR old.title IS NOT new.title OR old.description IS NOT new.description OR old.content IS NOT new.content BEGIN
+  INSERT INTO spellfix_sources (source) VALUES (new.source) ON CONFLICT DO UPDATE SET stale = 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS spellfix_mark_stale_on_page_delete AFTER DELETE ON pages BEGIN
+  UPDATE spellfix_sources SET stale = 1 WHERE source = old.source;
+END;
+
+-- Pages whose words changed since their source's dictionary was last updated, so that only these pages are read when it's updated again.
+-- Only the first change is recorded, along with the page's text before it, which is what the dictionary still counts (if `counted` is 1).
+CREATE TABLE IF NOT EXISTS vocabulary_changes(
+  page INTEGER PRIMARY KEY,
+  source TEXT NOT NULL,
+  counted INTEGER NOT NULL,
+  url TEXT,
+  title TEXT,
+  description TEXT,
+  content TEXT
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS vocabulary_changes_source ON vocabulary_changes(source);
+
+CREATE TRIGGER IF NOT EXISTS vocabulary_changes_on_page_insert AFTER INSERT ON pages BEGIN
+  INSERT INTO vocabulary_changes (page, source, counted) SELECT new.id, new.source, 0
+    WHERE NOT EXISTS (SELECT 1 FROM vocabulary_changes WHERE page = new.id);
+END;
+
+-- Dictionaries only count Finished (2) pages
+CREATE TRIGGER IF NOT EXISTS vocabulary_changes_on_page_update AFTER UPDATE ON pages
+WHEN old.status IS NOT new.status OR old.url IS NOT new.url OR old.title IS NOT new.title OR old.description IS NOT new.description OR old.content IS NOT new.content BEGIN
+  INSERT INTO vocabulary_changes (page, source, counted, url, title, description, content)
+    SELECT old.id, old.source, old.status = 2, old.url, old.title, old.description, old.content
+    WHERE NOT EXISTS (SELECT 1 FROM vocabulary_changes WHERE page = old.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS vocabulary_changes_on_page_delete AFTER DELETE ON pages BEGIN
+  INSERT INTO vocabulary_changes (page, source, counted, url, title, description, content)
+    SELECT old.id, old.source, old.status = 2, old.url, old.title, old.description, old.content
+    WHERE NOT EXISTS (SELECT 1 FROM vocabulary_changes WHERE page = old.id);
+END;
+
+-- The terms in each source's pages and the number of pages that contain each one.
+-- This is updated along with the spelling correction dictionaries and is used to autocomplete words as users type.
+CREATE TABLE IF NOT EXISTS vocabulary(
+  langid INTEGER NOT NULL,
+  term TEXT NOT NULL,
+  docs INTEGER NOT NULL,
+  PRIMARY KEY (langid, term)
+) STRICT, WITHOUT ROWID;
+
+-- Queries that users searched for, which are suggested to other users who type the same prefix
+CREATE TABLE IF NOT EXISTS query_log(
+  source TEXT NOT NULL,
+  query TEXT NOT NULL COLLATE NOCASE,
+  count INTEGER NOT NULL DEFAULT 1,
+  lastSearchedAt TEXT DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (source, query)
+) STRICT, WITHOUT ROWID;
+
+-- Allows page titles to be searched by prefix when suggesting pages
+CREATE INDEX IF NOT EXISTS pages_source_title ON pages(source, title COLLATE NOCASE);
+
+-- URLs that were linked from an RSS, Atom, or JSON feed. When these pages are crawled, they are assigned the "feed-item" kind.
+CREATE TABLE IF NOT EXISTS feed_items(
+  source TEXT NOT NULL,
+  url TEXT NOT NULL,
+  PRIMARY KEY (source, url)
+) STRICT, WITHOUT ROWID;
+
+-- The last modified dates of URLs listed in sitemaps
+CREATE TABLE IF NOT EXISTS sitemap_entries(
+  source TEXT NOT NULL,
+  url TEXT NOT NULL,
+  lastModified TEXT NOT NULL,
+  PRIMARY KEY (source, url)
+) STRICT, WITHOUT ROWID;
+
+-- Each page's PageRank score, scaled between 0 and 1 within its source. See `UpdateAuthority`.
+CREATE TABLE IF NOT EXISTS page_authority(
+  page INTEGER PRIMARY KEY,
+  score REAL NOT NULL,
+  FOREIGN KEY(page) REFERENCES pages(id) ON DELETE CASCADE
+) STRICT;
+
+-- Pinned and hidden results for queries that match a pattern. See `Curation` in db.go.
+CREATE TABLE IF NOT EXISTS curations(
+  id INTEGER PRIMARY KEY,
+  source TEXT NOT NULL,
+  -- Patterns are stored in lowercase with extra whitespace removed, which is how queries are compared to them
+  pattern TEXT NOT NULL,
+  matchType TEXT NOT NULL
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS curations_source_pattern ON curations(source, pattern);
+
+CREATE TABLE IF NOT EXISTS curation_urls(
+  curation INTEGER NOT NULL,
+  url TEXT NOT NULL,
+  -- 1 if the URL is pinned, 0 if it's hidden
+  pinned INTEGER NOT NULL,
+  -- The order of pinned URLs
+  position INTEGER NOT NULL,
+  PRIMARY KEY (curation, url),
+  FOREIGN KEY(curation) REFERENCES curations(id) ON DELETE CASCADE
+) STRICT, WITHOUT ROWID;
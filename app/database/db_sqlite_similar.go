@@ -0,0 +1,331 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+)
+
+// Looks up an indexed page and the ID that identifies its group of near-duplicates
+func (db *SQLiteDatabase) similarTo(ctx context.Context, source string, url string) (*Page, int64, error) {
+	page, err := db.GetDocument(ctx, source, url)
+	if err != nil {
+		return nil, 0, err
+	}
+	if page == nil || page.Status != Finished {
+		return nil, 0, ErrPageNotFound
+	}
+	var group int64
+	if err := db.conn.QueryRowContext(ctx, "SELECT coalesce(duplicateGroup, id) FROM pages WHERE id = ?;", page.ID).Scan(&group); err != nil {
+		return nil, 0, err
+	}
+	return page, group, nil
+}
+
+// The number of nearest chunks fetched for each result, since a page's chunks are often near each other
+const chunksPerResult = 5
+
+// The maximum number of a page's chunks that are searched separately with AggregateBest, since each one is a separate KNN query.
+// Longer pages are represented by chunks spread evenly through the page.
+const maxBestChunks = 8
+
+func (db *SQLiteDatabase) SimilarPages(ctx context.Context, source string, url string, aggregation ChunkAggregation, limit int) ([]SimilarityResult, error) {
+	page, group, err := db.similarTo(ctx, source, url)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf("SELECT pages_vec_%s.embedding FROM vec_chunks JOIN pages_vec_%s USING (id) WHERE vec_chunks.page = ? ORDER BY vec_chunks.chunkIndex;", source, source), page.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vectors := [][]float32{}
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, deserializeFloat32(blob))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, ErrNoEmbeddings
+	}
+
+	if aggregation != AggregateBest {
+		vectors = [][]float32{meanVector(vectors)}
+	} else {
+		vectors = evenlySpaced(vectors, maxBestChunks)
+	}
+
+	// With AggregateBest, each of the page's chunks is searched separately, and each result keeps the chunk that was closest to any of them
+	best := map[string]SimilarityResult{}
+	for _, vector := range vectors {
+		serialized, err := vec.SerializeFloat32(vector)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+			SELECT pages.url, pages.title, vec_chunks.chunk, min(pages_vec_%s.distance) FROM pages_vec_%s
+			JOIN vec_chunks USING (id)
+			JOIN pages ON pages.id = vec_chunks.page
+			WHERE pages_vec_%s.embedding MATCH ? AND k = ?
+				AND pages.status = ?
+				AND coalesce(pages.duplicateGroup, pages.id) != ?
+			GROUP BY pages.id;
+		`, source, source, source), serialized, (limit+len(vectors))*chunksPerResult, Finished, group)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			res := SimilarityResult{}
+			var distance float32
+			if err := rows.Scan(&res.URL, &res.Title, &res.Chunk, &distance); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			// Cosine distance is between 0 and 2, so this is between -1 and 1
+			res.Similarity = 1 - distance
+			res.Distance = &distance
+			if existing, ok := best[res.URL]; !ok || res.Similarity > existing.Similarity {
+				best[res.URL] = res
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]SimilarityResult, 0, len(best))
+	for _, res := range best {
+		results = append(results, res)
+	}
+	slices.SortFunc(results, func(a, b SimilarityResult) int {
+		return cmp.Or(cmp.Compare(b.Similarity, a.Similarity), strings.Compare(a.URL, b.URL))
+	})
+	return results[:min(limit, len(results))], nil
+}
+
+func (db *SQLiteDatabase) RelevantChunks(ctx context.Context, source string, query []float32, urls []string, perPage int) (map[string][]string, error) {
+	chunks := make(map[string][]string, len(urls))
+	if len(urls) == 0 {
+		return chunks, nil
+	}
+
+	serialized, err := vec.SerializeFloat32(query)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{source}
+	for _, url := range urls {
+		args = append(args, url)
+	}
+	args = append(args, serialized)
+
+	// The pages' chunks are compared to the query directly instead of with a KNN search, since only a few pages' chunks are needed
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT pages.url, vec_chunks.chunk FROM vec_chunks
+		JOIN pages ON pages.id = vec_chunks.page
+		JOIN pages_vec_%s ON pages_vec_%s.id = vec_chunks.id
+		WHERE pages.source = ? AND pages.url IN (%s)
+		ORDER BY vec_distance_cosine(pages_vec_%s.embedding, ?);
+	`, source, source, strings.Repeat("?, ", len(urls)-1)+"?", source), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url, chunk string
+		if err := rows.Scan(&url, &chunk); err != nil {
+			return nil, err
+		}
+		if len(chunks[url]) < perPage {
+			chunks[url] = append(chunks[url], chunk)
+		}
+	}
+	return chunks, rows.Err()
+}
+
+// Converts a vector from sqlite-vec's format (see vec.SerializeFloat32) into a slice
+func deserializeFloat32(blob []byte) []float32 {
+	vector := make([]float32, len(blob)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:]))
+	}
+	return vector
+}
+
+// Averages the vectors of a page's chunks, which represents the whole page
+func meanVector(vectors [][]float32) []float32 {
+	mean := make([]float32, len(vectors[0]))
+	for _, v := range vectors {
+		for i := range mean {
+			mean[i] += v[i] / float32(len(vectors))
+		}
+	}
+	return mean
+}
+
+// Returns at most `n` items, spread evenly through the slice so that they include its first and last items
+func evenlySpaced[T any](items []T, n int) []T {
+	if len(items) <= n {
+		return items
+	}
+	if n == 1 {
+		return items[:1]
+	}
+	spaced := make([]T, n)
+	for i := range spaced {
+		spaced[i] = items[i*(len(items)-1)/(n-1)]
+	}
+	return spaced
+}
+
+// The number of a page's most distinctive terms that are searched for to find related pages
+const relatedTerms = 12
+
+// Terms are chosen from this many of the page's most frequent words, since looking up every word would be slow
+const relatedCandidates = 50
+
+func (db *SQLiteDatabase) RelatedPages(ctx context.Context, source string, url string, limit int) ([]SimilarityResult, error) {
+	page, group, err := db.similarTo(ctx, source, url)
+	if err != nil {
+		return nil, err
+	}
+
+	terms, err := db.distinctiveTerms(ctx, source, page.Title+"\n"+page.Description+"\n"+page.Content)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return []SimilarityResult{}, nil
+	}
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = quoteFTS(term)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT pages.url, pages.title, snippet(pages_fts, 3, '', '', '…', 24), bm25(pages_fts) AS score
+		FROM pages_fts
+		JOIN pages ON pages.id = pages_fts.rowid
+		WHERE pages.source = ?
+			AND pages.status = ?
+			AND coalesce(pages.duplicateGroup, pages.id) != ?
+			AND pages_fts MATCH ?
+		ORDER BY score
+		LIMIT ?;
+	`, source, Finished, group, strings.Join(quoted, " OR "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SimilarityResult{}
+	var bestScore float64
+	for rows.Next() {
+		res := SimilarityResult{}
+		var score float64
+		if err := rows.Scan(&res.URL, &res.Title, &res.Chunk, &score); err != nil {
+			return nil, err
+		}
+		// bm25 scores aren't bounded, so they're scaled relative to the best match, which has a similarity of 1
+		if len(results) == 0 {
+			bestScore = score
+		}
+		if bestScore != 0 {
+			res.Similarity = float32(score / bestScore)
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// Returns the terms in the text that best distinguish it from the other pages in the source, ranked by tf-idf
+func (db *SQLiteDatabase) distinctiveTerms(ctx context.Context, source string, text string) ([]string, error) {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		// Short words are rarely distinctive, and the trigram tokenizer can't look them up
+		if len([]rune(word)) >= trigramLength {
+			counts[word]++
+		}
+	}
+
+	candidates := make([]string, 0, len(counts))
+	for word := range counts {
+		candidates = append(candidates, word)
+	}
+	slices.SortFunc(candidates, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+	candidates = candidates[:min(relatedCandidates, len(candidates))]
+
+	// The number of pages that contain each word comes from the source's vocabulary, which counts the source's indexed pages.
+	// It's updated periodically with the spelling correction dictionary, so a source without one yet has no distinctive terms.
+	langids, err := db.getLangIDs(ctx, []string{source})
+	if err != nil {
+		return nil, err
+	}
+	if len(langids) == 0 || len(candidates) == 0 {
+		return []string{}, nil
+	}
+
+	var total float64
+	if err := db.conn.QueryRowContext(ctx, "SELECT count(*) FROM pages WHERE source = ? AND status = ?;", source, Finished).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	args := make([]any, 0, len(candidates)+1)
+	args = append(args, langids[0])
+	for _, word := range candidates {
+		args = append(args, word)
+	}
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf("SELECT term, docs FROM vocabulary WHERE langid = ? AND term IN (%s);", strings.Repeat("?, ", len(candidates)-1)+"?"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[string]float64, len(candidates))
+	for rows.Next() {
+		var word string
+		var frequency float64
+		if err := rows.Scan(&word, &frequency); err != nil {
+			return nil, err
+		}
+		// Words that appear on every page don't help find related pages
+		if frequency <= 0 || frequency >= total {
+			continue
+		}
+		scores[word] = float64(counts[word]) * math.Log(total/frequency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	terms := make([]string, 0, len(scores))
+	for word := range scores {
+		terms = append(terms, word)
+	}
+	slices.SortFunc(terms, func(a, b string) int {
+		return cmp.Or(cmp.Compare(scores[b], scores[a]), strings.Compare(a, b))
+	})
+	return terms[:min(relatedTerms, len(terms))], nil
+}
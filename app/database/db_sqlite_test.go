@@ -2,12 +2,18 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"path"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+	"github.com/fluxcapacitor2/easysearch/app/query"
 	"github.com/fluxcapacitor2/easysearch/app/spellfix"
 )
 
@@ -31,6 +37,48 @@ func TestSetup(t *testing.T) {
 	createDB(t)
 }
 
+// Databases that were created before columns were added to the `pages` table should be migrated when they're set up
+func TestSetupMigratesExistingTables(t *testing.T) {
+	db, err := SQLiteFromFile(path.Join(t.TempDir(), "temp.db"))
+	if err != nil {
+		t.Fatalf("database creation failed: %v", err)
+	}
+
+	_, err = db.conn.Exec(`
+		CREATE TABLE pages(
+			id INTEGER PRIMARY KEY,
+			source TEXT NOT NULL,
+			crawledAt TEXT DEFAULT CURRENT_TIMESTAMP,
+			depth INTEGER NOT NULL,
+			errorInfo TEXT,
+			status INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			title TEXT,
+			description TEXT,
+			content TEXT
+		) STRICT;
+		INSERT INTO pages (source, depth, status, url, title, description, content) VALUES ('source1', 1, 2, 'https://example.com/docs/page', 'Title', '', 'Content');
+	`)
+	if err != nil {
+		t.Fatalf("failed to create old table: %v", err)
+	}
+
+	// Running setup twice shouldn't try to add the columns again
+	for range 2 {
+		if err := db.Setup(context.Background()); err != nil {
+			t.Fatalf("database setup failed: %v", err)
+		}
+	}
+
+	var kind, host, section string
+	if err := db.conn.QueryRow("SELECT kind, host, section FROM pages;").Scan(&kind, &host, &section); err != nil {
+		t.Fatalf("failed to read migrated columns: %v", err)
+	}
+	if kind != KindPage || host != "example.com" || section != "docs" {
+		t.Fatalf("unexpected values in migrated columns: %v, %v, %v", kind, host, section)
+	}
+}
+
 func TestVecSetup(t *testing.T) {
 	db := createDB(t)
 	err := db.SetupVectorTables(context.Background(), "1", 768)
@@ -66,14 +114,135 @@ func TestEscape(t *testing.T) {
 	}{
 		{input: "Hello, world!", output: `"Hello" "world"*`},
 		{input: "test123", output: `"test123"*`},
-		{input: `"double quotes" "are" "escaped properly"`, output: `"double" "quotes" "are" "escaped" "properly"*`},
+		{input: `"double quotes" "are" "escaped properly"`, output: `"double quotes" "are" "escaped properly"`},
 		{input: `using keywords like AND and OR`, output: `"using" "keywords" "like" "AND" "and" "OR"*`},
 		{input: `using * * * wildcards * * *`, output: `"using" "wildcards"*`},
+		{input: `prefix* (a OR b)`, output: `"prefix"* AND ("a" OR "b")`},
+		{input: `(a OR b) title:c d`, output: `("a" OR "b") AND title : "c" AND "d"*`},
+		{input: `a b -c NOT d`, output: `("a" "b") NOT "c" NOT "d"*`},
+		{input: `a OR (b -"c d")`, output: `"a" OR ("b" NOT "c d")`},
+		{input: `title:(a OR b) -inurl:tag site:example.com`, output: `title : ("a" OR "b") NOT url : "tag"`},
+	}
+
+	for _, c := range testCases {
+		compiled, err := compileQuery(c.input, DefaultTokenizer)
+		if err != nil {
+			t.Fatalf("unexpected error escaping %v: %v", c.input, err)
+		}
+		if compiled.match != c.output {
+			t.Fatalf("Expected %v, received %v", c.output, compiled.match)
+		}
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	ctx := context.Background()
+	file := path.Join(t.TempDir(), "temp.db")
+
+	open := func(tokenizer Tokenizer) *SQLiteDatabase {
+		db, err := SQLiteFromFile(file)
+		if err != nil {
+			t.Fatalf("database creation failed: %v", err)
+		}
+		if err := db.UseTokenizer(tokenizer); err != nil {
+			t.Fatalf("unexpected error setting tokenizer: %v", err)
+		}
+		if err := db.Setup(ctx); err != nil {
+			t.Fatalf("database setup failed: %v", err)
+		}
+		t.Cleanup(func() { db.conn.Close() })
+		return db
+	}
+
+	count := func(db *SQLiteDatabase, search string) int {
+		results, _, err := db.Search(ctx, []string{"source1"}, search, SearchOptions{}, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching for %q: %v", search, err)
+		}
+		return len(results)
+	}
+
+	db := open(DefaultTokenizer)
+	for i, content := range []string{"I was running to the café", "東京都に住んでいます"} {
+		_, err := db.AddDocument(ctx, "source1", 1, []int64{}, fmt.Sprintf("https://example.com/%v", i), Finished, "Title", "", content, "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
+	if count(db, "runs") != 0 || count(db, "cafe") != 1 || count(db, "住んで") != 0 {
+		t.Fatalf("unexpected results with the default tokenizer")
+	}
+
+	// Changing the tokenizer rebuilds the index from the existing pages
+	db = open(Tokenizer{Name: TokenizerPorter, RemoveDiacritics: true})
+	if count(db, "runs") != 1 {
+		t.Fatalf("expected stemmed words to match")
+	}
+
+	// Spelling correction dictionaries are built from whole words instead of stems
+	if err := db.UpdateSpellfixIndex(ctx); err != nil {
+		t.Fatalf("unexpected error updating spellfix index: %v", err)
+	}
+	var hasWord, hasStem bool
+	err := db.conn.QueryRow("SELECT count(*) FILTER (WHERE term = 'running') > 0, count(*) FILTER (WHERE term = 'run') > 0 FROM vocabulary;").Scan(&hasWord, &hasStem)
+	if err != nil || !hasWord || hasStem {
+		t.Fatalf("expected vocabulary to contain whole words: %v, %v, %v", hasWord, hasStem, err)
+	}
+
+	db = open(Tokenizer{Name: TokenizerTrigram, RemoveDiacritics: true})
+	if count(db, "住んで") != 1 || count(db, "unnin") != 1 || count(db, "to unnin") != 1 || count(db, "cafe") != 1 {
+		t.Fatalf("expected trigrams to match substrings")
+	}
+
+	// New pages are added to both indexes
+	if _, err := db.AddDocument(ctx, "source1", 1, []int64{}, "https://example.com/new", Finished, "Title", "", "Walking home", "", PageMetadata{}); err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	if count(db, "alki") != 1 {
+		t.Fatalf("expected new page to be indexed")
+	}
+
+	// Reopening the database with the same tokenizer doesn't change anything
+	db = open(Tokenizer{Name: TokenizerTrigram, RemoveDiacritics: true})
+	if count(db, "alki") != 1 {
+		t.Fatalf("expected page to still be indexed")
+	}
+
+	if err := db.UseTokenizer(Tokenizer{Name: "unknown"}); err == nil {
+		t.Fatalf("expected an error for an unknown tokenizer")
+	}
+}
+
+func TestEscapeTrigram(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{input: "go tutorial", output: `"tutorial"`},
+		{input: "tutorial -go -old", output: `"tutorial" NOT "old"`},
+		{input: "go", output: `"go"`},
+		{input: "(go OR rust) title:basics", output: `("go" OR "rust") AND title : "basics"`},
 	}
 
 	for _, c := range testCases {
-		if escape(c.input) != c.output {
-			t.Fatalf("Expected %v, received %v", c.output, escape(c.input))
+		compiled, err := compileQuery(c.input, Tokenizer{Name: TokenizerTrigram})
+		if err != nil {
+			t.Fatalf("unexpected error escaping %v: %v", c.input, err)
+		}
+		if compiled.match != c.output {
+			t.Fatalf("Expected %v, received %v", c.output, compiled.match)
+		}
+	}
+}
+
+func TestEscapeInvalidSyntax(t *testing.T) {
+	inputs := []string{`(unclosed`, `-only -excluded`}
+
+	for _, input := range inputs {
+		_, err := compileQuery(input, DefaultTokenizer)
+		var parseErr *query.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected a parse error for %v, got %v", input, err)
 		}
 	}
 }
@@ -110,6 +279,145 @@ func TestPopQueue(t *testing.T) {
 	}
 }
 
+func TestRetryQueueEntry(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	if err := db.AddToQueue(ctx, "source1", "", []string{"https://example.com/later", "https://example.com/now"}, 1, false); err != nil {
+		t.Fatalf("error adding to queue: %v", err)
+	}
+
+	for _, delay := range []time.Duration{time.Hour, 0} {
+		item, err := db.PopQueue(ctx, "source1")
+		if err != nil || item == nil {
+			t.Fatalf("expected a queue item, got %v, %v", item, err)
+		}
+		if err := db.RetryQueueEntry(ctx, item.ID, delay); err != nil {
+			t.Fatalf("error retrying queue item: %v", err)
+		}
+	}
+
+	// The item with a delay isn't returned until its next attempt is due
+	item, err := db.PopQueue(ctx, "source1")
+	if err != nil || item == nil || item.URL != "https://example.com/now" || item.Attempts != 1 {
+		t.Fatalf("unexpected queue item: %+v, %v", item, err)
+	}
+	if item, err := db.PopQueue(ctx, "source1"); err != nil || item != nil {
+		t.Fatalf("expected no queue items to be due, got %+v, %v", item, err)
+	}
+}
+
+func TestPopQueueConcurrently(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	urls := make([]string, 20)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%v", i)
+	}
+	if err := db.AddToQueue(ctx, "source1", "", urls, 1, false); err != nil {
+		t.Fatalf("error adding to queue: %v", err)
+	}
+
+	// Every item is claimed by exactly one worker
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	popped := map[string]int{}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, err := db.PopQueue(ctx, "source1")
+				if err != nil {
+					t.Errorf("error popping queue: %v", err)
+					return
+				}
+				if item == nil {
+					return
+				}
+				mu.Lock()
+				popped[item.URL]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(popped) != len(urls) {
+		t.Errorf("expected %v items to be popped, got %v", len(urls), len(popped))
+	}
+	for url, count := range popped {
+		if count != 1 {
+			t.Errorf("%v was popped %v times", url, count)
+		}
+	}
+}
+
+func TestPostponeQueueEntry(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	if err := db.AddToQueue(ctx, "source1", "", []string{"https://example.com/busy"}, 1, false); err != nil {
+		t.Fatalf("error adding to queue: %v", err)
+	}
+	item, err := db.PopQueue(ctx, "source1")
+	if err != nil || item == nil {
+		t.Fatalf("expected a queue item, got %v, %v", item, err)
+	}
+	if err := db.PostponeQueueEntry(ctx, item.ID, time.Hour); err != nil {
+		t.Fatalf("error postponing queue item: %v", err)
+	}
+	if item, err := db.PopQueue(ctx, "source1"); err != nil || item != nil {
+		t.Fatalf("expected no queue items to be due, got %+v, %v", item, err)
+	}
+
+	// Postponing doesn't count as an attempt
+	if err := db.PostponeQueueEntry(ctx, item.ID, 0); err != nil {
+		t.Fatalf("error postponing queue item: %v", err)
+	}
+	item, err = db.PopQueue(ctx, "source1")
+	if err != nil || item == nil || item.Attempts != 0 {
+		t.Fatalf("unexpected queue item: %+v, %v", item, err)
+	}
+}
+
+func TestAddFailure(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	for i := range 2 {
+		if _, err := db.AddDocument(ctx, "source1", 1, []int64{}, "https://example.com/gone", Error, "", "", "", "Not Found", PageMetadata{}); err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+		failures, err := db.AddFailure(ctx, "source1", "https://example.com/gone", "Not Found", true)
+		if err != nil || failures != int32(i+1) {
+			t.Fatalf("unexpected number of failures: %v, %v", failures, err)
+		}
+	}
+
+	// Loading the page successfully resets the count
+	if _, err := db.AddDocument(ctx, "source1", 1, []int64{}, "https://example.com/gone", Finished, "Back again", "", "", "", PageMetadata{}); err != nil {
+		t.Fatalf("error adding document: %v", err)
+	}
+	if failures, err := db.AddFailure(ctx, "source1", "https://example.com/gone", "Not Found", true); err != nil || failures != 1 {
+		t.Fatalf("expected failures to be reset, got %v, %v", failures, err)
+	}
+
+	// Temporary failures are recorded without being counted, and the page keeps its content
+	if failures, err := db.AddFailure(ctx, "source1", "https://example.com/gone", "Service Unavailable", false); err != nil || failures != 1 {
+		t.Fatalf("unexpected number of failures: %v, %v", failures, err)
+	}
+	page, err := db.GetDocument(ctx, "source1", "https://example.com/gone")
+	if err != nil || page == nil || page.Status != Finished || page.Title != "Back again" || page.ErrorInfo != "Service Unavailable" {
+		t.Fatalf("unexpected page after failure: %+v, %v", page, err)
+	}
+
+	if _, err := db.AddFailure(ctx, "source1", "https://example.com/missing", "Not Found", true); err != ErrPageNotFound {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+}
+
 func TestPopQueueWithOtherSource(t *testing.T) {
 	db := createDB(t)
 
@@ -171,7 +479,7 @@ func TestProcessResultEmptyEnd(t *testing.T) {
 func TestHasDocument(t *testing.T) {
 	db := createDB(t)
 
-	db.AddDocument(context.Background(), "source1", 1, []int64{}, "https://example.com/", Finished, "Example Domain", "", "This domain is for use in illustrative examples in documents. You may use this domain in literature without prior coordination or asking for permission.", "")
+	db.AddDocument(context.Background(), "source1", 1, []int64{}, "https://example.com/", Finished, "Example Domain", "", "This domain is for use in illustrative examples in documents. You may use this domain in literature without prior coordination or asking for permission.", "", PageMetadata{})
 
 	res, err := db.HasDocument(context.Background(), "source1", "https://example.com/")
 	if err != nil {
@@ -195,9 +503,10 @@ func TestGetDocument(t *testing.T) {
 		Depth:       1,
 		Status:      Finished,
 		ErrorInfo:   "",
+		Kind:        KindPage,
 	}
 
-	db.AddDocument(context.Background(), page.Source, page.Depth, []int64{}, page.URL, page.Status, page.Title, page.Description, page.Content, page.ErrorInfo)
+	db.AddDocument(context.Background(), page.Source, page.Depth, []int64{}, page.URL, page.Status, page.Title, page.Description, page.Content, page.ErrorInfo, PageMetadata{Kind: page.Kind})
 
 	doc, err := db.GetDocument(context.Background(), "source1", "https://example.com/")
 	if err != nil {
@@ -216,7 +525,7 @@ func TestGetDocument(t *testing.T) {
 func TestDeleteCanonicalsOnDeletePage(t *testing.T) {
 	db := createDB(t)
 
-	_, err := db.AddDocument(context.Background(), "source1", 0, []int64{}, "https://example.com/", Finished, "Title", "Description", "Content", "")
+	_, err := db.AddDocument(context.Background(), "source1", 0, []int64{}, "https://example.com/", Finished, "Title", "Description", "Content", "", PageMetadata{})
 	if err != nil {
 		t.Fatalf("failed to add page: %v", err)
 	}
@@ -242,7 +551,7 @@ func TestDeleteCanonicalsOnDeletePage(t *testing.T) {
 func TestSearchQuery(t *testing.T) {
 	db := createDB(t)
 
-	_, err := db.AddDocument(context.Background(), "source1", 1, []int64{}, "https://example.com/", Finished, "Example Domain", "", "This domain is for use in illustrative examples in documents. You may use this domain in literature without prior coordination or asking for permission.", "")
+	_, err := db.AddDocument(context.Background(), "source1", 1, []int64{}, "https://example.com/", Finished, "Example Domain", "", "This domain is for use in illustrative examples in documents. You may use this domain in literature without prior coordination or asking for permission.", "", PageMetadata{})
 	if err != nil {
 		t.Fatalf("error adding document: %v", err)
 	}
@@ -257,10 +566,13 @@ func TestSearchQuery(t *testing.T) {
 		{phrase: "illustrative examp", expected: 1},
 		{phrase: "illustrative examples", expected: 1},
 		{phrase: "a_nonexistant_word", expected: 0},
+		{phrase: "example OR a_nonexistant_word", expected: 1},
+		{phrase: "example -illustrative", expected: 0},
+		{phrase: "\"domain example\"", expected: 0},
 	}
 
 	for _, testCase := range phrases {
-		results, count, err := db.Search(context.Background(), []string{"source1"}, testCase.phrase, 1, 10)
+		results, count, err := db.Search(context.Background(), []string{"source1"}, testCase.phrase, SearchOptions{}, 1, 10)
 
 		if err != nil {
 			t.Fatalf("error fetching results for query '%v': %v", testCase.phrase, err)
@@ -276,85 +588,1320 @@ func TestSearchQuery(t *testing.T) {
 	}
 }
 
-func TestQueuePagesOlderThan(t *testing.T) {
+func TestSearchFieldsAndSites(t *testing.T) {
+	db := createDB(t)
+
+	pages := []struct {
+		url   string
+		title string
+	}{
+		{url: "https://docs.example.com/guide/install", title: "Installation guide"},
+		{url: "https://docs.example.com/guide", title: "Getting started"},
+		{url: "https://www.example.com/blog/install-day", title: "We love to install things"},
+		{url: "https://example.com:8080/installer", title: "Another page"},
+		{url: "https://notexample.com/install", title: "Unrelated install site"},
+	}
+
+	for _, p := range pages {
+		_, err := db.AddDocument(context.Background(), "source1", 1, []int64{}, p.url, Finished, p.title, "", "Content that mentions install", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "install title:installation", expected: []string{"https://docs.example.com/guide/install"}},
+		{query: "content:install site:docs.example.com/guide", expected: []string{"https://docs.example.com/guide/install", "https://docs.example.com/guide"}},
+		{query: "content:install site:example.com -site:docs.example.com", expected: []string{"https://www.example.com/blog/install-day", "https://example.com:8080/installer"}},
+		{query: "install inurl:blog", expected: []string{"https://www.example.com/blog/install-day"}},
+		{query: "install site:docs.example.com/gui", expected: []string{}},
+	}
+
+	for _, c := range testCases {
+		results, total, err := db.Search(context.Background(), []string{"source1"}, c.query, SearchOptions{}, 1, 10)
+		if err != nil {
+			t.Fatalf("error searching for %v: %v", c.query, err)
+		}
+		urls := make([]string, 0, len(results))
+		for _, res := range results {
+			urls = append(urls, res.URL)
+		}
+		slices.Sort(urls)
+		slices.Sort(c.expected)
+		if !reflect.DeepEqual(urls, c.expected) {
+			t.Fatalf("unexpected results for %v: wanted %v, got %v", c.query, c.expected, urls)
+		}
+		if int(*total) != len(c.expected) {
+			t.Fatalf("unexpected total for %v: wanted %v, got %v", c.query, len(c.expected), *total)
+		}
+	}
+}
+
+func TestFacets(t *testing.T) {
 	db := createDB(t)
+	ctx := context.Background()
+
+	pages := []struct {
+		source string
+		url    string
+	}{
+		{"source1", "https://example.com/"},
+		{"source1", "https://example.com/blog/first-post"},
+		{"source1", "https://example.com/blog/second-post?page=2"},
+		{"source1", "https://Docs.Example.com/guide#install"},
+		{"source2", "https://other.example.org/blog"},
+	}
+	for _, p := range pages {
+		_, err := db.AddDocument(ctx, p.source, 1, []int64{}, p.url, Finished, "Page title", "", "Content that mentions install", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
 
-	_, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "", Finished, "", "", "", "")
+	// Pages that were linked from a feed should be marked as feed items, even if they were crawled first
+	if err := db.AddFeedItems(ctx, "source1", []FeedItem{{URL: "https://example.com/blog/first-post"}}); err != nil {
+		t.Fatalf("unexpected error adding feed items: %v", err)
+	}
+	if err := db.AddFeedItems(ctx, "source2", []FeedItem{{URL: "https://other.example.org/blog/upcoming"}}); err != nil {
+		t.Fatalf("unexpected error adding feed items: %v", err)
+	}
+	_, err := db.AddDocument(ctx, "source2", 1, []int64{}, "https://other.example.org/blog/upcoming", Finished, "Page title", "", "Content that mentions install", "", PageMetadata{Kind: KindPage})
 	if err != nil {
 		t.Fatalf("unexpected error adding document: %v", err)
 	}
-	time.Sleep(1 * time.Second) // Ensure the document is at least 1 second old
-	err = db.QueuePagesOlderThan(context.Background(), "source", 0)
+
+	facets, err := db.Facets(ctx, []string{"source1", "source2"}, "install", SearchOptions{})
 	if err != nil {
-		t.Fatalf("failed to queue pages older than 0 days: %v", err)
+		t.Fatalf("unexpected error counting facets: %v", err)
+	}
+	want := &Facets{
+		Sources:   []FacetValue{{"source1", 4}, {"source2", 2}},
+		Hosts:     []FacetValue{{"example.com", 3}, {"other.example.org", 2}, {"docs.example.com", 1}},
+		Sections:  []FacetValue{{"blog", 4}, {"", 1}, {"guide", 1}},
+		Kinds:     []FacetValue{{KindPage, 4}, {KindFeedItem, 2}},
+		Languages: []FacetValue{},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Fatalf("unexpected facets: wanted %+v, got %+v", want, facets)
 	}
 
-	doc, err := db.PopQueue(context.Background(), "source")
+	// Each facet's counts should include the other filters, but not its own
+	options := SearchOptions{Host: "example.com", Kind: KindFeedItem}
+	facets, err = db.Facets(ctx, []string{"source1", "source2"}, "install", options)
 	if err != nil {
-		t.Fatalf("error popping queue: %v", err)
+		t.Fatalf("unexpected error counting facets: %v", err)
 	}
-	if doc == nil {
-		t.Fatalf("page was not queued; expected to be able to pop 1 item off the queue")
+	want = &Facets{
+		Sources:   []FacetValue{{"source1", 1}},
+		Hosts:     []FacetValue{{"example.com", 1}, {"other.example.org", 1}},
+		Sections:  []FacetValue{{"blog", 1}},
+		Kinds:     []FacetValue{{KindPage, 2}, {KindFeedItem, 1}},
+		Languages: []FacetValue{},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Fatalf("unexpected facets: wanted %+v, got %+v", want, facets)
 	}
 
-	doc, err = db.PopQueue(context.Background(), "source")
+	results, total, err := db.Search(ctx, []string{"source1", "source2"}, "install", options, 1, 10)
 	if err != nil {
-		t.Fatalf("error popping queue: %v", err)
+		t.Fatalf("unexpected error searching: %v", err)
 	}
-	if doc != nil {
-		t.Fatalf("more than one page was queued; expected exactly 1 page in the queue")
+	if *total != 1 || len(results) != 1 || results[0].URL != "https://example.com/blog/first-post" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	results, total, err = db.Search(ctx, []string{"source1", "source2"}, "install", SearchOptions{Section: "blog", Host: "other.example.org"}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error searching: %v", err)
+	}
+	if *total != 2 || len(results) != 2 {
+		t.Fatalf("unexpected results: %+v", results)
 	}
 }
 
-func TestSpellfix(t *testing.T) {
+func TestDates(t *testing.T) {
 	db := createDB(t)
+	ctx := context.Background()
 
-	_, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "", Finished, "The quick brown fox jumped over the lazy dog", "", "", "")
-	if err != nil {
-		t.Fatalf("unexpected error adding document: %v", err)
+	date := func(str string) *time.Time {
+		t, _ := time.Parse(time.DateOnly, str)
+		return &t
 	}
 
-	err = db.CreateSpellfixIndex(context.Background())
-	if err != nil {
-		t.Fatalf("failed to create spellfix index: %v\n", err)
+	// Feeds and sitemaps can be crawled before or after the pages they link to
+	if err := db.AddFeedItems(ctx, "source1", []FeedItem{{URL: "https://example.com/feed-first", PublishedAt: date("2024-02-01")}}); err != nil {
+		t.Fatalf("unexpected error adding feed items: %v", err)
+	}
+	if err := db.AddSitemapEntries(ctx, "source1", []SitemapEntry{{URL: "https://example.com/sitemap-first", LastModified: date("2024-03-01")}}); err != nil {
+		t.Fatalf("unexpected error adding sitemap entries: %v", err)
 	}
 
-	str, err := db.Spellfix(context.Background(), "The quicg browg fog jumpeg ovrr the lazg dog")
-	if err != nil {
-		t.Fatalf("error during spellfix: %v\n", err)
+	pages := []struct {
+		url      string
+		metadata PageMetadata
+	}{
+		// The page's own dates take precedence over the feed's
+		{"https://example.com/own-dates", PageMetadata{PublishedAt: date("2024-01-01"), ModifiedAt: date("2024-01-15")}},
+		{"https://example.com/feed-first", PageMetadata{}},
+		{"https://example.com/feed-later", PageMetadata{}},
+		{"https://example.com/sitemap-first", PageMetadata{LastModified: date("2020-01-01")}},
+		{"https://example.com/header-only", PageMetadata{LastModified: date("2023-06-01")}},
+		{"https://example.com/undated", PageMetadata{}},
+	}
+	for _, p := range pages {
+		_, err := db.AddDocument(ctx, "source1", 1, []int64{}, p.url, Finished, "Page title", "", "Content", "", p.metadata)
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
 	}
 
-	if str != "the quick brown fox jumped over the lazy dog" {
-		t.Fatalf("unexpected spellfix return: expected 'the quick brown fox jumped over the lazy dog', got '%v'\n", str)
+	if err := db.AddFeedItems(ctx, "source1", []FeedItem{
+		{URL: "https://example.com/own-dates", PublishedAt: date("2023-01-01")},
+		{URL: "https://example.com/feed-later", PublishedAt: date("2024-04-01"), ModifiedAt: date("2024-04-02")},
+	}); err != nil {
+		t.Fatalf("unexpected error adding feed items: %v", err)
+	}
+
+	str := func(s string) *string { return &s }
+	expected := map[string][2]*string{
+		"https://example.com/own-dates":     {str("2024-01-01T00:00:00Z"), str("2024-01-15T00:00:00Z")},
+		"https://example.com/feed-first":    {str("2024-02-01T00:00:00Z"), nil},
+		"https://example.com/feed-later":    {str("2024-04-01T00:00:00Z"), str("2024-04-02T00:00:00Z")},
+		"https://example.com/sitemap-first": {nil, str("2024-03-01T00:00:00Z")},
+		"https://example.com/header-only":   {nil, str("2023-06-01T00:00:00Z")},
+		"https://example.com/undated":       {nil, nil},
+	}
+	for url, dates := range expected {
+		page, err := db.GetDocument(ctx, "source1", url)
+		if err != nil {
+			t.Fatalf("unexpected error getting document: %v", err)
+		}
+		if !reflect.DeepEqual([2]*string{page.PublishedAt, page.ModifiedAt}, dates) {
+			t.Errorf("unexpected dates for %v: wanted %v, got %v", url, dates, [2]*string{page.PublishedAt, page.ModifiedAt})
+		}
+	}
+
+	urls := func(options SearchOptions) []string {
+		results, total, err := db.Search(ctx, []string{"source1"}, "content", options, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching: %v", err)
+		}
+		if int(*total) != len(results) {
+			t.Fatalf("unexpected total: wanted %v, got %v", len(results), *total)
+		}
+		urls := make([]string, 0, len(results))
+		for _, res := range results {
+			urls = append(urls, res.URL)
+		}
+		return urls
+	}
+
+	// Undated pages are sorted last
+	got := urls(SearchOptions{Sort: SortDate})
+	want := []string{
+		"https://example.com/feed-later",
+		"https://example.com/sitemap-first",
+		"https://example.com/feed-first",
+		"https://example.com/own-dates",
+		"https://example.com/header-only",
+		"https://example.com/undated",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected results sorted by date: wanted %v, got %v", want, got)
+	}
+
+	// Date filters exclude undated pages
+	got = urls(SearchOptions{Sort: SortDate, After: date("2024-01-01"), Before: date("2024-03-01")})
+	want = []string{"https://example.com/feed-first", "https://example.com/own-dates"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected results filtered by date: wanted %v, got %v", want, got)
 	}
 }
 
-// When a document is added, if one already exists with the same source and URL, the existing page should be updated instead of creating a new row.
-// However, if the ID changes and the page's referrer was set to itself, then the page's referrers will fail to be recorded.
-// This test makes sure that doesn't happen.
-func TestAddDocumentUpdateRow(t *testing.T) {
+func TestAuthority(t *testing.T) {
 	db := createDB(t)
+	ctx := context.Background()
 
-	oldPageID, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "http://url.test", Finished, "The quick brown fox jumped over the lazy dog", "", "", "")
-	if err != nil {
+	referrers := []int64{}
+	for _, url := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		id, err := db.AddDocument(ctx, "source1", 1, []int64{}, url, Finished, "Other page", "", "Unrelated content", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+		referrers = append(referrers, id)
+	}
+
+	// The orphan page matches the query better, but the hub page is linked from every other page
+	if _, err := db.AddDocument(ctx, "source1", 0, referrers, "https://example.com/", Finished, "Home", "", "Welcome! Read the installation guide to install the app and learn what it can do.", "", PageMetadata{}); err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	if _, err := db.AddDocument(ctx, "source1", 5, []int64{}, "https://example.com/orphan", Finished, "Orphan", "", "Install, install, install.", "", PageMetadata{}); err != nil {
 		t.Fatalf("unexpected error adding document: %v", err)
 	}
 
-	newPageID, err := db.AddDocument(context.Background(), "source", 1, []int64{oldPageID}, "http://url.test", Finished, "New page content", "New description", "", "")
-	if err != nil {
-		t.Fatalf("unexpected error adding second document: %v", err)
+	if err := db.UpdateAuthority(ctx, "source1"); err != nil {
+		t.Fatalf("unexpected error updating authority: %v", err)
 	}
 
-	page, err := db.GetDocumentByID(context.Background(), newPageID)
+	first := func(options SearchOptions) string {
+		results, _, err := db.Search(ctx, []string{"source1"}, "install", options, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+
+		hybrid, _, err := db.HybridSearch(ctx, []string{"source1"}, "install", options, map[string][]float32{}, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error running hybrid search: %v", err)
+		}
+		if len(hybrid) != 2 || hybrid[0].URL != results[0].URL {
+			t.Fatalf("expected hybrid search to rank %v first, got %+v", results[0].URL, hybrid)
+		}
+		return results[0].URL
+	}
+
+	if url := first(SearchOptions{}); url != "https://example.com/orphan" {
+		t.Fatalf("expected the orphan page to rank first without an authority weight, got %v", url)
+	}
+	weighted := DefaultRanking
+	weighted.AuthorityWeight = 10
+	if url := first(SearchOptions{Ranking: map[string]Ranking{"source1": weighted}}); url != "https://example.com/" {
+		t.Fatalf("expected the hub page to rank first with an authority weight, got %v", url)
+	}
+	// The weights only apply to their own source
+	if url := first(SearchOptions{Ranking: map[string]Ranking{"source2": weighted}}); url != "https://example.com/orphan" {
+		t.Fatalf("expected the orphan page to rank first when another source has an authority weight, got %v", url)
+	}
+}
+
+func TestRanking(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	pages := []struct {
+		url   string
+		depth int32
+	}{
+		{"https://example.com/docs/install", 1},
+		{"https://example.com/tag/install", 2},
+		{"https://example.com/blog/post", 3},
+	}
+	for _, p := range pages {
+		_, err := db.AddDocument(ctx, "source1", p.depth, []int64{}, p.url, Finished, "Page title", "", "How to install the app", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
+
+	search := func(ranking Ranking) []FTSResult {
+		results, _, err := db.Search(ctx, []string{"source1"}, "install", SearchOptions{Ranking: map[string]Ranking{"source1": ranking}, Explain: true}, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching: %v", err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		return results
+	}
+
+	// The pages with "install" in their URLs rank higher by default
+	if results := search(DefaultRanking); results[2].URL != "https://example.com/blog/post" {
+		t.Fatalf("expected the page without the query in its URL to rank last, got %+v", results)
+	}
+
+	// Without the URL field, only the boosts affect the order
+	ranking := DefaultRanking
+	ranking.FieldWeights.URL = 0
+	ranking.DepthBoost = 1
+	ranking.URLRules = []URLRule{{Contains: "/blog/", Multiplier: 3}, {Contains: "/tag/", Multiplier: 0.5}}
+	results := search(ranking)
+
+	urls := []string{results[0].URL, results[1].URL, results[2].URL}
+	want := []string{"https://example.com/blog/post", "https://example.com/docs/install", "https://example.com/tag/install"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Fatalf("unexpected order: wanted %v, got %v", want, urls)
+	}
+
+	explanation := results[0].Explanation
+	if explanation == nil || explanation.DepthBoost != 1.25 || explanation.URLBoost != 3 || explanation.AuthorityBoost != 1 || explanation.BM25 >= 0 {
+		t.Fatalf("unexpected explanation: %+v", explanation)
+	}
+	if explanation.Score != results[0].Rank || explanation.Score != explanation.BM25*explanation.DepthBoost*explanation.URLBoost {
+		t.Fatalf("expected the explanation's score to match the result's rank: %+v, %v", explanation, results[0].Rank)
+	}
+
+	ranking.RRFConstant = 10
+	hybrid, _, err := db.HybridSearch(ctx, []string{"source1"}, "install", SearchOptions{Ranking: map[string]Ranking{"source1": ranking}, Explain: true}, map[string][]float32{}, 1, 10)
 	if err != nil {
-		t.Fatalf("failed to get document by id: %v\n", err)
+		t.Fatalf("unexpected error running hybrid search: %v", err)
+	}
+	if len(hybrid) != 3 || hybrid[0].URL != "https://example.com/blog/post" {
+		t.Fatalf("unexpected hybrid results: %+v", hybrid)
+	}
+	if hybrid[0].Explanation == nil || hybrid[0].Explanation.FTS == nil || hybrid[0].Explanation.FTSContribution != 1.0/11 || hybrid[0].HybridRank != 1.0/11 {
+		t.Fatalf("unexpected hybrid explanation: %+v", hybrid[0].Explanation)
 	}
 
-	if page.Title != "New page content" {
-		t.Fatalf("unexpected page title: '%v' != '%v'", page.Title, "New page content")
+	// Sources with different rankings can be searched together
+	multi, _, err := db.Search(ctx, []string{"source1", "source2"}, "install", SearchOptions{Ranking: map[string]Ranking{"source1": ranking, "source2": DefaultRanking}, Explain: true}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error searching multiple sources: %v", err)
+	}
+	if len(multi) != 3 || multi[0].URL != "https://example.com/blog/post" {
+		t.Fatalf("unexpected results: %+v", multi)
 	}
+}
 
-	if page.Description != "New description" {
+func TestCurations(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	for i, url := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c", "https://example.com/unrelated"} {
+		content := "How to install the app"
+		if url == "https://example.com/unrelated" {
+			content = "Something else entirely"
+		}
+		_, err := db.AddDocument(ctx, "source1", int32(i+1), []int64{}, url, Finished, "Page title", "", content, "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
+
+	id, err := db.SaveCuration(ctx, Curation{
+		Source:  "source1",
+		Pattern: "  Install ",
+		Match:   MatchContains,
+		Pinned:  []string{"https://example.com/unrelated", "https://example.com/missing"},
+		Hidden:  []string{"https://example.com/b"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error saving curation: %v", err)
+	}
+
+	curation, err := db.GetCuration(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error getting curation: %v", err)
+	}
+	if curation.Pattern != "install" || curation.Match != MatchContains || !reflect.DeepEqual(curation.Pinned, []string{"https://example.com/unrelated", "https://example.com/missing"}) || !reflect.DeepEqual(curation.Hidden, []string{"https://example.com/b"}) {
+		t.Fatalf("unexpected curation: %+v", curation)
+	}
+
+	search := func(query string, page uint32, pageSize uint32) ([]FTSResult, *uint32) {
+		results, total, err := db.Search(ctx, []string{"source1"}, query, SearchOptions{}, page, pageSize)
+		if err != nil {
+			t.Fatalf("unexpected error searching: %v", err)
+		}
+		return results, total
+	}
+
+	// The pinned page is shown first even though it doesn't match, and the hidden page is removed. Missing pages are skipped.
+	results, total := search("how to install", 1, 10)
+	if len(results) != 3 || *total != 3 || results[0].URL != "https://example.com/unrelated" || !results[0].Pinned || results[1].Pinned {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	for _, res := range results {
+		if res.URL == "https://example.com/b" {
+			t.Fatalf("expected hidden page to be excluded: %+v", results)
+		}
+	}
+
+	// The pinned result takes up a slot on the first page, which shifts the organic results onto later pages
+	first, _ := search("how to install", 1, 2)
+	second, _ := search("how to install", 2, 2)
+	if len(first) != 2 || len(second) != 1 || first[0].URL != "https://example.com/unrelated" || second[0].Pinned || first[1].URL == second[0].URL {
+		t.Fatalf("unexpected pages: %+v, %+v", first, second)
+	}
+
+	// "contains" curations only match whole words
+	if results, _ := search("installing", 1, 10); slices.ContainsFunc(results, func(res FTSResult) bool { return res.Pinned }) {
+		t.Fatalf("expected curation not to apply: %+v", results)
+	}
+
+	hybrid, _, err := db.HybridSearch(ctx, []string{"source1"}, "install", SearchOptions{}, map[string][]float32{}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error running hybrid search: %v", err)
+	}
+	if len(hybrid) != 3 || hybrid[0].URL != "https://example.com/unrelated" || !hybrid[0].Pinned {
+		t.Fatalf("unexpected hybrid results: %+v", hybrid)
+	}
+
+	// Hybrid search results are paged the same way
+	firstHybrid, total, err := db.HybridSearch(ctx, []string{"source1"}, "install", SearchOptions{}, map[string][]float32{}, 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error running hybrid search: %v", err)
+	}
+	secondHybrid, _, err := db.HybridSearch(ctx, []string{"source1"}, "install", SearchOptions{}, map[string][]float32{}, 2, 2)
+	if err != nil {
+		t.Fatalf("unexpected error running hybrid search: %v", err)
+	}
+	if *total != 3 || len(firstHybrid) != 2 || len(secondHybrid) != 1 || !firstHybrid[0].Pinned || secondHybrid[0].URL != hybrid[2].URL {
+		t.Fatalf("unexpected hybrid pages: %+v, %+v", firstHybrid, secondHybrid)
+	}
+
+	// Updating a curation replaces its URLs
+	curation.Match = MatchExact
+	curation.Pinned = []string{}
+	if _, err := db.SaveCuration(ctx, *curation); err != nil {
+		t.Fatalf("unexpected error updating curation: %v", err)
+	}
+	if results, _ := search("how to install", 1, 10); len(results) != 3 || results[0].Pinned {
+		t.Fatalf("expected exact curation not to apply: %+v", results)
+	}
+	if results, _ := search("INSTALL", 1, 10); len(results) != 2 {
+		t.Fatalf("expected exact curation to hide a page: %+v", results)
+	}
+
+	curations, err := db.ListCurations(ctx, "source1")
+	if err != nil || len(curations) != 1 || len(curations[0].Pinned) != 0 {
+		t.Fatalf("unexpected curations: %+v, %v", curations, err)
+	}
+
+	if err := db.DeleteCuration(ctx, id); err != nil {
+		t.Fatalf("unexpected error deleting curation: %v", err)
+	}
+	if _, err := db.GetCuration(ctx, id); !errors.Is(err, ErrCurationNotFound) {
+		t.Fatalf("expected ErrCurationNotFound, got %v", err)
+	}
+	if err := db.DeleteCuration(ctx, id); !errors.Is(err, ErrCurationNotFound) {
+		t.Fatalf("expected ErrCurationNotFound, got %v", err)
+	}
+	if _, err := db.SaveCuration(ctx, Curation{ID: id, Source: "source1", Pattern: "x", Match: MatchExact}); !errors.Is(err, ErrCurationNotFound) {
+		t.Fatalf("expected ErrCurationNotFound, got %v", err)
+	}
+}
+
+func TestSynonyms(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	_, err := db.AddDocument(ctx, "source1", 1, []int64{}, "https://example.com/account", Finished, "Account help", "", "To change your password, sign in and open the settings page.", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+
+	synonyms := query.NewSynonyms()
+	synonyms.AddEquivalent([]string{"login", "log in", "sign in"})
+	options := SearchOptions{Synonyms: map[string]*query.Synonyms{"source1": synonyms}}
+
+	if results, _, err := db.Search(ctx, []string{"source1"}, "login password", SearchOptions{}, 1, 10); err != nil || len(results) != 0 {
+		t.Fatalf("expected no results without synonyms: %+v, %v", results, err)
+	}
+
+	results, _, err := db.Search(ctx, []string{"source1"}, "login password", options, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error searching: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %+v", results)
+	}
+
+	// The synonym is highlighted like the rest of the query
+	highlighted := []string{}
+	for _, match := range results[0].Content {
+		if match.Highlighted {
+			highlighted = append(highlighted, match.Content)
+		}
+	}
+	if !reflect.DeepEqual(highlighted, []string{"password", "sign in"}) {
+		t.Fatalf("unexpected highlights: %v", highlighted)
+	}
+
+	hybrid, _, err := db.HybridSearch(ctx, []string{"source1"}, "login", options, map[string][]float32{}, 1, 10)
+	if err != nil || len(hybrid) != 1 {
+		t.Fatalf("unexpected hybrid results: %+v, %v", hybrid, err)
+	}
+
+	// Synonyms only apply to the sources they're configured for
+	if results, _, err := db.Search(ctx, []string{"source1"}, "login", SearchOptions{Synonyms: map[string]*query.Synonyms{"source2": synonyms}}, 1, 10); err != nil || len(results) != 0 {
+		t.Fatalf("expected no results with another source's synonyms: %+v, %v", results, err)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	alternates := []string{"https://example.com/en/guide", "https://example.com/de/guide"}
+	pages := []struct {
+		url        string
+		language   string
+		alternates []string
+		content    string
+	}{
+		{"https://example.com/en/guide", "en", alternates, "Easysearch is a search engine for your website"},
+		{"https://example.com/de/guide", "de", alternates, "Easysearch ist eine Suchmaschine für Ihre Website"},
+		{"https://example.com/fr/other", "fr", []string{}, "Easysearch est un moteur de recherche"},
+	}
+	for _, p := range pages {
+		_, err := db.AddDocument(ctx, "source1", 1, []int64{}, p.url, Finished, "Easysearch", "", p.content, "", PageMetadata{Language: p.language, Alternates: p.alternates})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
+
+	search := func(options SearchOptions) ([]FTSResult, uint32) {
+		results, total, err := db.Search(ctx, []string{"source1"}, "easysearch", options, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching: %v", err)
+		}
+		return results, *total
+	}
+
+	// Translations of the same page are only shown once
+	results, total := search(SearchOptions{})
+	if total != 2 || len(results) != 2 {
+		t.Fatalf("expected translations to be grouped: %+v", results)
+	}
+
+	// The translation in the user's preferred language is chosen
+	for _, language := range []string{"en", "de"} {
+		results, _ := search(SearchOptions{PreferredLanguages: []LanguagePreference{{Language: language, Weight: 1}}, Explain: true})
+		if len(results) != 2 || *results[0].Language != language || results[0].Explanation.LanguageBoost != 2 {
+			t.Fatalf("expected the %v page first: %+v", language, results)
+		}
+	}
+
+	results, total = search(SearchOptions{Language: "fr"})
+	if total != 1 || len(results) != 1 || results[0].URL != "https://example.com/fr/other" {
+		t.Fatalf("unexpected results with a language filter: %+v", results)
+	}
+
+	facets, err := db.Facets(ctx, []string{"source1"}, "easysearch", SearchOptions{Language: "fr"})
+	if err != nil {
+		t.Fatalf("unexpected error counting facets: %v", err)
+	}
+	if !reflect.DeepEqual(facets.Languages, []FacetValue{{"de", 1}, {"en", 1}, {"fr", 1}}) {
+		t.Fatalf("unexpected language facets: %+v", facets.Languages)
+	}
+
+	hybrid, _, err := db.HybridSearch(ctx, []string{"source1"}, "easysearch", SearchOptions{PreferredLanguages: []LanguagePreference{{Language: "de", Weight: 1}}}, map[string][]float32{}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error running hybrid search: %v", err)
+	}
+	if len(hybrid) != 2 || hybrid[0].URL != "https://example.com/de/guide" {
+		t.Fatalf("unexpected hybrid results: %+v", hybrid)
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	article := strings.Join([]string{
+		"Easysearch is a simple search engine for your website. It crawls your pages, indexes their text, and serves results through an API or a built-in results page that you can customize.",
+		"To get started, write a config file that lists the sites you want to search. Each source has a base URL, a list of allowed domains, and a maximum crawl depth.",
+		"Pages are refreshed on a schedule, so the index stays up to date as you publish new content. Links between pages are used to rank well-connected pages higher.",
+		"If you enable embeddings, Easysearch also runs a vector similarity search and combines it with the full-text search results using reciprocal rank fusion.",
+		"Spelling correction, suggestions, facets, and curated results are built in, and every feature can be configured separately for each source.",
+	}, " ")
+	pages := []struct {
+		url     string
+		content string
+	}{
+		{"https://example.com/article", article},
+		// The print view only adds a few words, so it's a near-duplicate of the article
+		{"https://example.com/article/print", "Print view. " + article + " Print"},
+		{"https://example.com/other", "Easysearch also supports hybrid search, which combines full-text search with vector similarity search using embeddings from an OpenAI-compatible API."},
+		{"https://docs.example.com/guide", "The Easysearch guide explains how to write a config file, add search sources, and tune the ranking of results for each of your sites."},
+	}
+	for _, p := range pages {
+		_, err := db.AddDocument(ctx, "source1", 1, []int64{}, p.url, Finished, "Easysearch", "", p.content, "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
+
+	search := func(options SearchOptions) ([]FTSResult, uint32) {
+		results, total, err := db.Search(ctx, []string{"source1"}, "easysearch", options, 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching: %v", err)
+		}
+		return results, *total
+	}
+	collapsedCounts := func(results []FTSResult) map[string]uint32 {
+		counts := make(map[string]uint32, len(results))
+		for _, res := range results {
+			counts[res.URL] = res.Collapsed
+		}
+		return counts
+	}
+
+	results, total := search(SearchOptions{})
+	if total != 4 || len(results) != 4 {
+		t.Fatalf("expected all pages without collapsing: %+v", results)
+	}
+
+	results, total = search(SearchOptions{Collapse: CollapseHost})
+	if total != 2 || len(results) != 2 {
+		t.Fatalf("expected one result per host: %+v", results)
+	}
+	counts := collapsedCounts(results)
+	if counts["https://docs.example.com/guide"] != 0 || len(counts) != 2 {
+		t.Fatalf("unexpected collapsed counts: %+v", counts)
+	}
+	for url, count := range counts {
+		if strings.HasPrefix(url, "https://example.com/") && count != 2 {
+			t.Fatalf("expected 2 more results from example.com, got %v", count)
+		}
+	}
+
+	results, total = search(SearchOptions{Collapse: CollapseDuplicates})
+	if total != 3 || len(results) != 3 {
+		t.Fatalf("expected the print view to be collapsed: %+v", results)
+	}
+	counts = collapsedCounts(results)
+	if counts["https://example.com/other"] != 0 || counts["https://docs.example.com/guide"] != 0 {
+		t.Fatalf("unexpected collapsed counts: %+v", counts)
+	}
+
+	// The collapsed duplicates can be listed
+	results, total = search(SearchOptions{DuplicatesOf: "https://example.com/article"})
+	if total != 2 || len(results) != 2 || results[0].Collapsed != 0 {
+		t.Fatalf("expected the article and its print view: %+v", results)
+	}
+	for _, res := range results {
+		if !strings.HasPrefix(res.URL, "https://example.com/article") {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	for input, want := range map[string]string{"en-US": "en", "DE": "de", " pt_BR ": "pt", "zh-Hant-TW": "zh", "x-default": "", "": "", "not a language": ""} {
+		if got := NormalizeLanguage(input); got != want {
+			t.Errorf("expected NormalizeLanguage(%q) to be %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestHybridSearchWithSite(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	if err := db.SetupVectorTables(ctx, "source1", 3); err != nil {
+		t.Fatalf("error setting up vector tables: %v", err)
+	}
+
+	for i, url := range []string{"https://a.example.com/page", "https://b.example.com/page"} {
+		id, err := db.AddDocument(ctx, "source1", 1, []int64{}, url, Finished, "Page title", "", "Page content", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+		if err := db.AddEmbedding(ctx, id, "source1", 0, "Page content", []float32{1, float32(i), 0}); err != nil {
+			t.Fatalf("error adding embedding: %v", err)
+		}
+	}
+
+	results, _, err := db.HybridSearch(ctx, []string{"source1"}, "content site:b.example.com", SearchOptions{}, map[string][]float32{"source1": {1, 0, 0}}, 1, 10)
+	if err != nil {
+		t.Fatalf("error running hybrid search: %v", err)
+	}
+
+	if len(results) != 1 || results[0].URL != "https://b.example.com/page" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
+
+func TestSimilaritySearchTotal(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	if err := db.SetupVectorTables(ctx, "source1", 3); err != nil {
+		t.Fatalf("error setting up vector tables: %v", err)
+	}
+	// Three pages with several chunks each, so there are more chunks than pages
+	pages := [][][]float32{
+		{{1, 0, 0}, {0.9, 0.1, 0}, {0.8, 0.2, 0}},
+		{{0.7, 0.3, 0}, {0, 1, 0}},
+		{{0.6, 0, 0.4}, {0, 0, 1}, {0, 0.5, 0.5}},
+	}
+	for i, chunks := range pages {
+		id, err := db.AddDocument(ctx, "source1", 1, []int64{}, fmt.Sprintf("https://example.com/%v", i), Finished, "Page title", "", "Page content", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+		for j, chunk := range chunks {
+			if err := db.AddEmbedding(ctx, id, "source1", j, fmt.Sprintf("Chunk %v", j), chunk); err != nil {
+				t.Fatalf("error adding embedding: %v", err)
+			}
+		}
+	}
+
+	// Each page is returned once, with its closest chunk
+	results, total, err := db.SimilaritySearch(ctx, "source1", []float32{1, 0, 0}, 1)
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if len(results) != 1 || results[0].URL != "https://example.com/0" || results[0].Chunk != "Chunk 0" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if total == nil || *total != uint32(len(pages)) {
+		t.Fatalf("expected the total to count pages, got %v", total)
+	}
+
+	// Every result within the total can be reached by paging through the results one at a time
+	seen := map[string]bool{}
+	for page := 1; page <= int(*total); page++ {
+		results, _, err := db.SimilaritySearch(ctx, "source1", []float32{1, 0, 0}, page)
+		if err != nil {
+			t.Fatalf("error searching: %v", err)
+		}
+		if len(results) != page {
+			t.Fatalf("expected %v results for page %v, got %+v", page, page, results)
+		}
+		last := results[page-1]
+		if seen[last.URL] {
+			t.Fatalf("page %v repeats %v", page, last.URL)
+		}
+		seen[last.URL] = true
+	}
+}
+
+func TestEvenlySpaced(t *testing.T) {
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if got := evenlySpaced(items, 4); !reflect.DeepEqual(got, []int{0, 3, 6, 9}) {
+		t.Fatalf("unexpected items: %v", got)
+	}
+	if got := evenlySpaced(items, 20); !reflect.DeepEqual(got, items) {
+		t.Fatalf("expected all items when there are fewer than the limit, got %v", got)
+	}
+}
+
+func TestSimilarPages(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	if err := db.SetupVectorTables(ctx, "source1", 3); err != nil {
+		t.Fatalf("error setting up vector tables: %v", err)
+	}
+
+	pages := []struct {
+		url    string
+		chunks [][]float32
+	}{
+		{"https://example.com/original", [][]float32{{1, 0, 0}, {0, 1, 0}}},
+		{"https://example.com/first-half", [][]float32{{1, 0, 0}}},
+		{"https://example.com/second-half", [][]float32{{0, 1, 0.1}}},
+		{"https://example.com/both-halves", [][]float32{{1, 1, 0}}},
+		{"https://example.com/unrelated", [][]float32{{0, 0, 1}}},
+		{"https://example.com/not-embedded", nil},
+	}
+	for _, p := range pages {
+		id, err := db.AddDocument(ctx, "source1", 1, []int64{}, p.url, Finished, "Page title", "", "Page content", "", PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+		for i, chunk := range p.chunks {
+			if err := db.AddEmbedding(ctx, id, "source1", i, fmt.Sprintf("Chunk %v", i), chunk); err != nil {
+				t.Fatalf("error adding embedding: %v", err)
+			}
+		}
+	}
+
+	urls := func(results []SimilarityResult) []string {
+		urls := make([]string, len(results))
+		for i, res := range results {
+			urls[i] = res.URL
+		}
+		return urls
+	}
+
+	// The average of the page's chunks is closest to a page that covers both of them
+	results, err := db.SimilarPages(ctx, "source1", "https://example.com/original", AggregateMean, 2)
+	if err != nil {
+		t.Fatalf("error finding similar pages: %v", err)
+	}
+	if !reflect.DeepEqual(urls(results), []string{"https://example.com/both-halves", "https://example.com/first-half"}) || results[0].Similarity < 0.99 {
+		t.Fatalf("unexpected results with the mean of the chunks: %+v", results)
+	}
+
+	// Pages that match one chunk exactly are closest to the page's best chunk
+	results, err = db.SimilarPages(ctx, "source1", "https://example.com/original", AggregateBest, 3)
+	if err != nil {
+		t.Fatalf("error finding similar pages: %v", err)
+	}
+	if !reflect.DeepEqual(urls(results), []string{"https://example.com/first-half", "https://example.com/second-half", "https://example.com/both-halves"}) {
+		t.Fatalf("unexpected results with the best chunks: %+v", results)
+	}
+
+	chunks, err := db.RelevantChunks(ctx, "source1", []float32{0, 1, 0}, []string{"https://example.com/original", "https://example.com/unrelated", "https://example.com/not-embedded"}, 1)
+	if err != nil {
+		t.Fatalf("error finding relevant chunks: %v", err)
+	}
+	if !reflect.DeepEqual(chunks, map[string][]string{"https://example.com/original": {"Chunk 1"}, "https://example.com/unrelated": {"Chunk 0"}}) {
+		t.Fatalf("unexpected relevant chunks: %+v", chunks)
+	}
+
+	if _, err := db.SimilarPages(ctx, "source1", "https://example.com/not-embedded", AggregateMean, 3); err != ErrNoEmbeddings {
+		t.Fatalf("expected ErrNoEmbeddings, got %v", err)
+	}
+	if _, err := db.SimilarPages(ctx, "source1", "https://example.com/missing", AggregateMean, 3); err != ErrPageNotFound {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+}
+
+func TestRelatedPages(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	pages := []struct {
+		url     string
+		content string
+	}{
+		{"https://example.com/sourdough", "How to bake sourdough bread: feed the sourdough starter, mix the dough, and bake the bread in a hot oven."},
+		{"https://example.com/starter", "Keeping a sourdough starter alive means feeding the starter flour and water every day."},
+		{"https://example.com/oven", "Preheat the oven before you bake anything."},
+		{"https://example.com/garden", "Planting tomatoes in the garden is easy in the spring."},
+	}
+	for _, p := range pages {
+		if _, err := db.AddDocument(ctx, "source1", 1, []int64{}, p.url, Finished, "Recipes", "", p.content, "", PageMetadata{}); err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+	}
+
+	// Words are counted with the source's vocabulary, which is built along with its spelling correction dictionary
+	if err := db.UpdateSpellfixIndex(ctx); err != nil {
+		t.Fatalf("error updating vocabulary: %v", err)
+	}
+
+	results, err := db.RelatedPages(ctx, "source1", "https://example.com/sourdough", 10)
+	if err != nil {
+		t.Fatalf("error finding related pages: %v", err)
+	}
+	if len(results) != 2 || results[0].URL != "https://example.com/starter" || results[0].Similarity != 1 || results[1].URL != "https://example.com/oven" {
+		t.Fatalf("unexpected related pages: %+v", results)
+	}
+
+	if _, err := db.RelatedPages(ctx, "source1", "https://example.com/missing", 10); err != ErrPageNotFound {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+}
+
+func TestQueuePagesOlderThan(t *testing.T) {
+	db := createDB(t)
+
+	_, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "", Finished, "", "", "", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	time.Sleep(1 * time.Second) // Ensure the document is at least 1 second old
+	err = db.QueuePagesOlderThan(context.Background(), "source", 0)
+	if err != nil {
+		t.Fatalf("failed to queue pages older than 0 days: %v", err)
+	}
+
+	doc, err := db.PopQueue(context.Background(), "source")
+	if err != nil {
+		t.Fatalf("error popping queue: %v", err)
+	}
+	if doc == nil {
+		t.Fatalf("page was not queued; expected to be able to pop 1 item off the queue")
+	}
+
+	doc, err = db.PopQueue(context.Background(), "source")
+	if err != nil {
+		t.Fatalf("error popping queue: %v", err)
+	}
+	if doc != nil {
+		t.Fatalf("more than one page was queued; expected exactly 1 page in the queue")
+	}
+}
+
+func TestSpellfix(t *testing.T) {
+	db := createDB(t)
+
+	_, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "", Finished, "The quick brown fox jumped over the lazy dog", "", "", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+
+	err = db.UpdateSpellfixIndex(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create spellfix index: %v\n", err)
+	}
+
+	str, err := db.Spellfix(context.Background(), []string{"source"}, "The quicg browg fog jumpeg ovrr the lazg dog")
+	if err != nil {
+		t.Fatalf("error during spellfix: %v\n", err)
+	}
+
+	if str != "the quick brown fox jumped over the lazy dog" {
+		t.Fatalf("unexpected spellfix return: expected 'the quick brown fox jumped over the lazy dog', got '%v'\n", str)
+	}
+}
+
+func TestSpellfixPerSource(t *testing.T) {
+	db := createDB(t)
+
+	_, err := db.AddDocument(context.Background(), "a", 1, []int64{}, "https://a.example.com/", Finished, "Kubernetes", "", "Deploying with kubernetes", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	_, err = db.AddDocument(context.Background(), "b", 1, []int64{}, "https://b.example.com/", Finished, "Gardening", "", "Planting tomatoes", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+
+	err = db.UpdateSpellfixIndex(context.Background())
+	if err != nil {
+		t.Fatalf("failed to update spellfix index: %v\n", err)
+	}
+
+	testCases := []struct {
+		sources []string
+		input   string
+		want    string
+	}{
+		{sources: []string{"a"}, input: "kubernetis", want: "kubernetes"},
+		// Words from one source's pages shouldn't be used to correct queries in another source
+		{sources: []string{"b"}, input: "kubernetis", want: "kubernetis"},
+		{sources: []string{"b"}, input: "tomatos", want: "tomatoes"},
+		{sources: []string{"a", "b"}, input: "kubernetis tomatos", want: "kubernetes tomatoes"},
+		{sources: []string{"nonexistent"}, input: "tomatos", want: "tomatos"},
+	}
+
+	for _, c := range testCases {
+		got, err := db.Spellfix(context.Background(), c.sources, c.input)
+		if err != nil {
+			t.Fatalf("error during spellfix: %v\n", err)
+		}
+		if got != c.want {
+			t.Fatalf("unexpected spellfix result for %q in %v: expected %q, got %q", c.input, c.sources, c.want, got)
+		}
+	}
+}
+
+func TestSpellfixIncrementalUpdate(t *testing.T) {
+	db := createDB(t)
+
+	_, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "https://example.org/1", Finished, "Installation", "", "Installation guide", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+
+	if err := db.UpdateSpellfixIndex(context.Background()); err != nil {
+		t.Fatalf("failed to update spellfix index: %v\n", err)
+	}
+
+	assertCorrection := func(input string, want string) {
+		t.Helper()
+		got, err := db.Spellfix(context.Background(), []string{"source"}, input)
+		if err != nil {
+			t.Fatalf("error during spellfix: %v\n", err)
+		}
+		if got != want {
+			t.Fatalf("unexpected spellfix result for %q: expected %q, got %q", input, want, got)
+		}
+	}
+
+	assertCorrection("instalation", "installation")
+	assertCorrection("configuraton", "configuraton")
+
+	// New words should be added to the dictionary on the next update
+	_, err = db.AddDocument(context.Background(), "source", 1, []int64{}, "https://example.org/2", Finished, "Configuration", "", "Configuration reference", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	if err := db.UpdateSpellfixIndex(context.Background()); err != nil {
+		t.Fatalf("failed to update spellfix index: %v\n", err)
+	}
+	assertCorrection("configuraton", "configuration")
+	assertCorrection("instalation", "installation")
+
+	// Words from removed pages should be removed from the dictionary
+	if err := db.RemoveDocument(context.Background(), "source", "https://example.org/2"); err != nil {
+		t.Fatalf("unexpected error removing document: %v", err)
+	}
+	if err := db.UpdateSpellfixIndex(context.Background()); err != nil {
+		t.Fatalf("failed to update spellfix index: %v\n", err)
+	}
+	assertCorrection("configuraton", "configuraton")
+	assertCorrection("instalation", "installation")
+
+	// Dropping the index should cause it to be rebuilt from scratch
+	if err := db.DropSpellfixIndex(context.Background()); err != nil {
+		t.Fatalf("failed to drop spellfix index: %v\n", err)
+	}
+	if err := db.UpdateSpellfixIndex(context.Background()); err != nil {
+		t.Fatalf("failed to update spellfix index: %v\n", err)
+	}
+	assertCorrection("instalation", "installation")
+}
+
+func TestVocabularyIncrementalUpdateMatchesRebuild(t *testing.T) {
+	db := createDB(t).(*SQLiteDatabase)
+	ctx := context.Background()
+
+	addPage := func(url string, status QueueItemStatus, title string, content string) {
+		t.Helper()
+		if _, err := db.AddDocument(ctx, "source", 1, []int64{}, url, status, title, "", content, "", PageMetadata{}); err != nil {
+			t.Fatalf("unexpected error adding document: %v", err)
+		}
+	}
+	update := func() {
+		t.Helper()
+		if err := db.UpdateSpellfixIndex(ctx); err != nil {
+			t.Fatalf("failed to update spellfix index: %v", err)
+		}
+	}
+	// Returns every word in the source's vocabulary and spelling correction dictionary with its rank
+	dictionary := func() map[string]string {
+		t.Helper()
+		rows, err := db.conn.QueryContext(ctx, `
+			SELECT 'vocabulary:' || term, docs FROM vocabulary WHERE langid = (SELECT langid FROM spellfix_sources WHERE source = 'source')
+			UNION ALL
+			SELECT 'spellfix:' || word, rank FROM spellfix_vocab WHERE langid = (SELECT langid FROM spellfix_sources WHERE source = 'source');
+		`)
+		if err != nil {
+			t.Fatalf("error reading dictionary: %v", err)
+		}
+		defer rows.Close()
+		words := map[string]string{}
+		for rows.Next() {
+			var word, rank string
+			if err := rows.Scan(&word, &rank); err != nil {
+				t.Fatalf("error reading dictionary: %v", err)
+			}
+			words[word] = rank
+		}
+		return words
+	}
+
+	addPage("https://example.org/1", Finished, "Installation", "Installation guide for the installer")
+	addPage("https://example.org/2", Finished, "Configuration", "Configuration reference")
+	addPage("https://example.org/3", Finished, "Troubleshooting", "Installation problems")
+	update()
+
+	// Change one page's words, remove one, and add pages that are and aren't indexed
+	addPage("https://example.org/1", Finished, "Installation", "Upgrade guide")
+	if err := db.RemoveDocument(ctx, "source", "https://example.org/2"); err != nil {
+		t.Fatalf("unexpected error removing document: %v", err)
+	}
+	addPage("https://example.org/4", Finished, "Upgrading", "Upgrade the configuration")
+	addPage("https://example.org/5", Error, "", "")
+	addPage("https://example.org/3", Error, "", "")
+	update()
+
+	var changes int
+	if err := db.conn.QueryRowContext(ctx, "SELECT count(*) FROM vocabulary_changes;").Scan(&changes); err != nil || changes != 0 {
+		t.Fatalf("expected processed changes to be removed, got %v, %v", changes, err)
+	}
+	incremental := dictionary()
+	if incremental["vocabulary:upgrade"] != "2" || incremental["spellfix:upgrade"] != "2" || incremental["vocabulary:installation"] != "1" {
+		t.Fatalf("unexpected dictionary after an incremental update: %v", incremental)
+	}
+	if _, ok := incremental["vocabulary:problems"]; ok {
+		t.Fatalf("expected words from pages that failed to be removed: %v", incremental)
+	}
+
+	if _, err := db.conn.ExecContext(ctx, "UPDATE spellfix_sources SET stale = 1, rebuild = 1;"); err != nil {
+		t.Fatalf("error marking the dictionary for a rebuild: %v", err)
+	}
+	update()
+	if rebuilt := dictionary(); !reflect.DeepEqual(incremental, rebuilt) {
+		t.Fatalf("incremental update doesn't match a full rebuild:\nincremental: %v\nrebuilt: %v", incremental, rebuilt)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	_, err := db.AddDocument(ctx, "source", 1, []int64{}, "https://example.org/install", Finished, "Installation guide", "", "Install the package with the installer", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	_, err = db.AddDocument(ctx, "source", 2, []int64{}, "https://example.org/instructions", Finished, "Instructions", "", "Follow the instructions to install it", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	_, err = db.AddDocument(ctx, "other", 1, []int64{}, "https://example.net/", Finished, "Instant noodles", "", "Instant", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+	if err := db.UpdateSpellfixIndex(ctx); err != nil {
+		t.Fatalf("failed to update spellfix index: %v", err)
+	}
+
+	// Queries are only suggested after they have been searched more than once
+	for _, q := range []string{"install windows", "install  windows", "insecure", "install linux"} {
+		if err := db.RecordQuery(ctx, []string{"source"}, q); err != nil {
+			t.Fatalf("failed to record query: %v", err)
+		}
+	}
+	if err := db.RecordQuery(ctx, []string{"other"}, "instant noodles"); err != nil {
+		t.Fatalf("failed to record query: %v", err)
+	}
+	if err := db.RecordQuery(ctx, []string{"other"}, "Instant noodles"); err != nil {
+		t.Fatalf("failed to record query: %v", err)
+	}
+
+	results, err := db.Suggest(ctx, []string{"source"}, "Ins", 10)
+	if err != nil {
+		t.Fatalf("failed to get suggestions: %v", err)
+	}
+
+	want := []Suggestion{
+		{Text: "install windows", Kind: SuggestionQuery},
+		// "install" appears in both pages, so it's suggested before the words that only appear in one page
+		{Text: "install", Kind: SuggestionTerm},
+		{Text: "installation", Kind: SuggestionTerm},
+		{Text: "installer", Kind: SuggestionTerm},
+		{Text: "instructions", Kind: SuggestionTerm},
+		// Pages closer to the start of the crawl are suggested first
+		{Text: "Installation guide", Kind: SuggestionPage, URL: "https://example.org/install"},
+		{Text: "Instructions", Kind: SuggestionPage, URL: "https://example.org/instructions"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("unexpected suggestions: wanted %+v, got %+v", want, results)
+	}
+
+	// Only the last word is completed, and the rest of the query is kept as it was typed
+	results, err = db.Suggest(ctx, []string{"source", "other"}, `title:guide "Instan`, 3)
+	if err != nil {
+		t.Fatalf("failed to get suggestions: %v", err)
+	}
+	if len(results) != 1 || results[0].Text != `title:guide "instant` || results[0].Kind != SuggestionTerm {
+		t.Fatalf("unexpected suggestions: %+v", results)
+	}
+
+	results, err = db.Suggest(ctx, []string{"other"}, "instant n", 10)
+	if err != nil {
+		t.Fatalf("failed to get suggestions: %v", err)
+	}
+	want = []Suggestion{
+		{Text: "instant noodles", Kind: SuggestionQuery},
+		// "instant noodles" is also a term completion, but duplicates are removed
+		{Text: "instant net", Kind: SuggestionTerm},
+		{Text: "Instant noodles", Kind: SuggestionPage, URL: "https://example.net/"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("unexpected suggestions: wanted %+v, got %+v", want, results)
+	}
+}
+
+// When a document is added, if one already exists with the same source and URL, the existing page should be updated instead of creating a new row.
+// However, if the ID changes and the page's referrer was set to itself, then the page's referrers will fail to be recorded.
+// This test makes sure that doesn't happen.
+func TestAddDocumentUpdateRow(t *testing.T) {
+	db := createDB(t)
+
+	oldPageID, err := db.AddDocument(context.Background(), "source", 1, []int64{}, "http://url.test", Finished, "The quick brown fox jumped over the lazy dog", "", "", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding document: %v", err)
+	}
+
+	newPageID, err := db.AddDocument(context.Background(), "source", 1, []int64{oldPageID}, "http://url.test", Finished, "New page content", "New description", "", "", PageMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error adding second document: %v", err)
+	}
+
+	page, err := db.GetDocumentByID(context.Background(), newPageID)
+	if err != nil {
+		t.Fatalf("failed to get document by id: %v\n", err)
+	}
+
+	if page.Title != "New page content" {
+		t.Fatalf("unexpected page title: '%v' != '%v'", page.Title, "New page content")
+	}
+
+	if page.Description != "New description" {
 		t.Fatalf("unexpected page title: '%v' != '%v'", page.Title, "New description")
 	}
 }
+
+func TestFeedItemsKeepQueuedRefresh(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+	const url = "https://example.com/blog/post"
+
+	if _, err := db.AddDocument(ctx, "source1", 1, []int64{}, url, Finished, "Post", "", "Content", "", PageMetadata{}); err != nil {
+		t.Fatalf("error adding document: %v", err)
+	}
+	if err := db.AddToQueue(ctx, "source1", "", []string{url}, 1, true); err != nil {
+		t.Fatalf("error adding to queue: %v", err)
+	}
+
+	// Reclassifying the page as a feed item and filling in its date doesn't remove the pending refresh
+	publishedAt := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := db.AddFeedItems(ctx, "source1", []FeedItem{{URL: url, PublishedAt: &publishedAt}}); err != nil {
+		t.Fatalf("error adding feed items: %v", err)
+	}
+	item, err := db.PopQueue(ctx, "source1")
+	if err != nil || item == nil || item.URL != url {
+		t.Fatalf("expected the refresh to stay in the queue, got %+v, %v", item, err)
+	}
+}
+
+func TestMarkUnchanged(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	if err := db.SetupVectorTables(ctx, "source1", 3); err != nil {
+		t.Fatalf("error setting up vector tables: %v", err)
+	}
+
+	validators := CacheValidators{ETag: `"v1"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT", ContentHash: "abc123"}
+	id, err := db.AddDocument(ctx, "source1", 1, []int64{}, "https://example.com/page", Finished, "Unchanged page", "", "Some content", "", PageMetadata{Validators: validators})
+	if err != nil {
+		t.Fatalf("error adding document: %v", err)
+	}
+	if err := db.AddEmbedding(ctx, id, "source1", 0, "Some content", []float32{1, 0, 0}); err != nil {
+		t.Fatalf("error adding embedding: %v", err)
+	}
+
+	got, err := db.GetCacheValidators(ctx, "source1", "https://example.com/page")
+	if err != nil || got == nil || *got != validators {
+		t.Fatalf("unexpected validators: %+v, %v", got, err)
+	}
+	if got, err := db.GetCacheValidators(ctx, "source1", "https://example.com/missing"); err != nil || got != nil {
+		t.Fatalf("expected no validators for a missing page, got %+v, %v", got, err)
+	}
+
+	sqlite := db.(*SQLiteDatabase)
+	if _, err := sqlite.conn.Exec("UPDATE pages SET crawledAt = '2000-01-01 00:00:00';"); err != nil {
+		t.Fatalf("error changing crawl time: %v", err)
+	}
+	if err := db.AddToQueue(ctx, "source1", "", []string{"https://example.com/page"}, 1, true); err != nil {
+		t.Fatalf("error queueing page: %v", err)
+	}
+
+	// Validators that the server didn't send again are kept
+	unchangedID, err := db.MarkUnchanged(ctx, "source1", "https://example.com/page", CacheValidators{ETag: `"v2"`})
+	if err != nil || unchangedID != id {
+		t.Fatalf("unexpected result from MarkUnchanged: %v, %v", unchangedID, err)
+	}
+	got, err = db.GetCacheValidators(ctx, "source1", "https://example.com/page")
+	if err != nil || got.ETag != `"v2"` || got.LastModified != validators.LastModified || got.ContentHash != validators.ContentHash {
+		t.Fatalf("unexpected validators after marking the page unchanged: %+v, %v", got, err)
+	}
+
+	var crawledAt string
+	var queued, embeddings int
+	err = sqlite.conn.QueryRow(`SELECT
+		(SELECT crawledAt FROM pages WHERE id = ?),
+		(SELECT count(*) FROM crawl_queue),
+		(SELECT count(*) FROM pages_vec_source1);`, id).Scan(&crawledAt, &queued, &embeddings)
+	if err != nil {
+		t.Fatalf("error reading page: %v", err)
+	}
+	if strings.HasPrefix(crawledAt, "2000") || queued != 0 || embeddings != 1 {
+		t.Fatalf("unexpected state after marking the page unchanged: crawledAt %v, %v queued, %v embeddings", crawledAt, queued, embeddings)
+	}
+	if _, err := sqlite.conn.Exec("INSERT INTO pages_fts(pages_fts, rank) VALUES('integrity-check', 1);"); err != nil {
+		t.Fatalf("full-text search index is inconsistent: %v", err)
+	}
+	results, _, err := db.Search(ctx, []string{"source1"}, "unchanged", SearchOptions{}, 1, 10)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("unexpected search results: %+v, %v", results, err)
+	}
+
+	if _, err := db.MarkUnchanged(ctx, "source1", "https://example.com/missing", CacheValidators{}); err != ErrPageNotFound {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+}
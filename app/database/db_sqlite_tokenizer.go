@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// Sets the tokenizer used by the full-text search index. This must be called before Setup.
+// If the index was built with a different tokenizer, Setup rebuilds it.
+func (db *SQLiteDatabase) UseTokenizer(tokenizer Tokenizer) error {
+	switch tokenizer.Name {
+	case TokenizerUnicode61, TokenizerPorter, TokenizerTrigram:
+		db.tokenizer = tokenizer
+		return nil
+	}
+	return fmt.Errorf("unknown tokenizer: %v", tokenizer.Name)
+}
+
+// Returns the arguments of the `tokenize` option for the `pages_fts` table.
+// See https://sqlite.org/fts5.html#tokenizers
+func (t Tokenizer) args() string {
+	switch t.Name {
+	case TokenizerPorter:
+		// The porter tokenizer wraps another tokenizer, which splits the text into words before they're stemmed
+		return "porter " + Tokenizer{Name: TokenizerUnicode61, RemoveDiacritics: t.RemoveDiacritics}.args()
+	case TokenizerTrigram:
+		if t.RemoveDiacritics {
+			return "trigram remove_diacritics 1"
+		}
+		return "trigram"
+	}
+	if t.RemoveDiacritics {
+		// 2 also removes diacritics from letters that have more than one. 1 only exists for backwards compatibility.
+		return "unicode61 remove_diacritics 2"
+	}
+	return "unicode61 remove_diacritics 0"
+}
+
+// Whether the terms in the full-text search index are whole words as they appear in the text.
+// If they aren't, a separate index of words is kept to build spelling correction dictionaries and autocomplete vocabularies.
+func (t Tokenizer) indexesWords() bool {
+	return t.Name == TokenizerUnicode61
+}
+
+var tokenizePattern = regexp.MustCompile(`tokenize\s*=\s*'([^']*)'`)
+
+// Returns the `tokenize` option that the existing `pages_fts` table was created with
+func (db *SQLiteDatabase) currentTokenizer(ctx context.Context) (string, error) {
+	var schema string
+	err := db.conn.QueryRowContext(ctx, "SELECT sql FROM sqlite_schema WHERE type = 'table' AND name = 'pages_fts';").Scan(&schema)
+	if err != nil {
+		return "", err
+	}
+	if match := tokenizePattern.FindStringSubmatch(schema); match != nil {
+		return match[1], nil
+	}
+	// Tables created before the tokenizer was configurable use FTS5's default tokenizer
+	return "unicode61", nil
+}
+
+// Makes sure the full-text search index uses the configured tokenizer, and sets up the table that spelling correction dictionaries are built from.
+// If the tokenizer changed, the index is rebuilt from the `pages` table in a single transaction, so the old index is kept if the rebuild fails.
+func (db *SQLiteDatabase) setupTokenizer(ctx context.Context) error {
+	current, err := db.currentTokenizer(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current == db.tokenizer.args() {
+		var exists bool
+		err := db.conn.QueryRowContext(ctx, "SELECT count(*) > 0 FROM sqlite_schema WHERE type = 'table' AND name = 'pages_words';").Scan(&exists)
+		if err != nil {
+			return err
+		}
+		_, err = db.conn.ExecContext(ctx, db.vocabularySetup(!exists))
+		return err
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		DROP TABLE IF EXISTS pages_vocab_instance;
+		DROP TRIGGER IF EXISTS pages_words_insert;
+		DROP TRIGGER IF EXISTS pages_words_delete;
+		DROP TRIGGER IF EXISTS pages_words_update;
+		DROP TABLE IF EXISTS pages_words;
+
+		DROP TABLE pages_fts;
+		CREATE VIRTUAL TABLE pages_fts USING fts5(url, title, description, content, content=pages, tokenize='%s');
+		-- Re-index every page using the new tokenizer. See https://sqlite.org/fts5.html#the_rebuild_command
+		INSERT INTO pages_fts(pages_fts) VALUES('rebuild');
+
+		%s
+
+		-- The terms in the index changed, so every spelling correction dictionary needs to be rebuilt
+		UPDATE spellfix_sources SET stale = 1, rebuild = 1;
+	`, db.tokenizer.args(), db.vocabularySetup(true)))
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return fmt.Errorf("error rebuilding full-text search index with tokenizer %q: %v", db.tokenizer.args(), err)
+	}
+
+	return tx.Commit()
+}
+
+// Returns the `tokenize` option of the index that `pages_vocab_instance` reads words from
+func (db *SQLiteDatabase) vocabularyTokenizer() string {
+	if db.tokenizer.indexesWords() {
+		return db.tokenizer.args()
+	}
+	return Tokenizer{Name: TokenizerUnicode61, RemoveDiacritics: db.tokenizer.RemoveDiacritics}.args()
+}
+
+// Returns SQL commands that create `pages_vocab_instance`, which exposes every occurrence of every word in the pages.
+// It's used to build spelling correction dictionaries for each source. See https://sqlite.org/fts5.html#the_fts5vocab_virtual_table_module
+//
+// If the full-text search index doesn't contain whole words (because they're stemmed or split into trigrams), the words come from
+// `pages_words`, a smaller index that only records which pages contain each word. If `rebuild` is true, it's populated from the `pages` table.
+func (db *SQLiteDatabase) vocabularySetup(rebuild bool) string {
+	if db.tokenizer.indexesWords() {
+		return "CREATE VIRTUAL TABLE IF NOT EXISTS pages_vocab_instance USING fts5vocab(pages_fts, instance);"
+	}
+
+	commands := fmt.Sprintf(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS pages_words USING fts5(url, title, description, content, content=pages, detail=none, tokenize='%s');
+
+		CREATE TRIGGER IF NOT EXISTS pages_words_insert AFTER INSERT ON pages BEGIN
+			INSERT INTO pages_words(rowid, url, title, description, content) VALUES (new.rowid, new.url, new.title, new.description, new.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS pages_words_delete AFTER DELETE ON pages BEGIN
+			INSERT INTO pages_words(pages_words, rowid, url, title, description, content) VALUES('delete', old.rowid, old.url, old.title, old.description, old.content);
+		END;
+		-- Like pages_auto_update, this is recreated because older versions re-indexed pages on every update
+		DROP TRIGGER IF EXISTS pages_words_update;
+		CREATE TRIGGER pages_words_update AFTER UPDATE ON pages
+		WHEN old.url IS NOT new.url OR old.title IS NOT new.title OR old.description IS NOT new.description OR old.content IS NOT new.content BEGIN
+			INSERT INTO pages_words(pages_words, rowid, url, title, description, content) VALUES('delete', old.rowid, old.url, old.title, old.description, old.content);
+			INSERT INTO pages_words(rowid, url, title, description, content) VALUES (new.rowid, new.url, new.title, new.description, new.content);
+		END;
+
+		CREATE VIRTUAL TABLE IF NOT EXISTS pages_vocab_instance USING fts5vocab(pages_words, instance);
+	`, db.vocabularyTokenizer())
+
+	if rebuild {
+		commands += "INSERT INTO pages_words(pages_words) VALUES('rebuild');"
+	}
+	return commands
+}
@@ -0,0 +1,98 @@
+package database
+
+import "math"
+
+const (
+	// The probability that a visitor follows a link instead of jumping to a random page
+	pageRankDamping = 0.85
+	// PageRank stops iterating when the total change in scores is smaller than this, or after `pageRankMaxIterations`
+	pageRankTolerance     = 1e-6
+	pageRankMaxIterations = 100
+)
+
+// Computes a PageRank score for each page in a link graph, where `links` maps a page's ID to the IDs of the pages it links to.
+// Links to pages that aren't in `pages` are ignored. The scores add up to 1.
+func pageRank(pages []int64, links map[int64][]int64) map[int64]float64 {
+	n := len(pages)
+	if n == 0 {
+		return map[int64]float64{}
+	}
+
+	index := make(map[int64]int, n)
+	for i, id := range pages {
+		index[id] = i
+	}
+
+	// Convert the links into indices so that the scores can be stored in slices
+	outgoing := make([][]int, n)
+	for i, id := range pages {
+		for _, dest := range links[id] {
+			if j, ok := index[dest]; ok && j != i {
+				outgoing[i] = append(outgoing[i], j)
+			}
+		}
+	}
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1.0 / float64(n)
+	}
+	next := make([]float64, n)
+
+	for iteration := 0; iteration < pageRankMaxIterations; iteration++ {
+		// Pages without any outgoing links distribute their score evenly to every page
+		dangling := 0.0
+		for i, dests := range outgoing {
+			if len(dests) == 0 {
+				dangling += scores[i]
+			}
+		}
+
+		base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i, dests := range outgoing {
+			share := pageRankDamping * scores[i] / float64(len(dests))
+			for _, j := range dests {
+				next[j] += share
+			}
+		}
+
+		delta := 0.0
+		for i := range scores {
+			delta += math.Abs(next[i] - scores[i])
+		}
+		scores, next = next, scores
+		if delta < pageRankTolerance {
+			break
+		}
+	}
+
+	result := make(map[int64]float64, n)
+	for i, id := range pages {
+		result[id] = scores[i]
+	}
+	return result
+}
+
+// Scales PageRank scores to the range [0, 1], where 1 is the highest score.
+// PageRank scores follow a power law (a few pages have much higher scores than the rest), so they're scaled logarithmically.
+func normalizeAuthority(scores map[int64]float64) map[int64]float64 {
+	n := float64(len(scores))
+	highest := 0.0
+	for _, score := range scores {
+		highest = max(highest, score)
+	}
+
+	normalized := make(map[int64]float64, len(scores))
+	for id, score := range scores {
+		if highest == 0 {
+			normalized[id] = 0
+		} else {
+			// Multiplying by `n` makes the average score 1, so the result doesn't depend on the number of pages in the source
+			normalized[id] = math.Log1p(score*n) / math.Log1p(highest*n)
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageRank(t *testing.T) {
+	// Every page links to the home page (1), which links to two of them
+	pages := []int64{1, 2, 3, 4}
+	links := map[int64][]int64{
+		1: {2, 3},
+		2: {1},
+		3: {1, 4},
+		4: {1},
+		// Links to pages outside of the graph are ignored
+		5: {1},
+	}
+
+	scores := pageRank(pages, links)
+
+	total := 0.0
+	for _, score := range scores {
+		total += score
+	}
+	if math.Abs(total-1) > 1e-6 {
+		t.Fatalf("expected scores to add up to 1, got %v", total)
+	}
+
+	if !(scores[1] > scores[3] && scores[3] > scores[4] && scores[2] == scores[3]) {
+		t.Fatalf("unexpected scores: %v", scores)
+	}
+
+	normalized := normalizeAuthority(scores)
+	if normalized[1] != 1 {
+		t.Fatalf("expected the highest score to be normalized to 1, got %v", normalized[1])
+	}
+	for id, score := range normalized {
+		if score <= 0 || score > 1 {
+			t.Fatalf("expected normalized score of page %v to be between 0 and 1, got %v", id, score)
+		}
+	}
+}
+
+func TestPageRankWithoutLinks(t *testing.T) {
+	scores := normalizeAuthority(pageRank([]int64{1, 2}, map[int64][]int64{}))
+	if scores[1] != 1 || scores[2] != 1 {
+		t.Fatalf("expected pages without links to have equal scores, got %v", scores)
+	}
+
+	if len(pageRank([]int64{}, map[int64][]int64{})) != 0 {
+		t.Fatal("expected no scores for an empty graph")
+	}
+}
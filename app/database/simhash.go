@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// The number of consecutive words hashed together when fingerprinting a page
+const shingleSize = 2
+
+// Pages with fewer words than this aren't fingerprinted, since short pages (like error pages) often look the same without being duplicates
+const minFingerprintWords = 20
+
+// Pages whose fingerprints differ in at most this many bits are considered near-duplicates
+const maxDuplicateDistance = 3
+
+// Returns a simhash fingerprint of the text, which is similar for texts that share most of their words.
+// See https://en.wikipedia.org/wiki/SimHash. Returns false if the text is too short to be fingerprinted.
+func fingerprint(text string) (uint64, bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minFingerprintWords {
+		return 0, false
+	}
+
+	// Each bit of the fingerprint is set if most of the shingles' hashes have that bit set
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var result uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			result |= 1 << bit
+		}
+	}
+	return result, true
+}
+
+// Returns whether two fingerprints are close enough for their pages to be near-duplicates
+func isNearDuplicate(a uint64, b uint64) bool {
+	return bits.OnesCount64(a^b) <= maxDuplicateDistance
+}
+
+// Fingerprints are split into this many bands to find near-duplicates. Two fingerprints that differ in at most
+// maxDuplicateDistance bits must have at least one identical band, so only pages with a matching band need to be compared.
+const (
+	fingerprintBands = maxDuplicateDistance + 1
+	bandBits         = 64 / fingerprintBands
+)
+
+// Returns an SQL expression for the value of one band of a fingerprint column, which matches fingerprintBandValues
+func sqlFingerprintBand(column string, band int) string {
+	return fmt.Sprintf("(%s >> %d) & %d", column, band*bandBits, 1<<bandBits-1)
+}
+
+// Returns the value of each band of the fingerprint
+func fingerprintBandValues(f uint64) [fingerprintBands]int64 {
+	var bands [fingerprintBands]int64
+	for i := range bands {
+		bands[i] = int64((f >> (i * bandBits)) & (1<<bandBits - 1))
+	}
+	return bands
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog while the cat watches from the window. ", 3)
+	a, ok := fingerprint(text)
+	if !ok {
+		t.Fatalf("expected text to be fingerprinted")
+	}
+	b, _ := fingerprint(strings.ToUpper(text) + "!!!")
+	if a != b {
+		t.Errorf("expected case and punctuation to be ignored: %x != %x", a, b)
+	}
+	c, _ := fingerprint("Easysearch is a simple search engine that crawls websites and indexes their content so that visitors can find pages by keyword.")
+	if isNearDuplicate(a, c) {
+		t.Errorf("expected different texts to have different fingerprints: %x and %x", a, c)
+	}
+	if _, ok := fingerprint("Page not found"); ok {
+		t.Errorf("expected short text not to be fingerprinted")
+	}
+}
+
+func TestFingerprintBandColumns(t *testing.T) {
+	db := createDB(t).(*SQLiteDatabase)
+
+	// Fingerprints with the highest bit set are stored as negative integers
+	for _, f := range []uint64{0x0123456789abcdef, 0xfedcba9876543210} {
+		var bands [fingerprintBands]int64
+		err := db.conn.QueryRow("SELECT "+sqlFingerprintBand("?1", 0)+", "+sqlFingerprintBand("?1", 1)+", "+sqlFingerprintBand("?1", 2)+", "+sqlFingerprintBand("?1", 3)+";",
+			int64(f)).Scan(&bands[0], &bands[1], &bands[2], &bands[3])
+		if err != nil {
+			t.Fatalf("error computing bands: %v", err)
+		}
+		if bands != fingerprintBandValues(f) {
+			t.Errorf("bands of %x don't match: %v, %v", f, bands, fingerprintBandValues(f))
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultDescriptionTokens = 8
+	defaultContentTokens     = 24
+	// FTS5's snippet function never returns more tokens than this
+	MaxSnippetTokens = 64
+)
+
+func (s SnippetOptions) withDefaults() SnippetOptions {
+	s.DescriptionTokens = cmp.Or(s.DescriptionTokens, defaultDescriptionTokens)
+	s.ContentTokens = cmp.Or(s.ContentTokens, defaultContentTokens)
+	s.ContentSnippets = cmp.Or(s.ContentSnippets, 1)
+	return s
+}
+
+// Returns the SQL columns for a result's highlighted title, description snippet, and content, and their args.
+// The columns are highlighted with `start` and `end`, which processResult converts into matches.
+// If there's more than one content snippet, the whole content is highlighted, and contentMatches chooses the snippets.
+func (s SnippetOptions) columns(start string, end string) (string, []any) {
+	content, contentArgs := "snippet(pages_fts, 3, ?, ?, '…', ?)", []any{start, end, s.ContentTokens}
+	if s.ContentSnippets > 1 {
+		content, contentArgs = "highlight(pages_fts, 3, ?, ?)", []any{start, end}
+	}
+	return `highlight(pages_fts, 1, ?, ?) AS title,
+		snippet(pages_fts, 2, ?, ?, '…', ?) AS description,
+		` + content + ` AS content`, slices.Concat([]any{start, end, start, end, s.DescriptionTokens}, contentArgs)
+}
+
+// Converts the content column from `columns` into matches
+func (s SnippetOptions) contentMatches(content string, start string, end string) []Match {
+	matches := processResult(content, start, end)
+	if s.ContentSnippets > 1 {
+		return selectSnippets(matches, s.ContentSnippets, s.ContentTokens)
+	}
+	return matches
+}
+
+// A word and the whitespace after it
+type snippetToken struct {
+	parts       []Match
+	highlighted bool
+}
+
+// Splits highlighted text into words. A word can have highlighted and non-highlighted parts, since some tokenizers match parts of words.
+func splitTokens(matches []Match) []snippetToken {
+	tokens := []snippetToken{}
+	inSpace := true
+	for _, m := range matches {
+		for _, r := range m.Content {
+			space := unicode.IsSpace(r)
+			if len(tokens) == 0 || (inSpace && !space) {
+				tokens = append(tokens, snippetToken{})
+			}
+			inSpace = space
+
+			t := &tokens[len(tokens)-1]
+			if n := len(t.parts); n > 0 && t.parts[n-1].Highlighted == m.Highlighted {
+				t.parts[n-1].Content += string(r)
+			} else {
+				t.parts = append(t.parts, Match{Highlighted: m.Highlighted, Content: string(r)})
+			}
+			t.highlighted = t.highlighted || (m.Highlighted && !space)
+		}
+	}
+	return tokens
+}
+
+// Chooses up to `count` separate parts of the text, each `size` tokens long, that contain the most highlighted words.
+// The parts are returned in the order that they appear, separated by "…".
+func selectSnippets(matches []Match, count int, size int) []Match {
+	tokens := splitTokens(matches)
+
+	hits := []int{}
+	for i, t := range tokens {
+		if t.highlighted {
+			hits = append(hits, i)
+		}
+	}
+
+	type window struct{ start, score int }
+	candidates := []window{{start: 0}}
+	for _, hit := range hits {
+		// Show a few words of context before the match
+		start := max(0, hit-size/4)
+		score := 0
+		for _, h := range hits {
+			if h >= start && h < start+size {
+				score++
+			}
+		}
+		candidates = append(candidates, window{start, score})
+	}
+	slices.SortStableFunc(candidates, func(a, b window) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.start, b.start))
+	})
+
+	chosen := []window{}
+	for _, c := range candidates {
+		if len(chosen) == count {
+			break
+		}
+		overlaps := slices.ContainsFunc(chosen, func(w window) bool {
+			return c.start < w.start+size && w.start < c.start+size
+		})
+		// The start of the text is only used if there are no matches
+		if !overlaps && (c.score > 0 || len(hits) == 0) {
+			chosen = append(chosen, c)
+		}
+	}
+	slices.SortFunc(chosen, func(a, b window) int { return cmp.Compare(a.start, b.start) })
+
+	result := []Match{}
+	add := func(m Match) {
+		if n := len(result); n > 0 && result[n-1].Highlighted == m.Highlighted {
+			result[n-1].Content += m.Content
+		} else if m.Content != "" {
+			result = append(result, m)
+		}
+	}
+	for _, w := range chosen {
+		end := min(w.start+size, len(tokens))
+		if w.start > 0 {
+			add(Match{Content: "…"})
+		}
+		for i, t := range tokens[w.start:end] {
+			for j, part := range t.parts {
+				// Trailing whitespace is removed from the last word
+				if w.start+i == end-1 && j == len(t.parts)-1 {
+					part.Content = strings.TrimRightFunc(part.Content, unicode.IsSpace)
+				}
+				add(part)
+			}
+		}
+		if end < len(tokens) && w == chosen[len(chosen)-1] {
+			add(Match{Content: "…"})
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectSnippets(t *testing.T) {
+	matches := []Match{
+		{Content: "one two "},
+		{Highlighted: true, Content: "apple"},
+		{Content: " three four five six seven eight nine ten eleven "},
+		{Highlighted: true, Content: "apple"},
+		{Content: " twelve "},
+		{Highlighted: true, Content: "app"},
+		{Content: "les thirteen fourteen"},
+	}
+
+	// The second part has two matches, so it's chosen first when there's only one snippet
+	want := []Match{
+		{Content: "…eleven "},
+		{Highlighted: true, Content: "apple"},
+		{Content: " twelve "},
+		{Highlighted: true, Content: "app"},
+		{Content: "les…"},
+	}
+	if got := selectSnippets(matches, 1, 4); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected snippet: %+v", got)
+	}
+
+	want = []Match{
+		{Content: "…two "},
+		{Highlighted: true, Content: "apple"},
+		{Content: " three four…eleven "},
+		{Highlighted: true, Content: "apple"},
+		{Content: " twelve "},
+		{Highlighted: true, Content: "app"},
+		{Content: "les…"},
+	}
+	if got := selectSnippets(matches, 2, 4); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected snippets: %+v", got)
+	}
+
+	// Without matches, the start of the text is shown
+	want = []Match{{Content: "one two three…"}}
+	if got := selectSnippets([]Match{{Content: "one two three four"}}, 2, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected snippet without matches: %+v", got)
+	}
+}
+
+func TestSearchSnippetOptions(t *testing.T) {
+	db := createDB(t)
+	ctx := context.Background()
+
+	content := "The oven heats up quickly. " + strings.Repeat("Filler text about nothing in particular. ", 10) + "Clean the oven after baking."
+	if _, err := db.AddDocument(ctx, "source1", 1, []int64{}, "https://example.com/oven", Finished, "Oven guide", "How to use and clean an oven", content, "", PageMetadata{}); err != nil {
+		t.Fatalf("error adding document: %v", err)
+	}
+
+	search := func(snippets SnippetOptions) FTSResult {
+		results, _, err := db.Search(ctx, []string{"source1"}, "oven", SearchOptions{Snippets: snippets}, 1, 10)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("unexpected search results: %+v, %v", results, err)
+		}
+		return results[0]
+	}
+	text := func(matches []Match) string {
+		var str strings.Builder
+		for _, m := range matches {
+			str.WriteString(m.Content)
+		}
+		return str.String()
+	}
+
+	res := search(SnippetOptions{DescriptionTokens: 3, ContentTokens: 4})
+	if len(strings.Fields(text(res.Description))) != 3 || len(strings.Fields(text(res.Content))) != 4 {
+		t.Errorf("unexpected snippet lengths: %q, %q", text(res.Description), text(res.Content))
+	}
+
+	// Both mentions of the oven are shown in separate snippets
+	res = search(SnippetOptions{ContentTokens: 4, ContentSnippets: 2})
+	if got := text(res.Content); got != "The oven heats up…the oven after baking." {
+		t.Errorf("unexpected snippets: %q", got)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
 	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/fluxcapacitor2/easysearch/app/config"
@@ -41,6 +45,13 @@ func main() {
 		if err != nil {
 			panic(fmt.Sprintf("Error opening SQLite database: %v", err))
 		}
+		err = sqlite.UseTokenizer(database.Tokenizer{
+			Name:             cmp.Or(config.DB.Tokenizer.Name, database.DefaultTokenizer.Name),
+			RemoveDiacritics: *cmp.Or(config.DB.Tokenizer.RemoveDiacritics, &database.DefaultTokenizer.RemoveDiacritics),
+		})
+		if err != nil {
+			panic(fmt.Sprintf("Invalid tokenizer: %v", err))
+		}
 		db = sqlite
 	default:
 		panic(fmt.Sprintf("Unknown database driver: %v. Valid drivers include: sqlite.", config.DB.Driver))
@@ -55,12 +66,12 @@ func main() {
 		}
 
 		start := time.Now()
-		err = db.CreateSpellfixIndex(context.Background())
+		err = db.UpdateSpellfixIndex(context.Background())
 
 		if err != nil {
-			slog.Warn("Failed to create spellfix index", "error", err)
+			slog.Warn("Failed to update spellfix index", "error", err)
 		} else {
-			slog.Info("Created spellfix index", "time", fmt.Sprintf("%dms", time.Since(start).Milliseconds()))
+			slog.Info("Updated spellfix index", "time", fmt.Sprintf("%dms", time.Since(start).Milliseconds()))
 		}
 
 		for _, src := range config.Sources {
@@ -74,6 +85,8 @@ func main() {
 	}
 
 	// Continuously pop items off each source's queue and crawl them
+	crawlers := startCrawlPool(db, config)
+
 	go scheduleJobs(db, config)
 
 	// If the base page for a source hasn't been crawled yet, queue it
@@ -83,7 +96,14 @@ func main() {
 	go startRefreshJob(db, config)
 
 	// Create an API server
-	server.Start(db, config)
+	go server.Start(db, config)
+
+	// When Easysearch is stopped, put the pages that are being crawled back in the queue
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	slog.Info("Shutting down")
+	crawlers.Stop()
 }
 
 func startCrawl(ctx context.Context, db database.Database, config *config.Config) {
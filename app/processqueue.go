@@ -1,7 +1,9 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
@@ -17,6 +19,13 @@ import (
 	slogctx "github.com/veqryn/slog-context"
 )
 
+const (
+	// The default number of times a page is crawled before a temporary failure is recorded as an error
+	defaultMaxAttempts = 5
+	// The default number of permanent failures in a row after which a page is removed from the index
+	defaultRemoveAfter = 3
+)
+
 func scheduleJobs(db database.Database, config *config.Config) {
 
 	scheduler, err := gocron.NewScheduler()
@@ -25,18 +34,6 @@ func scheduleJobs(db database.Database, config *config.Config) {
 		panic(fmt.Sprintf("Failed to create gocron scheduler: %v", err))
 	}
 
-	for _, src := range config.Sources {
-		interval := 60.0 / float64(src.Speed)
-
-		if _, err := scheduler.NewJob(gocron.DurationJob(time.Duration(interval*float64(time.Second))), gocron.NewTask(func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-			defer cancel()
-			processCrawlQueue(ctx, db, src)
-		})); err != nil {
-			slog.Error("Failed to create crawl job", "error", err)
-		}
-	}
-
 	for _, src := range config.Sources {
 		if !src.Embeddings.Enabled {
 			continue
@@ -79,12 +76,32 @@ func scheduleJobs(db database.Database, config *config.Config) {
 	if _, err := scheduler.NewJob(gocron.DurationJob(time.Duration(5*time.Minute)), gocron.NewTask(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
-		err := db.CreateSpellfixIndex(ctx)
+		err := db.UpdateSpellfixIndex(ctx)
 		if err != nil {
-			slogctx.Error(ctx, "Failed to build spellfix index", "error", err)
+			slogctx.Error(ctx, "Failed to update spellfix index", "error", err)
 		}
 	})); err != nil {
-		slog.Error("Failed to create spellfix index build job", "error", err)
+		slog.Error("Failed to create spellfix index update job", "error", err)
+	}
+
+	// Recompute authority scores periodically, since they depend on the links between all of a source's pages
+	if _, err := scheduler.NewJob(gocron.DurationJob(time.Duration(30*time.Minute)), gocron.NewTask(func() {
+		for _, src := range config.Sources {
+			if src.Ranking.AuthorityWeight == 0 {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			ctx = slogctx.Append(ctx, "sourceId", src.ID)
+			start := time.Now()
+			if err := db.UpdateAuthority(ctx, src.ID); err != nil {
+				slogctx.Error(ctx, "Failed to update authority scores", "error", err)
+			} else {
+				slogctx.Info(ctx, "Updated authority scores", "time", fmt.Sprintf("%dms", time.Since(start).Milliseconds()))
+			}
+			cancel()
+		}
+	}), gocron.WithStartAt(gocron.WithStartImmediately())); err != nil {
+		slog.Error("Failed to create authority update job", "error", err)
 	}
 
 	scheduler.Start()
@@ -137,8 +154,8 @@ func processEmbedQueue(ctx context.Context, db database.Database, src config.Sou
 	}
 }
 
-func processCrawlQueue(ctx context.Context, db database.Database, src config.Source) {
-	// Pop the oldest item off the queue and crawl it.
+func processCrawlQueue(ctx context.Context, db database.Database, src config.Source, slots *hostSlots) {
+	// Pop the oldest item off the queue and crawl it. PopQueue claims the item atomically, so other workers can't pop it too.
 	item, err := db.PopQueue(ctx, src.ID)
 	if err != nil {
 		slogctx.Error(ctx, "Failed to get next item in crawl queue", "error", err)
@@ -150,13 +167,52 @@ func processCrawlQueue(ctx context.Context, db database.Database, src config.Sou
 		ctx = slogctx.With(ctx, "sourceId", src.ID)
 	}
 
-	// The `item` is nil when there are no items in the queue
+	// Wait for the source's other crawls of the same host to finish
+	waitCtx, cancelWait := context.WithTimeout(ctx, maxHostSlotWait)
+	release, ok := slots.acquire(waitCtx, item.URL)
+	cancelWait()
+	if !ok {
+		if shuttingDown(ctx) {
+			returnToQueue(ctx, db, item)
+		} else if err := db.PostponeQueueEntry(ctx, item.ID, maxHostSlotWait); err != nil {
+			slogctx.Error(ctx, "Failed to postpone queue item", "error", err)
+		}
+		return
+	}
+	defer release()
+
 	result, err := crawler.Crawl(ctx, src, item.Depth, item.Referrers, db, item.URL)
 
+	if shuttingDown(ctx) {
+		// The crawl was interrupted, so the page is crawled again from the start the next time Easysearch starts
+		returnToQueue(ctx, db, item)
+		return
+	}
+
 	if result != nil {
 		ctx = slogctx.With(ctx, "original", item.URL, "canonical", result.Canonical, "pageId", result.PageID)
 	}
 
+	var busy *crawler.HostBusyError
+	if errors.As(err, &busy) {
+		// The host was crawled recently, so the page is put back in the queue until it's the host's turn again
+		slogctx.Debug(ctx, "Postponing crawl until the host can be crawled again", "host", busy.Host, "delay", busy.Delay.String())
+		if err := db.PostponeQueueEntry(ctx, item.ID, busy.Delay); err != nil {
+			slogctx.Error(ctx, "Failed to postpone queue item", "error", err)
+		}
+		return
+	}
+
+	if err != nil && crawler.IsRetryable(err) && item.Attempts+1 < cmp.Or(src.Retry.MaxAttempts, defaultMaxAttempts) {
+		// Temporary failures are retried with increasing delays. Until then, the existing copy of the page (if there is one) stays in the index.
+		delay := crawler.RetryDelay(item.Attempts+1, err)
+		slogctx.Warn(ctx, "Failed to crawl URL; trying again later", "error", err, "attempts", item.Attempts+1, "delay", delay.String())
+		if err := db.RetryQueueEntry(ctx, item.ID, delay); err != nil {
+			slogctx.Error(ctx, "Failed to schedule another crawl attempt", "error", err)
+		}
+		return
+	}
+
 	if err != nil {
 		// Mark the queue entry as errored
 		slogctx.Error(ctx, "Failed to crawl URL", "error", err)
@@ -167,46 +223,93 @@ func processCrawlQueue(ctx context.Context, db database.Database, src config.Sou
 			}
 		}
 
-		// Add an entry to the pages table to prevent immediately recrawling the same URL when referred from other sources.
-		// Additionally, if refresh is enabled, another crawl attempt will be made after the refresh interval passes.
-		if result != nil {
-			_, err := db.AddDocument(ctx, src.ID, item.Depth, item.Referrers, result.Canonical, database.Error, "", "", "", err.Error())
-			if err != nil {
-				slogctx.Error(ctx, "Failed to add placeholder page in Error state", "error", err)
+		if result == nil {
+			return
+		}
+
+		// Pages that are already indexed keep their content until they fail permanently too many times in a row
+		permanent := !crawler.IsRetryable(err)
+		_, failErr := recordFailure(ctx, db, src, result.Canonical, err.Error(), permanent)
+		if failErr == database.ErrPageNotFound {
+			// Add an entry to the pages table to prevent immediately recrawling the same URL when referred from other sources.
+			// Additionally, if refresh is enabled, another crawl attempt will be made after the refresh interval passes.
+			if _, addErr := db.AddDocument(ctx, src.ID, item.Depth, item.Referrers, result.Canonical, database.Error, "", "", "", err.Error(), database.PageMetadata{}); addErr != nil {
+				slogctx.Error(ctx, "Failed to add placeholder page in Error state", "error", addErr)
+				return
+			}
+			failErr = nil
+			if permanent {
+				_, failErr = recordFailure(ctx, db, src, result.Canonical, err.Error(), permanent)
 			}
 		}
+		if failErr != nil {
+			slogctx.Error(ctx, "Failed to record crawl failure", "error", failErr)
+		}
 
-	} else {
-		// Chunk the page into sections and add it to the embedding queue
-		if result.PageID > 0 {
-			chunks, err := embedding.ChunkText(result.Content.Content, src.Embeddings.ChunkSize, src.Embeddings.ChunkOverlap)
+		// The page's links weren't loaded, so its existing references are kept
+		return
+	}
 
-			if err != nil {
-				slogctx.Error(ctx, "Failed to split page into chunks for embedding", "error", err)
+	if result.Content.Status == database.Error {
+		// The page loaded, but it can't be indexed (for example, because of a noindex tag).
+		// The crawler doesn't save these pages, so an indexed copy keeps its content until the page fails too many times in a row.
+		removed, err := recordFailure(ctx, db, src, result.Canonical, result.Content.ErrorInfo, true)
+		if err == database.ErrPageNotFound {
+			// Add a placeholder page so that the URL isn't immediately crawled again and its links can be recorded
+			if _, err = db.AddDocument(ctx, src.ID, item.Depth, item.Referrers, result.Canonical, database.Error, "", "", "", result.Content.ErrorInfo, database.PageMetadata{}); err == nil {
+				removed, err = recordFailure(ctx, db, src, result.Canonical, result.Content.ErrorInfo, true)
 			}
+		}
+		if err != nil {
+			slogctx.Error(ctx, "Failed to record crawl failure", "error", err)
+		}
+		if removed {
+			return
+		}
 
-			// Filter out empty chunks
-			filtered := make([]string, 0, len(chunks))
-			for _, chunk := range chunks {
-				if len(strings.TrimSpace(chunk)) != 0 {
-					filtered = append(filtered, chunk)
-				}
-			}
+		// The page's links are still followed, so its ID is needed to record them
+		page, err := db.GetDocument(ctx, src.ID, result.Canonical)
+		if err != nil || page == nil {
+			slogctx.Error(ctx, "Failed to find page that couldn't be indexed", "error", err)
+			return
+		}
+		result.PageID = page.ID
+	}
 
-			err = db.AddToEmbedQueue(ctx, result.PageID, filtered)
+	// Chunk the page into sections and add it to the embedding queue. Unchanged pages and pages that couldn't be indexed keep their existing embeddings.
+	if result.PageID > 0 && !result.Unchanged && result.Content.Status != database.Error {
+		chunks, err := embedding.ChunkText(result.Content.Content, src.Embeddings.ChunkSize, src.Embeddings.ChunkOverlap)
 
-			if err != nil {
-				slogctx.Error(ctx, "Failed to add page chunks to embed queue", "error", err)
+		if err != nil {
+			slogctx.Error(ctx, "Failed to split page into chunks for embedding", "error", err)
+		}
+
+		// Filter out empty chunks
+		filtered := make([]string, 0, len(chunks))
+		for _, chunk := range chunks {
+			if len(strings.TrimSpace(chunk)) != 0 {
+				filtered = append(filtered, chunk)
 			}
 		}
 
-		// If the crawl completed successfully, mark the item as finished
-		err = db.UpdateQueueEntry(ctx, item.ID, database.Finished)
+		err = db.AddToEmbedQueue(ctx, result.PageID, filtered)
+
 		if err != nil {
-			slogctx.Error(ctx, "Failed to update queue item status to Finished", "error", err)
+			slogctx.Error(ctx, "Failed to add page chunks to embed queue", "error", err)
 		}
 	}
 
+	// If the crawl completed successfully, mark the item as finished
+	err = db.UpdateQueueEntry(ctx, item.ID, database.Finished)
+	if err != nil {
+		slogctx.Error(ctx, "Failed to update queue item status to Finished", "error", err)
+	}
+
+	if result.Unchanged {
+		// The page's links haven't changed either, and a 304 response doesn't include them
+		return
+	}
+
 	// Remove existing references and then populate new ones
 	err = db.RemoveAllReferences(ctx, result.PageID)
 	if err != nil {
@@ -238,6 +341,23 @@ func processCrawlQueue(ctx context.Context, db database.Database, src config.Sou
 	}
 }
 
+// Records a failure to crawl a page without changing its indexed content, and removes the page from the index if it failed permanently
+// (like with a 404 status) too many times in a row. Returns whether the page was removed, or ErrPageNotFound if the page isn't indexed.
+func recordFailure(ctx context.Context, db database.Database, src config.Source, url string, errorInfo string, permanent bool) (bool, error) {
+	failures, err := db.AddFailure(ctx, src.ID, url, errorInfo, permanent)
+	if err != nil {
+		return false, err
+	}
+	if !permanent || failures < cmp.Or(src.Retry.RemoveAfter, defaultRemoveAfter) {
+		return false, nil
+	}
+	slogctx.Info(ctx, "Removing page after repeated crawl failures", "failures", failures)
+	if err := db.RemoveDocument(ctx, src.ID, url); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func filterURLs(db database.Database, src config.Source, urls []string, newOnly bool) []string {
 	filtered := []string{}
 
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+)
+
+func createDB(t *testing.T) database.Database {
+	db, err := database.SQLiteFromFile(path.Join(t.TempDir(), "temp.db"))
+
+	if err != nil {
+		t.Fatalf("database creation failed: %v", err)
+	}
+
+	if err := db.Setup(context.Background()); err != nil {
+		t.Fatalf("database setup failed: %v", err)
+	}
+
+	return db
+}
+
+func TestPermanentFailureKeepsPage(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" || status != http.StatusOK {
+			http.Error(w, "Not Found", status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><head><title>Temporary page</title></head><body><p>Some searchable zeppelin content.</p></body></html>"))
+	}))
+	defer server.Close()
+
+	parsed, _ := url.Parse(server.URL)
+	db := createDB(t)
+	ctx := context.Background()
+	src := config.Source{ID: "source1", URL: server.URL, AllowedDomains: []string{parsed.Host}, MaxDepth: 10, SizeLimit: 1000}
+	src.Retry.RemoveAfter = 2
+	pageURL := server.URL + "/page"
+
+	crawl := func() {
+		if err := db.AddToQueue(ctx, src.ID, "", []string{pageURL}, 1, true); err != nil {
+			t.Fatalf("error adding to queue: %v", err)
+		}
+		processCrawlQueue(ctx, db, src, newHostSlots(1))
+	}
+	searchable := func() bool {
+		results, _, err := db.Search(ctx, []string{src.ID}, "zeppelin", database.SearchOptions{}, 1, 10)
+		if err != nil {
+			t.Fatalf("error searching: %v", err)
+		}
+		return len(results) == 1
+	}
+
+	crawl()
+	if !searchable() {
+		t.Fatalf("expected the page to be searchable after crawling it")
+	}
+
+	// A 404 below the removal threshold keeps the page in search results
+	status = http.StatusNotFound
+	crawl()
+	if !searchable() {
+		t.Fatalf("expected the page to stay searchable after one failure")
+	}
+	page, err := db.GetDocument(ctx, src.ID, pageURL)
+	if err != nil || page == nil || page.Status != database.Finished || page.ErrorInfo != "Not Found" {
+		t.Fatalf("unexpected page after one failure: %+v, %v", page, err)
+	}
+
+	// Reaching the threshold removes it
+	crawl()
+	if page, err := db.GetDocument(ctx, src.ID, pageURL); err != nil || page != nil {
+		t.Fatalf("expected the page to be removed, got %+v, %v", page, err)
+	}
+}
+
+func TestNoindexKeepsPage(t *testing.T) {
+	noindex := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if noindex {
+			w.Write([]byte(`<html><head><title>Hidden page</title><meta name="robots" content="noindex"></head><body><p>Hidden content.</p></body></html>`))
+			return
+		}
+		w.Write([]byte("<html><head><title>Temporary page</title></head><body><p>Some searchable zeppelin content.</p></body></html>"))
+	}))
+	defer server.Close()
+
+	parsed, _ := url.Parse(server.URL)
+	db := createDB(t)
+	ctx := context.Background()
+	src := config.Source{ID: "source1", URL: server.URL, AllowedDomains: []string{parsed.Host}, MaxDepth: 10, SizeLimit: 1000}
+	src.Retry.RemoveAfter = 2
+	pageURL := server.URL + "/page"
+
+	crawl := func() {
+		if err := db.AddToQueue(ctx, src.ID, "", []string{pageURL}, 1, true); err != nil {
+			t.Fatalf("error adding to queue: %v", err)
+		}
+		processCrawlQueue(ctx, db, src, newHostSlots(1))
+	}
+
+	crawl()
+	results, _, err := db.Search(ctx, []string{src.ID}, "zeppelin", database.SearchOptions{}, 1, 10)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected the page to be searchable after crawling it, got %v, %v", results, err)
+	}
+
+	// A noindex tag below the removal threshold keeps the page's content in search results
+	noindex = true
+	crawl()
+	results, _, err = db.Search(ctx, []string{src.ID}, "zeppelin", database.SearchOptions{}, 1, 10)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected the page to stay searchable after one noindex response, got %v, %v", results, err)
+	}
+	page, err := db.GetDocument(ctx, src.ID, pageURL)
+	if err != nil || page == nil || page.Status != database.Finished || page.Title != "Temporary page" || page.ErrorInfo != `Disallowed by <meta name="robots">` {
+		t.Fatalf("unexpected page after one noindex response: %+v, %v", page, err)
+	}
+
+	// Reaching the threshold removes it
+	crawl()
+	if page, err := db.GetDocument(ctx, src.ID, pageURL); err != nil || page != nil {
+		t.Fatalf("expected the page to be removed, got %+v, %v", page, err)
+	}
+}
+
+func TestNoindexAddsPlaceholder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><meta name="robots" content="noindex"></head><body><a href="/other">Other</a></body></html>`))
+	}))
+	defer server.Close()
+
+	parsed, _ := url.Parse(server.URL)
+	db := createDB(t)
+	ctx := context.Background()
+	// The crawler checks the host with its port, and links are filtered by hostname
+	src := config.Source{ID: "source1", URL: server.URL, AllowedDomains: []string{parsed.Host, parsed.Hostname()}, MaxDepth: 10, SizeLimit: 1000}
+	pageURL := server.URL + "/page"
+	if err := db.AddToQueue(ctx, src.ID, "", []string{pageURL}, 1, true); err != nil {
+		t.Fatalf("error adding to queue: %v", err)
+	}
+	processCrawlQueue(ctx, db, src, newHostSlots(1))
+
+	// Pages that were never indexed are recorded in the Error state, and their links are still followed
+	page, err := db.GetDocument(ctx, src.ID, pageURL)
+	if err != nil || page == nil || page.Status != database.Error || page.Content != "" {
+		t.Fatalf("unexpected placeholder page: %+v, %v", page, err)
+	}
+	processCrawlQueue(ctx, db, src, newHostSlots(1))
+	other, err := db.GetDocument(ctx, src.ID, server.URL+"/other")
+	if err != nil || other == nil {
+		t.Fatalf("expected the page's link to be crawled, got %+v, %v", other, err)
+	}
+}
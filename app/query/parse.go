@@ -0,0 +1,589 @@
+package query
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int8
+
+const (
+	tokenWord tokenKind = iota
+	tokenPhrase
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLeftParen
+	tokenRightParen
+	// A field name followed by a colon, like `title:`
+	tokenField
+	// A site filter, like `site:example.com`
+	tokenSite
+)
+
+type token struct {
+	kind     tokenKind
+	position int
+	// For words, this contains one item. For phrases, it contains each word in the phrase.
+	// For fields, this contains the field name, and for sites, this contains the site as it was typed.
+	words []string
+	// The start and end offsets of each search term in `words`, in runes
+	spans  [][2]int
+	prefix bool
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+// Splits text into words, discarding punctuation and whitespace.
+// Returns each word and its start and end offsets, which are relative to `offset`.
+func splitWords(text []rune, offset int) ([]string, [][2]int) {
+	words := []string{}
+	spans := [][2]int{}
+	start := -1
+	for i, r := range text {
+		if isWordChar(r) {
+			if start == -1 {
+				start = i
+			}
+		} else if start != -1 {
+			words = append(words, string(text[start:i]))
+			spans = append(spans, [2]int{offset + start, offset + i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		words = append(words, string(text[start:]))
+		spans = append(spans, [2]int{offset + start, offset + len(text)})
+	}
+	return words, spans
+}
+
+func tokenize(input string) []token {
+	runes := []rune(input)
+	tokens := []token{}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '"':
+			// Phrases continue until the next double quote. If there isn't one, the phrase ends at the end of the query.
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			words, spans := splitWords(runes[i+1:end], i+1)
+			if len(words) > 0 {
+				tokens = append(tokens, token{kind: tokenPhrase, position: i, words: words, spans: spans})
+			}
+			i = end + 1
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, position: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, position: i})
+			i++
+		case r == '-' && (i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == '(') && i+1 < len(runes) && (isWordChar(runes[i+1]) || runes[i+1] == '"' || runes[i+1] == '('):
+			// A hyphen at the start of a word negates it. Hyphens anywhere else are treated as punctuation.
+			tokens = append(tokens, token{kind: tokenNot, position: i})
+			i++
+		case isWordChar(r):
+			end := i
+			for end < len(runes) && isWordChar(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+
+			if end < len(runes) && runes[end] == ':' {
+				name := strings.ToLower(word)
+				if name == "site" {
+					// Site filters continue until the next space or closing parenthesis
+					valueEnd := end + 1
+					for valueEnd < len(runes) && !unicode.IsSpace(runes[valueEnd]) && runes[valueEnd] != ')' {
+						valueEnd++
+					}
+					tokens = append(tokens, token{kind: tokenSite, position: i, words: []string{string(runes[end+1 : valueEnd])}})
+					i = valueEnd
+					continue
+				}
+				if field, ok := fieldNames[name]; ok {
+					tokens = append(tokens, token{kind: tokenField, position: i, words: []string{field}})
+					i = end + 1
+					continue
+				}
+			}
+
+			// Operators keep the word that was typed, in case they're searched for as words (see literalOperators)
+			switch word {
+			case "AND":
+				tokens = append(tokens, token{kind: tokenAnd, position: i, words: []string{word}})
+			case "OR":
+				tokens = append(tokens, token{kind: tokenOr, position: i, words: []string{word}})
+			case "NOT":
+				tokens = append(tokens, token{kind: tokenNot, position: i, words: []string{word}})
+			default:
+				t := token{kind: tokenWord, position: i, words: []string{word}, spans: [][2]int{{i, end}}}
+				if end < len(runes) && runes[end] == '*' {
+					t.prefix = true
+					end++
+				}
+				tokens = append(tokens, t)
+			}
+			i = end
+		default:
+			// Other punctuation separates words but is otherwise ignored
+			i++
+		}
+	}
+
+	// Users usually search as they type, so the last word is treated as a prefix to match words that haven't been finished yet
+	if len(tokens) > 0 && tokens[len(tokens)-1].kind == tokenWord {
+		tokens[len(tokens)-1].prefix = true
+	}
+
+	return tokens
+}
+
+// Returns whether the token is an operator that was typed as a word (AND, OR, or NOT), rather than a hyphen
+func (t token) isKeyword() bool {
+	return (t.kind == tokenAnd || t.kind == tokenOr || t.kind == tokenNot) && len(t.words) > 0
+}
+
+// An operator that doesn't have a search term on both sides (or after it, for NOT), like `a OR` or `AND a`, usually means that
+// the user typed the words without meaning to use them as operators, like in `using keywords like AND and OR`.
+// In that case, every operator keyword in the query is searched for as a word.
+func literalOperators(tokens []token) []token {
+	misplaced := false
+	for i, t := range tokens {
+		if !t.isKeyword() {
+			continue
+		}
+		var prev, next *token
+		if i > 0 {
+			prev = &tokens[i-1]
+		}
+		if i+1 < len(tokens) {
+			next = &tokens[i+1]
+		}
+
+		dangling := next == nil || next.kind == tokenRightParen || next.kind == tokenAnd || next.kind == tokenOr
+		leading := t.kind != tokenNot && (prev == nil || prev.kind == tokenLeftParen || prev.kind == tokenAnd || prev.kind == tokenOr || prev.kind == tokenNot || prev.kind == tokenField)
+		if dangling || leading {
+			misplaced = true
+			break
+		}
+	}
+	if !misplaced {
+		return tokens
+	}
+
+	for i, t := range tokens {
+		if t.isKeyword() {
+			tokens[i] = token{kind: tokenWord, position: t.position, words: t.words, spans: [][2]int{{t.position, t.position + len([]rune(t.words[0]))}}}
+		}
+	}
+	if last := &tokens[len(tokens)-1]; last.kind == tokenWord {
+		last.prefix = true
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []token
+	index  int
+	// The length of the input, in runes, used to report errors at the end of the query
+	length int
+	// The position of each node in the input, used to report errors found after the tree is built
+	positions map[Node]int
+}
+
+// Parse converts a search query into a syntax tree.
+//
+// Words separated by spaces must all match. Other supported syntax includes:
+//   - "exact phrases" in double quotes
+//   - prefix* matches
+//   - boolean operators: AND, OR, and NOT (which must be uppercase). If an operator is missing a search term, like in `a OR`,
+//     the operators are searched for as words instead.
+//   - -negation with a leading hyphen
+//   - (grouping) with parentheses
+//   - field:term to only search one part of each page (url, title, description, or content)
+//   - site:example.com/path to only include pages on a host or below a path
+//
+// The last word in the query is always treated as a prefix.
+func Parse(input string) (Node, error) {
+	p := &parser{tokens: literalOperators(tokenize(input)), length: len([]rune(input)), positions: make(map[Node]int)}
+
+	if len(p.tokens) == 0 {
+		return nil, &ParseError{Position: 0, Message: "query does not contain any search terms"}
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next != nil {
+		// parseOr only stops early when it finds a closing parenthesis that doesn't have a matching opening parenthesis
+		return nil, &ParseError{Position: next.position, Message: "unexpected closing parenthesis"}
+	}
+
+	node = simplify(node)
+
+	if err := p.validate(node); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (p *parser) peek() *token {
+	if p.index >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.index]
+}
+
+func (p *parser) next() *token {
+	t := p.peek()
+	if t != nil {
+		p.index++
+	}
+	return t
+}
+
+// Returns an error at the position of the next token, or at the end of the query if there are no more tokens
+func (p *parser) errorAtNext(message string) *ParseError {
+	if next := p.peek(); next != nil {
+		return &ParseError{Position: next.position, Message: message}
+	}
+	return &ParseError{Position: p.length, Message: message}
+}
+
+func (p *parser) parseOr() (Node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []Node{first}
+	for {
+		next := p.peek()
+		if next == nil || next.kind != tokenOr {
+			break
+		}
+		p.next()
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == 1 {
+		return first, nil
+	}
+	node := &Or{Children: children}
+	p.positions[node] = p.positions[first]
+	return node, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []Node{first}
+	for {
+		next := p.peek()
+		if next == nil || next.kind == tokenOr || next.kind == tokenRightParen {
+			break
+		}
+		if next.kind == tokenAnd {
+			p.next()
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == 1 {
+		return first, nil
+	}
+	node := &And{Children: children}
+	p.positions[node] = p.positions[first]
+	return node, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	next := p.peek()
+	if next == nil {
+		return nil, p.errorAtNext("expected a search term")
+	}
+
+	if next.kind == tokenNot {
+		p.next()
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node := &Not{Child: child}
+		p.positions[node] = next.position
+		return node, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+	if t == nil {
+		return nil, p.errorAtNext("expected a search term")
+	}
+
+	switch t.kind {
+	case tokenWord:
+		node := &Term{Value: t.words[0], Prefix: t.prefix}
+		p.positions[node] = t.position
+		return node, nil
+	case tokenPhrase:
+		var node Node
+		if len(t.words) == 1 {
+			node = &Term{Value: t.words[0]}
+		} else {
+			node = &Phrase{Words: t.words}
+		}
+		p.positions[node] = t.position
+		return node, nil
+	case tokenField:
+		if next := p.peek(); next == nil || next.kind == tokenRightParen || next.kind == tokenOr || next.kind == tokenAnd {
+			return nil, p.errorAtNext("expected a search term after " + t.words[0] + ":")
+		}
+		child, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		node := &Field{Name: t.words[0], Child: child}
+		p.positions[node] = t.position
+		return node, nil
+	case tokenSite:
+		node, err := parseSite(t.words[0], t.position)
+		if err != nil {
+			return nil, err
+		}
+		p.positions[node] = t.position
+		return node, nil
+	case tokenLeftParen:
+		if next := p.peek(); next != nil && next.kind == tokenRightParen {
+			return nil, &ParseError{Position: next.position, Message: "empty parentheses"}
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing == nil || closing.kind != tokenRightParen {
+			return nil, &ParseError{Position: t.position, Message: "missing closing parenthesis"}
+		}
+		return node, nil
+	case tokenRightParen:
+		return nil, &ParseError{Position: t.position, Message: "unexpected closing parenthesis"}
+	case tokenAnd:
+		return nil, &ParseError{Position: t.position, Message: "expected a search term before AND"}
+	case tokenOr:
+		return nil, &ParseError{Position: t.position, Message: "expected a search term before OR"}
+	case tokenNot:
+		return nil, &ParseError{Position: t.position, Message: "NOT can't be used here"}
+	}
+
+	return nil, &ParseError{Position: t.position, Message: "unexpected token"}
+}
+
+func parseSite(value string, position int) (*Site, error) {
+	if value == "" {
+		return nil, &ParseError{Position: position, Message: "expected a domain after site:"}
+	}
+
+	// Allow pasting full URLs
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, &ParseError{Position: position, Message: "invalid site: filter"}
+	}
+
+	return &Site{
+		Host: strings.ToLower(parsed.Hostname()),
+		Path: strings.TrimSuffix(parsed.Path, "/"),
+	}, nil
+}
+
+// Flattens nested And and Or nodes and removes double negatives
+func simplify(node Node) Node {
+	switch n := node.(type) {
+	case *And:
+		children := make([]Node, 0, len(n.Children))
+		for _, child := range n.Children {
+			child = simplify(child)
+			if and, ok := child.(*And); ok {
+				children = append(children, and.Children...)
+			} else {
+				children = append(children, child)
+			}
+		}
+		n.Children = children
+	case *Or:
+		children := make([]Node, 0, len(n.Children))
+		for _, child := range n.Children {
+			child = simplify(child)
+			if or, ok := child.(*Or); ok {
+				children = append(children, or.Children...)
+			} else {
+				children = append(children, child)
+			}
+		}
+		n.Children = children
+	case *Not:
+		n.Child = simplify(n.Child)
+		if not, ok := n.Child.(*Not); ok {
+			return not.Child
+		}
+	case *Field:
+		n.Child = simplify(n.Child)
+	}
+	return node
+}
+
+// Makes sure that the query contains at least one search term that isn't negated.
+// Search engines can't efficiently find every document that *doesn't* contain a word, so these queries are rejected.
+func (p *parser) validate(root Node) error {
+	children := []Node{root}
+	if and, ok := root.(*And); ok {
+		children = and.Children
+	}
+
+	positive := false
+	var firstExcluded, firstSite Node
+	for _, child := range children {
+		target := child
+		if not, ok := child.(*Not); ok {
+			target = not.Child
+			if _, ok := target.(*Site); !ok && firstExcluded == nil {
+				firstExcluded = child
+			}
+		} else if _, ok := target.(*Site); !ok {
+			positive = true
+		}
+
+		if site, ok := target.(*Site); ok {
+			// Sites are allowed here, at the top level of the query, because they apply to every result
+			if firstSite == nil {
+				firstSite = site
+			}
+			continue
+		}
+
+		if err := p.validateTerms(target, false); err != nil {
+			return err
+		}
+	}
+
+	if !positive {
+		if firstExcluded != nil {
+			return &ParseError{Position: p.positions[firstExcluded], Message: "a query can't only contain excluded terms"}
+		}
+		return &ParseError{Position: p.positions[firstSite], Message: "site: must be combined with a search term"}
+	}
+
+	return nil
+}
+
+// Makes sure that negated terms are always paired with a term that isn't negated, and that
+// fields and sites are only used in places where they can be translated into a database query.
+func (p *parser) validateTerms(node Node, inField bool) error {
+	switch n := node.(type) {
+	case *And:
+		positive := false
+		for _, child := range n.Children {
+			if _, ok := child.(*Not); !ok {
+				positive = true
+			}
+		}
+		for _, child := range n.Children {
+			if not, ok := child.(*Not); ok {
+				if !positive {
+					return &ParseError{Position: p.positions[not], Message: "a query can't only contain excluded terms"}
+				}
+				child = not.Child
+			}
+			if err := p.validateTerms(child, inField); err != nil {
+				return err
+			}
+		}
+	case *Or:
+		for _, child := range n.Children {
+			if not, ok := child.(*Not); ok {
+				return &ParseError{Position: p.positions[not], Message: "excluded terms can't be combined with OR"}
+			}
+			if err := p.validateTerms(child, inField); err != nil {
+				return err
+			}
+		}
+	case *Not:
+		return &ParseError{Position: p.positions[n], Message: "a query can't only contain excluded terms"}
+	case *Field:
+		if inField {
+			return &ParseError{Position: p.positions[n], Message: "fields can't be nested inside other fields"}
+		}
+		return p.validateTerms(n.Child, true)
+	case *Site:
+		return &ParseError{Position: p.positions[n], Message: "site: can't be used inside parentheses, fields, or OR groups"}
+	}
+	return nil
+}
+
+// ReplaceWords calls `replace` on each search term in the input and substitutes the returned value.
+// Punctuation, operators, field names, and site filters are left intact.
+// This works even if the query can't be parsed.
+func ReplaceWords(input string, replace func(word string) string) string {
+	runes := []rune(input)
+	var result strings.Builder
+	last := 0
+	for _, t := range tokenize(input) {
+		for i, span := range t.spans {
+			result.WriteString(string(runes[last:span[0]]))
+			result.WriteString(replace(t.words[i]))
+			last = span[1]
+		}
+	}
+	result.WriteString(string(runes[last:]))
+	return result.String()
+}
+
+// SplitLastWord separates the word that the user is typing from the rest of the input, so that it can be autocompleted.
+// It returns the input before the word and the word itself. If the input doesn't end with a search term
+// (for example, if it ends with a space, an operator, or a site filter), `ok` is false.
+func SplitLastWord(input string) (before string, word string, ok bool) {
+	tokens := tokenize(input)
+	if len(tokens) == 0 {
+		return "", "", false
+	}
+
+	last := tokens[len(tokens)-1]
+	if last.kind != tokenWord && last.kind != tokenPhrase {
+		return "", "", false
+	}
+
+	// The word must be at the very end of the input. Otherwise, the user has already finished typing it.
+	runes := []rune(input)
+	span := last.spans[len(last.spans)-1]
+	if span[1] != len(runes) {
+		return "", "", false
+	}
+
+	return string(runes[:span[0]]), string(runes[span[0]:span[1]]), true
+}
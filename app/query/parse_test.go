@@ -0,0 +1,164 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  Node
+	}{
+		{input: "hello", want: &Term{Value: "hello", Prefix: true}},
+		{input: "Hello, world!", want: &And{Children: []Node{&Term{Value: "Hello"}, &Term{Value: "world", Prefix: true}}}},
+		{input: `"exact phrase"`, want: &Phrase{Words: []string{"exact", "phrase"}}},
+		{input: `"unterminated phrase`, want: &Phrase{Words: []string{"unterminated", "phrase"}}},
+		{input: `"single"`, want: &Term{Value: "single"}},
+		{input: "prefix* match", want: &And{Children: []Node{&Term{Value: "prefix", Prefix: true}, &Term{Value: "match", Prefix: true}}}},
+		{input: "a OR b", want: &Or{Children: []Node{&Term{Value: "a"}, &Term{Value: "b", Prefix: true}}}},
+		{input: "a AND b OR c", want: &Or{Children: []Node{
+			&And{Children: []Node{&Term{Value: "a"}, &Term{Value: "b"}}},
+			&Term{Value: "c", Prefix: true},
+		}}},
+		{input: "a (b OR c)", want: &And{Children: []Node{
+			&Term{Value: "a"},
+			&Or{Children: []Node{&Term{Value: "b"}, &Term{Value: "c"}}},
+		}}},
+		{input: "a -b NOT c", want: &And{Children: []Node{
+			&Term{Value: "a"},
+			&Not{Child: &Term{Value: "b"}},
+			&Not{Child: &Term{Value: "c", Prefix: true}},
+		}}},
+		{input: "a (b c) d", want: &And{Children: []Node{&Term{Value: "a"}, &Term{Value: "b"}, &Term{Value: "c"}, &Term{Value: "d", Prefix: true}}}},
+		{input: "e-mail", want: &And{Children: []Node{&Term{Value: "e"}, &Term{Value: "mail", Prefix: true}}}},
+		{input: "a --b", want: &And{Children: []Node{&Term{Value: "a"}, &Term{Value: "b", Prefix: true}}}},
+		{input: "using keywords like and or", want: &And{Children: []Node{
+			&Term{Value: "using"}, &Term{Value: "keywords"}, &Term{Value: "like"}, &Term{Value: "and"}, &Term{Value: "or", Prefix: true},
+		}}},
+		{input: "a OR", want: &And{Children: []Node{&Term{Value: "a"}, &Term{Value: "OR", Prefix: true}}}},
+		{input: "AND a", want: &And{Children: []Node{&Term{Value: "AND"}, &Term{Value: "a", Prefix: true}}}},
+		{input: "a NOT", want: &And{Children: []Node{&Term{Value: "a"}, &Term{Value: "NOT", Prefix: true}}}},
+		{input: "using keywords like AND and OR", want: &And{Children: []Node{
+			&Term{Value: "using"}, &Term{Value: "keywords"}, &Term{Value: "like"}, &Term{Value: "AND"}, &Term{Value: "and"}, &Term{Value: "OR", Prefix: true},
+		}}},
+		{input: "(a AND OR b) -c", want: &And{Children: []Node{
+			&Term{Value: "a"}, &Term{Value: "AND"}, &Term{Value: "OR"}, &Term{Value: "b"}, &Not{Child: &Term{Value: "c", Prefix: true}},
+		}}},
+		{input: "title:install guide", want: &And{Children: []Node{
+			&Field{Name: FieldTitle, Child: &Term{Value: "install"}},
+			&Term{Value: "guide", Prefix: true},
+		}}},
+		{input: `inurl:(blog OR news) "release notes"`, want: &And{Children: []Node{
+			&Field{Name: FieldURL, Child: &Or{Children: []Node{&Term{Value: "blog"}, &Term{Value: "news"}}}},
+			&Phrase{Words: []string{"release", "notes"}},
+		}}},
+		{input: "install site:Docs.Example.com/guide/ -site:example.com/old", want: &And{Children: []Node{
+			&Term{Value: "install"},
+			&Site{Host: "docs.example.com", Path: "/guide"},
+			&Not{Child: &Site{Host: "example.com", Path: "/old"}},
+		}}},
+		{input: "unknown:field", want: &And{Children: []Node{&Term{Value: "unknown"}, &Term{Value: "field", Prefix: true}}}},
+		{input: "café naïve", want: &And{Children: []Node{&Term{Value: "café"}, &Term{Value: "naïve", Prefix: true}}}},
+	}
+
+	for _, c := range testCases {
+		got, err := Parse(c.input)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", c.input, err)
+		}
+		if !reflect.DeepEqual(c.want, got) {
+			t.Fatalf("unexpected result parsing %q: wanted %v, got %v", c.input, c.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		input    string
+		position int
+	}{
+		{input: "", position: 0},
+		{input: "!!!", position: 0},
+		{input: "(a b", position: 0},
+		{input: "a b)", position: 3},
+		{input: "a ()", position: 3},
+		{input: "-a", position: 0},
+		{input: "(-b -c) OR a", position: 1},
+		{input: "a OR -b", position: 5},
+		{input: "site:example.com", position: 0},
+		{input: "a site:", position: 2},
+		{input: "a OR site:example.com", position: 5},
+		{input: "title:(a url:b)", position: 9},
+		{input: "a title:", position: 8},
+	}
+
+	for _, c := range testCases {
+		_, err := Parse(c.input)
+		if err == nil {
+			t.Fatalf("expected an error parsing %q", c.input)
+		}
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("expected a *ParseError parsing %q, got %T", c.input, err)
+		}
+		if parseErr.Position != c.position {
+			t.Fatalf("unexpected error position parsing %q: wanted %v, got %v (%v)", c.input, c.position, parseErr.Position, parseErr)
+		}
+	}
+}
+
+func TestExtractSites(t *testing.T) {
+	node, err := Parse("install site:a.example.com site:b.example.com -site:c.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	remaining, included, excluded := ExtractSites(node)
+
+	if !reflect.DeepEqual(remaining, &Term{Value: "install"}) {
+		t.Fatalf("unexpected remaining query: %+v", remaining)
+	}
+	if len(included) != 2 || included[0].Host != "a.example.com" || included[1].Host != "b.example.com" {
+		t.Fatalf("unexpected included sites: %+v", included)
+	}
+	if len(excluded) != 1 || excluded[0].Host != "c.example.com" {
+		t.Fatalf("unexpected excluded sites: %+v", excluded)
+	}
+}
+
+func TestReplaceWords(t *testing.T) {
+	got := ReplaceWords(`Hello (wrld OR "big plnet") title:tst site:exmple.com AND NOT`, strings.ToUpper)
+	want := `HELLO (WRLD OR "BIG PLNET") title:TST site:exmple.com AND NOT`
+	if got != want {
+		t.Fatalf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestSplitLastWord(t *testing.T) {
+	testCases := []struct {
+		input  string
+		before string
+		word   string
+		ok     bool
+	}{
+		{input: "inst", before: "", word: "inst", ok: true},
+		{input: "how to inst", before: "how to ", word: "inst", ok: true},
+		{input: "title:inst", before: "title:", word: "inst", ok: true},
+		{input: `"release no`, before: `"release `, word: "no", ok: true},
+		{input: "install ", ok: false},
+		{input: "install*", ok: false},
+		{input: `"release notes"`, ok: false},
+		{input: "install AND", ok: false},
+		{input: "install site:exa", ok: false},
+		{input: "", ok: false},
+	}
+
+	for _, c := range testCases {
+		before, word, ok := SplitLastWord(c.input)
+		if ok != c.ok || before != c.before || word != c.word {
+			t.Fatalf("unexpected result splitting %q: wanted (%q, %q, %v), got (%q, %q, %v)", c.input, c.before, c.word, c.ok, before, word, ok)
+		}
+	}
+}
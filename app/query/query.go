@@ -0,0 +1,162 @@
+// Package query parses user-entered search queries into a syntax tree.
+// The tree doesn't depend on any particular database, so each driver can compile it into its own query language.
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is an element of a parsed query. It is one of *And, *Or, *Not, *Term, *Phrase, *Field, or *Site.
+type Node interface {
+	node()
+}
+
+// And matches documents that match all of its children.
+type And struct {
+	Children []Node
+}
+
+// Or matches documents that match at least one of its children.
+type Or struct {
+	Children []Node
+}
+
+// Not excludes documents that match its child.
+// It only appears as a child of an And node that has at least one child which isn't negated.
+type Not struct {
+	Child Node
+}
+
+// Term matches a single word.
+type Term struct {
+	Value string
+	// If true, the term matches any word that starts with Value.
+	Prefix bool
+}
+
+// Phrase matches a sequence of words in order.
+type Phrase struct {
+	Words []string
+}
+
+// Field restricts its child to matching text in one part of the page, like `title:install`.
+type Field struct {
+	// One of FieldURL, FieldTitle, FieldDescription, or FieldContent
+	Name  string
+	Child Node
+}
+
+const (
+	FieldURL         = "url"
+	FieldTitle       = "title"
+	FieldDescription = "description"
+	FieldContent     = "content"
+)
+
+// Maps the field names that users can type to the fields they search
+var fieldNames = map[string]string{
+	"url":         FieldURL,
+	"inurl":       FieldURL,
+	"title":       FieldTitle,
+	"intitle":     FieldTitle,
+	"description": FieldDescription,
+	"content":     FieldContent,
+}
+
+// Site restricts results to pages on a host (including its subdomains), like `site:docs.example.com`.
+// If Path is set, only pages at that path or below it are included, like `site:example.com/docs`.
+// Sites only appear at the top level of a query. Use ExtractSites to separate them from the rest of the query.
+type Site struct {
+	// The lowercase hostname, without a scheme or port
+	Host string
+	// The path prefix, starting with a slash and without a trailing slash, or an empty string
+	Path string
+}
+
+func (*And) node()    {}
+func (*Or) node()     {}
+func (*Not) node()    {}
+func (*Term) node()   {}
+func (*Phrase) node() {}
+func (*Field) node()  {}
+func (*Site) node()   {}
+
+// ParseError is returned when a query can't be parsed.
+type ParseError struct {
+	// The offset of the character that caused the error, in runes, starting at 0.
+	Position int
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+// IsOperator returns whether the word is treated as a boolean operator rather than a search term.
+// Operators are case-sensitive, so users can still search for the lowercase words "and", "or", and "not".
+func IsOperator(word string) bool {
+	return word == "AND" || word == "OR" || word == "NOT"
+}
+
+// ExtractSites separates the site: filters from a parsed query.
+// It returns the remaining query, the sites that results must be on (any of them), and the sites that results must not be on.
+func ExtractSites(node Node) (Node, []*Site, []*Site) {
+	children := []Node{node}
+	if and, ok := node.(*And); ok {
+		children = and.Children
+	}
+
+	remaining := make([]Node, 0, len(children))
+	included := []*Site{}
+	excluded := []*Site{}
+
+	for _, child := range children {
+		switch c := child.(type) {
+		case *Site:
+			included = append(included, c)
+			continue
+		case *Not:
+			if site, ok := c.Child.(*Site); ok {
+				excluded = append(excluded, site)
+				continue
+			}
+		}
+		remaining = append(remaining, child)
+	}
+
+	if len(remaining) == 1 {
+		return remaining[0], included, excluded
+	}
+	return &And{Children: remaining}, included, excluded
+}
+
+// Text returns the words that a result should contain, separated by spaces.
+// Excluded terms, field names, and sites are omitted. This is useful when the query needs to be interpreted as natural language, like when generating embeddings.
+func Text(node Node) string {
+	return strings.Join(positiveWords(node), " ")
+}
+
+func positiveWords(node Node) []string {
+	switch n := node.(type) {
+	case *And:
+		return childWords(n.Children)
+	case *Or:
+		return childWords(n.Children)
+	case *Field:
+		return positiveWords(n.Child)
+	case *Term:
+		return []string{n.Value}
+	case *Phrase:
+		return n.Words
+	}
+	return []string{}
+}
+
+func childWords(children []Node) []string {
+	words := []string{}
+	for _, child := range children {
+		words = append(words, positiveWords(child)...)
+	}
+	return words
+}
@@ -0,0 +1,165 @@
+package query
+
+import (
+	"slices"
+	"strings"
+)
+
+// Synonyms maps words and phrases to alternatives that should match the same documents.
+// Words are compared case-insensitively.
+type Synonyms struct {
+	// Maps a lowercase word or phrase (with words separated by single spaces) to its alternatives
+	expansions map[string][]string
+	// The number of words in the longest word or phrase that has alternatives
+	maxWords int
+}
+
+func NewSynonyms() *Synonyms {
+	return &Synonyms{expansions: map[string][]string{}}
+}
+
+// AddEquivalent makes each word or phrase in the group match all of the others.
+func (s *Synonyms) AddEquivalent(group []string) {
+	for _, from := range group {
+		s.AddOneWay(from, group)
+	}
+}
+
+// AddOneWay makes a word or phrase also match each of `to`, but not the other way around.
+func (s *Synonyms) AddOneWay(from string, to []string) {
+	key := normalizeSynonym(from)
+	if key == "" {
+		return
+	}
+	for _, alternative := range to {
+		alternative = normalizeSynonym(alternative)
+		if alternative != "" && alternative != key && !slices.Contains(s.expansions[key], alternative) {
+			s.expansions[key] = append(s.expansions[key], alternative)
+		}
+	}
+	s.maxWords = max(s.maxWords, len(strings.Fields(key)))
+}
+
+func normalizeSynonym(str string) string {
+	return strings.Join(strings.Fields(strings.ToLower(str)), " ")
+}
+
+// ExpandSynonyms replaces words and sequences of words in the query that have synonyms with an Or node that matches the original words or any of their synonyms.
+// Multi-word synonyms become phrases. Words inside phrases aren't expanded, since phrases are meant to match exactly.
+// If more than one set of synonyms is given, they're combined.
+func ExpandSynonyms(node Node, synonyms ...*Synonyms) Node {
+	sets := make(synonymSets, 0, len(synonyms))
+	for _, set := range synonyms {
+		if set != nil && len(set.expansions) > 0 {
+			sets = append(sets, set)
+		}
+	}
+	if len(sets) == 0 {
+		return node
+	}
+	return sets.expand(node)
+}
+
+// Sets of synonyms that are used together. Words are looked up in each set, so the sets don't have to be copied into one for every query.
+type synonymSets []*Synonyms
+
+// Returns the alternatives to a word or phrase from all of the sets
+func (s synonymSets) alternatives(key string) []string {
+	if len(s) == 1 {
+		return s[0].expansions[key]
+	}
+	var alternatives []string
+	for _, set := range s {
+		for _, alternative := range set.expansions[key] {
+			if !slices.Contains(alternatives, alternative) {
+				alternatives = append(alternatives, alternative)
+			}
+		}
+	}
+	return alternatives
+}
+
+// Returns the number of words in the longest word or phrase that has alternatives in any of the sets
+func (s synonymSets) maxWords() int {
+	longest := 0
+	for _, set := range s {
+		longest = max(longest, set.maxWords)
+	}
+	return longest
+}
+
+func (s synonymSets) expand(node Node) Node {
+	switch n := node.(type) {
+	case *And:
+		return &And{Children: s.expandSequence(n.Children)}
+	case *Or:
+		children := make([]Node, 0, len(n.Children))
+		for _, child := range n.Children {
+			children = append(children, s.expand(child))
+		}
+		return &Or{Children: children}
+	case *Not:
+		return &Not{Child: s.expand(n.Child)}
+	case *Field:
+		return &Field{Name: n.Name, Child: s.expand(n.Child)}
+	case *Term:
+		return s.expandSequence([]Node{n})[0]
+	}
+	return node
+}
+
+// Expands the children of an And node. Consecutive terms are matched against multi-word synonyms, preferring the longest match.
+func (s synonymSets) expandSequence(children []Node) []Node {
+	maxWords := s.maxWords()
+	expanded := make([]Node, 0, len(children))
+	for i := 0; i < len(children); {
+		matched := 0
+		for length := min(maxWords, len(children)-i); length > 0; length-- {
+			alternatives := s.alternatives(termSequence(children[i : i+length]))
+			if len(alternatives) == 0 {
+				continue
+			}
+
+			// The original words are kept as they were typed, so a prefix at the end of the query still matches partial words
+			var original Node = children[i]
+			if length > 1 {
+				original = &And{Children: slices.Clone(children[i : i+length])}
+			}
+			or := &Or{Children: []Node{original}}
+			for _, alternative := range alternatives {
+				if words := strings.Fields(alternative); len(words) > 1 {
+					or.Children = append(or.Children, &Phrase{Words: words})
+				} else {
+					or.Children = append(or.Children, &Term{Value: alternative})
+				}
+			}
+			expanded = append(expanded, or)
+			matched = length
+			break
+		}
+
+		if matched == 0 {
+			if _, ok := children[i].(*Term); ok {
+				expanded = append(expanded, children[i])
+			} else {
+				expanded = append(expanded, s.expand(children[i]))
+			}
+			matched = 1
+		}
+		i += matched
+	}
+	return expanded
+}
+
+// Returns the lowercase words of a sequence of terms separated by spaces, or an empty string if any of the nodes aren't terms
+func termSequence(nodes []Node) string {
+	words := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		term, ok := node.(*Term)
+		if !ok {
+			return ""
+		}
+		words = append(words, strings.ToLower(term.Value))
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,70 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandSynonyms(t *testing.T) {
+	synonyms := NewSynonyms()
+	synonyms.AddEquivalent([]string{"login", "Log In", "sign in"})
+	synonyms.AddOneWay("tv", []string{"television"})
+
+	testCases := []struct {
+		input string
+		want  Node
+	}{
+		{input: "login", want: &Or{Children: []Node{
+			&Term{Value: "login", Prefix: true},
+			&Phrase{Words: []string{"log", "in"}},
+			&Phrase{Words: []string{"sign", "in"}},
+		}}},
+		{input: "how to sign in", want: &And{Children: []Node{
+			&Term{Value: "how"},
+			&Term{Value: "to"},
+			&Or{Children: []Node{
+				&And{Children: []Node{&Term{Value: "sign"}, &Term{Value: "in", Prefix: true}}},
+				&Term{Value: "login"},
+				&Phrase{Words: []string{"log", "in"}},
+			}},
+		}}},
+		{input: "TV -television", want: &And{Children: []Node{
+			&Or{Children: []Node{&Term{Value: "TV"}, &Term{Value: "television"}}},
+			&Not{Child: &Term{Value: "television", Prefix: true}},
+		}}},
+		{input: "title:tv", want: &Field{Name: FieldTitle, Child: &Or{Children: []Node{&Term{Value: "tv", Prefix: true}, &Term{Value: "television"}}}}},
+		// Phrases aren't expanded
+		{input: `"sign in" sign`, want: &And{Children: []Node{&Phrase{Words: []string{"sign", "in"}}, &Term{Value: "sign", Prefix: true}}}},
+	}
+
+	for _, c := range testCases {
+		parsed, err := Parse(c.input)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", c.input, err)
+		}
+		got := ExpandSynonyms(parsed, nil, synonyms)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("unexpected expansion of %q: wanted %v, got %v", c.input, c.want, got)
+		}
+	}
+}
+
+func TestExpandSynonymsFromSeveralSets(t *testing.T) {
+	first := NewSynonyms()
+	first.AddOneWay("tv", []string{"television"})
+	second := NewSynonyms()
+	second.AddOneWay("tv", []string{"television", "telly"})
+	second.AddEquivalent([]string{"sign in", "login"})
+
+	parsed, err := Parse("tv sign in")
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	want := &And{Children: []Node{
+		&Or{Children: []Node{&Term{Value: "tv"}, &Term{Value: "television"}, &Term{Value: "telly"}}},
+		&Or{Children: []Node{&And{Children: []Node{&Term{Value: "sign"}, &Term{Value: "in", Prefix: true}}}, &Term{Value: "login"}}},
+	}}
+	if got := ExpandSynonyms(parsed, first, second); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected expansion: wanted %v, got %v", want, got)
+	}
+}
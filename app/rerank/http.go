@@ -0,0 +1,112 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// The request and response formats that HTTP rerankers can use
+const (
+	// The format of Cohere's and Jina's `/rerank` APIs, which is also used by many self-hosted rerankers
+	FormatCohere = "cohere"
+	// The format of Hugging Face Text Embeddings Inference's `/rerank` API
+	FormatTEI = "tei"
+)
+
+// A reranker that calls an external `/rerank` API
+type HTTPReranker struct {
+	// The full URL of the endpoint, like "https://api.cohere.com/v2/rerank" or "http://localhost:8080/rerank"
+	URL    string
+	Model  string
+	APIKey string
+	// One of the Format constants. Defaults to FormatCohere.
+	Format string
+	Client *http.Client
+}
+
+func (r *HTTPReranker) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	var body any
+	if r.Format == FormatTEI {
+		body = map[string]any{"query": query, "texts": documents}
+	} else {
+		body = map[string]any{"model": r.Model, "query": query, "documents": documents}
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.URL, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling reranker: %v", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading reranker response: %v", err)
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("reranker returned status %v: %s", res.StatusCode, data)
+	}
+
+	// Both formats return a list of document indices and scores, in order of relevance
+	type result struct {
+		Index          int      `json:"index"`
+		RelevanceScore *float64 `json:"relevance_score"`
+		Score          *float64 `json:"score"`
+	}
+	var results []result
+	if r.Format == FormatTEI {
+		err = json.Unmarshal(data, &results)
+	} else {
+		var wrapper struct {
+			Results []result `json:"results"`
+		}
+		err = json.Unmarshal(data, &wrapper)
+		results = wrapper.Results
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing reranker response: %v", err)
+	}
+
+	scores := make([]float64, len(documents))
+	found := make([]bool, len(documents))
+	for _, res := range results {
+		if res.Index < 0 || res.Index >= len(documents) {
+			return nil, fmt.Errorf("reranker returned a score for document %v, but only %v documents were sent", res.Index, len(documents))
+		}
+		switch {
+		case res.RelevanceScore != nil:
+			scores[res.Index] = *res.RelevanceScore
+		case res.Score != nil:
+			scores[res.Index] = *res.Score
+		default:
+			return nil, fmt.Errorf("reranker didn't return a score for document %v", res.Index)
+		}
+		found[res.Index] = true
+	}
+	for i, ok := range found {
+		if !ok {
+			return nil, fmt.Errorf("reranker didn't return a score for document %v", i)
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,97 @@
+package rerank
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// A reranker that runs without a model. Like a cross-encoder, it scores the query and each document together:
+// documents that contain more of the query's words, closer together, and in the same order as the query score higher.
+// This helps with queries whose words are scattered across long pages, which bm25 ranks highly.
+type LocalReranker struct{}
+
+func (LocalReranker) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	terms := unique(words(query))
+	scores := make([]float64, len(documents))
+	if len(terms) == 0 {
+		return scores, nil
+	}
+	for i, doc := range documents {
+		scores[i] = localScore(terms, words(doc))
+	}
+	return scores, nil
+}
+
+// Returns a score between 0 and 3: up to 1 for the share of query terms that the document contains,
+// up to 1 for how close together they are, and 1 if the document contains the whole query as a phrase
+func localScore(terms []string, doc []string) float64 {
+	index := make(map[string]int, len(terms))
+	for i, term := range terms {
+		index[term] = i
+	}
+
+	// Find the shortest span of the document that contains every query term that appears in it
+	counts := make([]int, len(terms))
+	matched := 0
+	for _, word := range doc {
+		if i, ok := index[word]; ok {
+			if counts[i] == 0 {
+				matched++
+			}
+			counts[i]++
+		}
+	}
+	if matched == 0 {
+		return 0
+	}
+
+	window := make([]int, len(terms))
+	inWindow, start, shortest := 0, 0, len(doc)
+	for end, word := range doc {
+		if i, ok := index[word]; ok {
+			if window[i] == 0 {
+				inWindow++
+			}
+			window[i]++
+		}
+		for inWindow == matched {
+			shortest = min(shortest, end-start+1)
+			if i, ok := index[doc[start]]; ok {
+				window[i]--
+				if window[i] == 0 {
+					inWindow--
+				}
+			}
+			start++
+		}
+	}
+
+	coverage := float64(matched) / float64(len(terms))
+	proximity := float64(matched) / float64(shortest)
+	score := coverage + proximity*coverage
+	if len(terms) > 1 && strings.Contains(" "+strings.Join(doc, " ")+" ", " "+strings.Join(terms, " ")+" ") {
+		score++
+	}
+	return score
+}
+
+// Splits text into lowercase words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Removes repeated words, keeping the first occurrence of each
+func unique(words []string) []string {
+	seen := make(map[string]struct{}, len(words))
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		if _, ok := seen[word]; !ok {
+			seen[word] = struct{}{}
+			result = append(result, word)
+		}
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package rerank
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Scores how relevant documents are to a query. Unlike the first stage of a search, a reranker sees the query and each document together,
+// so it can be slower and more accurate.
+type Reranker interface {
+	// Returns one score for each document, in the same order. Higher scores are more relevant.
+	Rerank(ctx context.Context, query string, documents []string) ([]float64, error)
+}
+
+// Reorders the first `topN` items by their scores from the reranker. Items after the first `topN` keep their positions.
+// `text` returns the text of an item that's sent to the reranker, and `record` is called with each reranked item's original position (starting at 1) and score.
+func Apply[T any](ctx context.Context, reranker Reranker, query string, items []T, topN int, text func(T) string, record func(item *T, originalRank int, score float64)) error {
+	candidates := items[:min(topN, len(items))]
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	documents := make([]string, len(candidates))
+	for i, item := range candidates {
+		documents[i] = text(item)
+	}
+	scores, err := reranker.Rerank(ctx, query, documents)
+	if err != nil {
+		return err
+	}
+	if len(scores) != len(candidates) {
+		return fmt.Errorf("reranker returned %v scores for %v documents", len(scores), len(candidates))
+	}
+
+	type scored struct {
+		item  T
+		rank  int
+		score float64
+	}
+	reordered := make([]scored, len(candidates))
+	for i, item := range candidates {
+		reordered[i] = scored{item: item, rank: i + 1, score: scores[i]}
+	}
+	// Items with the same score keep their original order
+	slices.SortStableFunc(reordered, func(a, b scored) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	for i, s := range reordered {
+		candidates[i] = s.item
+		record(&candidates[i], s.rank, s.score)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type result struct {
+	text         string
+	originalRank int
+	score        float64
+}
+
+func TestApply(t *testing.T) {
+	items := []result{{text: "a"}, {text: "b"}, {text: "c"}, {text: "d"}}
+	scores := map[string]float64{"a": 0.1, "b": 0.9, "c": 0.5}
+	reranker := stubReranker(func(documents []string) []float64 {
+		result := make([]float64, len(documents))
+		for i, doc := range documents {
+			result[i] = scores[doc]
+		}
+		return result
+	})
+
+	err := Apply(context.Background(), reranker, "query", items, 3, func(r result) string { return r.text }, func(r *result, originalRank int, score float64) {
+		r.originalRank, r.score = originalRank, score
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Only the first three items are reranked
+	want := []result{{"b", 2, 0.9}, {"c", 3, 0.5}, {"a", 1, 0.1}, {"d", 0, 0}}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("unexpected order: %+v", items)
+	}
+}
+
+type stubReranker func(documents []string) []float64
+
+func (s stubReranker) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	return s(documents), nil
+}
+
+func TestHTTPReranker(t *testing.T) {
+	for _, format := range []string{FormatCohere, FormatTEI} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			var body struct {
+				Query     string   `json:"query"`
+				Model     string   `json:"model"`
+				Documents []string `json:"documents"`
+				Texts     []string `json:"texts"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			if req.Header.Get("Authorization") != "Bearer key" || body.Query != "query" {
+				t.Errorf("unexpected request: %+v", body)
+			}
+			// The results are sorted by relevance, not by index
+			if format == FormatTEI {
+				if len(body.Texts) != 2 {
+					t.Errorf("expected texts in the TEI format: %+v", body)
+				}
+				fmt.Fprint(w, `[{"index": 1, "score": 0.8}, {"index": 0, "score": 0.2}]`)
+			} else {
+				if len(body.Documents) != 2 || body.Model != "rerank-model" {
+					t.Errorf("expected documents and a model in the Cohere format: %+v", body)
+				}
+				fmt.Fprint(w, `{"results": [{"index": 1, "relevance_score": 0.8}, {"index": 0, "relevance_score": 0.2}]}`)
+			}
+		}))
+
+		reranker := &HTTPReranker{URL: server.URL + "/rerank", Model: "rerank-model", APIKey: "key", Format: format}
+		scores, err := reranker.Rerank(context.Background(), "query", []string{"first", "second"})
+		server.Close()
+		if err != nil {
+			t.Fatalf("unexpected error with format %v: %v", format, err)
+		}
+		if !reflect.DeepEqual(scores, []float64{0.2, 0.8}) {
+			t.Fatalf("unexpected scores with format %v: %v", format, scores)
+		}
+	}
+}
+
+func TestLocalReranker(t *testing.T) {
+	documents := []string{
+		"Rust is fast. Our blog also covers Go, and we care about memory safety.",
+		"Nothing relevant here.",
+		"Memory safety in Rust, explained.",
+		"Safety tips for memory.",
+	}
+	scores, err := LocalReranker{}.Rerank(context.Background(), "rust memory safety", documents)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The document with the terms close together and in order ranks first, and the document without any terms ranks last
+	if !(scores[2] > scores[0] && scores[0] > scores[3] && scores[3] > scores[1] && scores[1] == 0) {
+		t.Fatalf("unexpected scores: %v", scores)
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/crawler"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	slogctx "github.com/veqryn/slog-context"
+)
+
+type adminResponse struct {
+	status       int16
+	Success      bool                `json:"success"`
+	Error        string              `json:"error,omitempty"`
+	Curation     *database.Curation  `json:"curation,omitempty"`
+	Curations    []database.Curation `json:"curations,omitempty"`
+	Hosts        []hostStatus        `json:"hosts,omitempty"`
+	ResponseTime float64             `json:"responseTime"`
+}
+
+// Adds the admin API's routes to the mux. The admin API is only enabled if an API key is configured.
+func registerAdminRoutes(mux *http.ServeMux, db database.Database, cfg *config.Config) {
+	if cfg.Admin.APIKey == "" {
+		return
+	}
+
+	// Wraps a handler so that it requires the API key and can respond with an `adminResponse`
+	handle := func(pattern string, handler func(req *http.Request) adminResponse) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, req *http.Request) {
+			timeStart := time.Now().UnixMicro()
+
+			var response adminResponse
+			token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.APIKey)) != 1 {
+				response = adminResponse{status: 401, Success: false, Error: "Unauthorized"}
+			} else {
+				response = handler(req)
+			}
+
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(int(response.status))
+			response.ResponseTime = float64(time.Now().UnixMicro()-timeStart) / 1e6
+			str, err := json.Marshal(response)
+			if err != nil {
+				w.Write([]byte(`{"success":"false","error":"Failed to marshal struct into JSON"}`))
+			} else {
+				w.Write([]byte(str))
+			}
+		})
+	}
+
+	internalError := func(req *http.Request, msg string, err error) adminResponse {
+		slogctx.Error(req.Context(), msg, "error", err)
+		return adminResponse{status: 500, Success: false, Error: "Internal server error"}
+	}
+
+	handle("GET /api/admin/curations", func(req *http.Request) adminResponse {
+		source := req.URL.Query().Get("source")
+		if source == "" {
+			return adminResponse{status: 400, Success: false, Error: "Bad request"}
+		}
+		curations, err := db.ListCurations(req.Context(), source)
+		if err != nil {
+			return internalError(req, "Failed to list curations", err)
+		}
+		return adminResponse{status: 200, Success: true, Curations: curations}
+	})
+
+	handle("POST /api/admin/curations", func(req *http.Request) adminResponse {
+		curation, err := readCuration(req, cfg)
+		if err != nil {
+			return adminResponse{status: 400, Success: false, Error: "Bad request: " + err.Error()}
+		}
+		curation.ID = 0
+		return saveCuration(req, db, curation, 201, internalError)
+	})
+
+	handle("GET /api/admin/curations/{id}", func(req *http.Request) adminResponse {
+		id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+		if err != nil {
+			return adminResponse{status: 400, Success: false, Error: "Bad request"}
+		}
+		curation, err := db.GetCuration(req.Context(), id)
+		if errors.Is(err, database.ErrCurationNotFound) {
+			return adminResponse{status: 404, Success: false, Error: "Curation not found"}
+		} else if err != nil {
+			return internalError(req, "Failed to get curation", err)
+		}
+		return adminResponse{status: 200, Success: true, Curation: curation}
+	})
+
+	handle("PUT /api/admin/curations/{id}", func(req *http.Request) adminResponse {
+		id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+		if err != nil {
+			return adminResponse{status: 400, Success: false, Error: "Bad request"}
+		}
+		curation, err := readCuration(req, cfg)
+		if err != nil {
+			return adminResponse{status: 400, Success: false, Error: "Bad request: " + err.Error()}
+		}
+		curation.ID = id
+		return saveCuration(req, db, curation, 200, internalError)
+	})
+
+	handle("DELETE /api/admin/curations/{id}", func(req *http.Request) adminResponse {
+		id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+		if err != nil {
+			return adminResponse{status: 400, Success: false, Error: "Bad request"}
+		}
+		err = db.DeleteCuration(req.Context(), id)
+		if errors.Is(err, database.ErrCurationNotFound) {
+			return adminResponse{status: 404, Success: false, Error: "Curation not found"}
+		} else if err != nil {
+			return internalError(req, "Failed to delete curation", err)
+		}
+		return adminResponse{status: 200, Success: true}
+	})
+
+	handle("GET /api/admin/hosts", func(req *http.Request) adminResponse {
+		return adminResponse{status: 200, Success: true, Hosts: hostStatuses(cfg, crawler.Hosts.Status())}
+	})
+}
+
+// A crawled host's rate limit, combined with the speeds of the sources that crawl it
+type hostStatus struct {
+	crawler.HostStatus
+	// The IDs of the sources that are allowed to crawl the host
+	Sources []string `json:"sources"`
+	// The most requests per minute that are sent to the host, which is the lower of its rate limit and the combined speeds of its sources
+	RequestsPerMinute float64 `json:"requestsPerMinute"`
+}
+
+func hostStatuses(cfg *config.Config, statuses []crawler.HostStatus) []hostStatus {
+	hosts := make([]hostStatus, 0, len(statuses))
+	for _, status := range statuses {
+		host := hostStatus{HostStatus: status, Sources: []string{}, RequestsPerMinute: status.MaxRequestsPerMinute}
+		speed := 0.0
+		for _, src := range cfg.Sources {
+			if slices.Contains(src.AllowedDomains, status.Host) {
+				host.Sources = append(host.Sources, src.ID)
+				speed += float64(src.Speed)
+			}
+		}
+		if len(host.Sources) > 0 {
+			host.RequestsPerMinute = min(host.RequestsPerMinute, speed)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// Saves a curation and responds with the saved version
+func saveCuration(req *http.Request, db database.Database, curation *database.Curation, status int16, internalError func(*http.Request, string, error) adminResponse) adminResponse {
+	id, err := db.SaveCuration(req.Context(), *curation)
+	if errors.Is(err, database.ErrCurationNotFound) {
+		return adminResponse{status: 404, Success: false, Error: "Curation not found"}
+	} else if err != nil {
+		return internalError(req, "Failed to save curation", err)
+	}
+	saved, err := db.GetCuration(req.Context(), id)
+	if err != nil {
+		return internalError(req, "Failed to get saved curation", err)
+	}
+	return adminResponse{status: status, Success: true, Curation: saved}
+}
+
+// Reads a curation from a JSON request body and makes sure it's valid
+func readCuration(req *http.Request, cfg *config.Config) (*database.Curation, error) {
+	curation := &database.Curation{}
+	if err := json.NewDecoder(req.Body).Decode(curation); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if !slices.ContainsFunc(cfg.Sources, func(s config.Source) bool { return s.ID == curation.Source }) {
+		return nil, fmt.Errorf("unknown source %q", curation.Source)
+	}
+	if strings.TrimSpace(curation.Pattern) == "" {
+		return nil, errors.New("`pattern` is required")
+	}
+	switch curation.Match {
+	case "":
+		curation.Match = database.MatchExact
+	case database.MatchExact, database.MatchContains:
+	default:
+		return nil, fmt.Errorf("`match` must be %q or %q", database.MatchExact, database.MatchContains)
+	}
+	if len(curation.Pinned) == 0 && len(curation.Hidden) == 0 {
+		return nil, errors.New("at least one URL must be pinned or hidden")
+	}
+
+	seen := map[string]struct{}{}
+	for _, u := range slices.Concat(curation.Pinned, curation.Hidden) {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q", u)
+		}
+		if _, ok := seen[u]; ok {
+			return nil, fmt.Errorf("URL %q is listed more than once", u)
+		}
+		seen[u] = struct{}{}
+	}
+
+	return curation, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/crawler"
+)
+
+func TestAdminRequiresAPIKey(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Admin.APIKey = "secret"
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, nil, cfg)
+
+	for _, header := range []string{"", "secret", "Bearer wrong"} {
+		req := httptest.NewRequest("GET", "/api/admin/curations?source=source1", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != 401 {
+			t.Errorf("expected status 401 with Authorization header %q, got %v", header, rec.Code)
+		}
+	}
+
+	// Without an API key, the admin API isn't registered at all
+	mux = http.NewServeMux()
+	registerAdminRoutes(mux, nil, &config.Config{})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/admin/curations?source=source1", nil))
+	if rec.Code != 404 {
+		t.Errorf("expected status 404 when the admin API is disabled, got %v", rec.Code)
+	}
+}
+
+func TestReadCuration(t *testing.T) {
+	cfg := &config.Config{Sources: []config.Source{{ID: "source1"}}}
+
+	table := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"source": "source1", "pattern": "install", "pinned": ["https://example.com/install"]}`, true},
+		{`{"source": "source1", "pattern": "install", "match": "contains", "hidden": ["https://example.com/old"]}`, true},
+		{`{"source": "unknown", "pattern": "install", "pinned": ["https://example.com/install"]}`, false},
+		{`{"source": "source1", "pattern": " ", "pinned": ["https://example.com/install"]}`, false},
+		{`{"source": "source1", "pattern": "install", "match": "prefix", "pinned": ["https://example.com/install"]}`, false},
+		{`{"source": "source1", "pattern": "install"}`, false},
+		{`{"source": "source1", "pattern": "install", "pinned": ["/install"]}`, false},
+		{`{"source": "source1", "pattern": "install", "pinned": ["https://example.com/a"], "hidden": ["https://example.com/a"]}`, false},
+		{`{"source": "source1"`, false},
+	}
+
+	for _, test := range table {
+		req := httptest.NewRequest("POST", "/api/admin/curations", strings.NewReader(test.body))
+		curation, err := readCuration(req, cfg)
+		if test.valid && err != nil {
+			t.Errorf("expected %v to be valid, got error: %v", test.body, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("expected %v to be invalid, got %+v", test.body, curation)
+		}
+	}
+}
+
+func TestHostStatuses(t *testing.T) {
+	cfg := &config.Config{Sources: []config.Source{
+		{ID: "source1", AllowedDomains: []string{"example.com"}, Speed: 20},
+		{ID: "source2", AllowedDomains: []string{"example.com", "docs.example.com"}, Speed: 30},
+	}}
+	hosts := hostStatuses(cfg, []crawler.HostStatus{
+		{Host: "example.com", MaxRequestsPerMinute: 60},
+		{Host: "docs.example.com", MaxRequestsPerMinute: 15},
+		{Host: "other.example.com", MaxRequestsPerMinute: 60},
+	})
+
+	want := []struct {
+		sources           []string
+		requestsPerMinute float64
+	}{
+		{[]string{"source1", "source2"}, 50},
+		{[]string{"source2"}, 15},
+		{[]string{}, 60},
+	}
+	for i, host := range hosts {
+		if !slices.Equal(host.Sources, want[i].sources) || host.RequestsPerMinute != want[i].requestsPerMinute {
+			t.Errorf("unexpected status for %v: %+v", host.Host, host)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fluxcapacitor2/easysearch/app/answer"
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	"github.com/fluxcapacitor2/easysearch/app/embedding"
+	slogctx "github.com/veqryn/slog-context"
+)
+
+const (
+	// The number of passages sent to the model if the source doesn't specify a limit
+	defaultAnswerChunks = 8
+	// The maximum number of chunks used from each page, so that one long page doesn't crowd out the others
+	answerChunksPerPage = 2
+)
+
+type answerResponse struct {
+	status       int16
+	Success      bool           `json:"success"`
+	Error        string         `json:"error,omitempty"`
+	Answer       *answer.Answer `json:"answer,omitempty"`
+	ResponseTime float64        `json:"responseTime"`
+}
+
+// A question that's ready to be sent to the model
+type answerRequest struct {
+	question string
+	settings *config.Source
+	passages []answer.Passage
+}
+
+// Registers `/api/answer`, which answers a question using the pages of the requested sources, and `/api/answer/stream`,
+// which sends the answer as server-sent events while it's generated
+func registerAnswerRoutes(mux *http.ServeMux, db database.Database, cfg *config.Config, settings *searchSettings) {
+	mux.HandleFunc("/api/answer", func(w http.ResponseWriter, req *http.Request) {
+		timeStart := time.Now().UnixMicro()
+
+		respond := func(response answerResponse) {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(int(response.status))
+			response.ResponseTime = float64(time.Now().UnixMicro()-timeStart) / 1e6
+			str, err := json.Marshal(response)
+			if err != nil {
+				w.Write([]byte(`{"success":"false","error":"Failed to marshal struct into JSON"}`))
+			} else {
+				w.Write([]byte(str))
+			}
+		}
+
+		request, errResponse := prepareAnswer(req, db, cfg, settings)
+		if errResponse != nil {
+			respond(*errResponse)
+			return
+		}
+
+		result, err := generateAnswer(req.Context(), request, nil)
+		if err != nil {
+			slogctx.Error(req.Context(), "Failed to generate answer", "error", err)
+			respond(answerResponse{status: 500, Success: false, Error: "Internal server error"})
+			return
+		}
+
+		respond(answerResponse{status: 200, Success: true, Answer: result})
+	})
+
+	mux.HandleFunc("/api/answer/stream", func(w http.ResponseWriter, req *http.Request) {
+		request, errResponse := prepareAnswer(req, db, cfg, settings)
+		if errResponse != nil {
+			// Errors that happen before the stream starts are returned as regular JSON responses
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(int(errResponse.status))
+			str, _ := json.Marshal(errResponse)
+			w.Write(str)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(200)
+
+		send := func(event string, data any) error {
+			str, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, str); err != nil {
+				return err
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return nil
+		}
+
+		result, err := generateAnswer(req.Context(), request, func(text string) error {
+			return send("text", map[string]string{"text": text})
+		})
+		if err != nil {
+			slogctx.Error(req.Context(), "Failed to generate streamed answer", "error", err)
+			send("error", map[string]string{"error": "Internal server error"})
+			return
+		}
+		send("done", result)
+	})
+}
+
+// Validates the request, searches for pages that are relevant to the question, and collects the passages that will be sent to the model
+func prepareAnswer(req *http.Request, db database.Database, cfg *config.Config, settings *searchSettings) (*answerRequest, *answerResponse) {
+	src := req.URL.Query()["source"]
+	q := strings.TrimSpace(req.URL.Query().Get("q"))
+	if questionQuery(q) == "" || len(src) == 0 {
+		return nil, &answerResponse{status: 400, Success: false, Error: "Bad request"}
+	}
+
+	foundSources := make([]config.Source, 0, len(src))
+	for _, sourceID := range src {
+		for _, s := range cfg.Sources {
+			if s.ID == sourceID {
+				foundSources = append(foundSources, s)
+				break
+			}
+		}
+	}
+
+	// When multiple sources are searched, the first one with answers enabled chooses the model
+	request := &answerRequest{question: q}
+	for _, s := range foundSources {
+		if s.Answers.Enabled {
+			request.settings = &s
+			break
+		}
+	}
+	if request.settings == nil {
+		return nil, &answerResponse{status: 400, Success: false, Error: "Bad request: answers aren't enabled for these sources"}
+	}
+
+	options, err := searchOptionsFromRequest(req, settings)
+	if err != nil {
+		return nil, &answerResponse{status: 400, Success: false, Error: "Bad request: " + err.Error()}
+	}
+
+	internalError := func(msg string, err error) (*answerRequest, *answerResponse) {
+		slogctx.Error(req.Context(), msg, "error", err)
+		return nil, &answerResponse{status: 500, Success: false, Error: "Internal server error"}
+	}
+
+	embeddedQueries, err := embedQuery(req.Context(), foundSources, q)
+	if err != nil {
+		return internalError("Failed to generate embeddings for question", err)
+	}
+
+	sourceList := make([]string, 0, len(foundSources))
+	for _, s := range foundSources {
+		sourceList = append(sourceList, s.ID)
+	}
+
+	maxChunks := defaultAnswerChunks
+	if request.settings.Answers.MaxChunks > 0 {
+		maxChunks = request.settings.Answers.MaxChunks
+	}
+
+	results, _, err := hybridSearchAndRerank(req.Context(), db, cfg, sourceList, questionQuery(q), options, embeddedQueries, 1, uint32(maxChunks))
+	if err != nil {
+		return internalError("Failed to search for pages to answer question", err)
+	}
+
+	urls := make([]string, 0, len(results))
+	for _, res := range results {
+		urls = append(urls, res.URL)
+	}
+	chunks := map[string][]string{}
+	for sourceID, vector := range embeddedQueries {
+		sourceChunks, err := db.RelevantChunks(req.Context(), sourceID, vector, urls, answerChunksPerPage)
+		if err != nil {
+			return internalError("Failed to find chunks to answer question", err)
+		}
+		for url, c := range sourceChunks {
+			chunks[url] = append(chunks[url], c...)
+		}
+	}
+
+	// Passages are sent in the order of the search results
+	for _, res := range results {
+		texts := chunks[res.URL]
+		if len(texts) == 0 {
+			// Pages that haven't been embedded are represented by their search snippets
+			texts = []string{matchText(res.Description) + "\n" + matchText(res.Content)}
+		}
+		for _, text := range texts {
+			if len(request.passages) < maxChunks {
+				request.passages = append(request.passages, answer.Passage{URL: res.URL, Title: matchText(res.Title), Text: text})
+			}
+		}
+	}
+
+	return request, nil
+}
+
+// Asks the model to answer the question. If no pages are relevant to the question, the answer is empty.
+func generateAnswer(ctx context.Context, request *answerRequest, stream func(text string) error) (*answer.Answer, error) {
+	if len(request.passages) == 0 {
+		return &answer.Answer{Text: "", Citations: []answer.Citation{}}, nil
+	}
+	s := request.settings.Answers
+	return answer.Generate(ctx, s.OpenAIBaseURL, s.Model, s.APIKey, request.question, request.passages, stream)
+}
+
+// Converts a natural-language question into a full-text search query that matches pages containing any of its words.
+// Requiring every word would miss most pages, since questions contain words like "how" and "does". Pages with more of the words still rank higher.
+func questionQuery(question string) string {
+	words := strings.FieldsFunc(question, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+	return strings.Join(words, " OR ")
+}
+
+// Joins the parts of a snippet, removing the highlighting
+func matchText(matches []database.Match) string {
+	var text strings.Builder
+	for _, m := range matches {
+		text.WriteString(m.Content)
+	}
+	return text.String()
+}
+
+// Generates an embedding of the text for each source that has embeddings enabled, keyed by source ID.
+// Sources that use the same model from the same API share an embedding. Different APIs can serve different models under the same name.
+func embedQuery(ctx context.Context, sources []config.Source, text string) (map[string][]float32, error) {
+	type model struct {
+		baseURL string
+		name    string
+	}
+	byModel := make(map[model][]float32)
+	embedded := make(map[string][]float32)
+	for _, s := range sources {
+		if !s.Embeddings.Enabled {
+			continue
+		}
+		m := model{baseURL: s.Embeddings.OpenAIBaseURL, name: s.Embeddings.Model}
+		if byModel[m] == nil {
+			vector, err := embedding.GetEmbeddings(ctx, s.Embeddings.OpenAIBaseURL, s.Embeddings.Model, s.Embeddings.APIKey, []string{text})
+			if err != nil {
+				return nil, err
+			}
+			byModel[m] = vector[0]
+		}
+		embedded[s.ID] = byModel[m]
+	}
+	return embedded, nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	"github.com/fluxcapacitor2/easysearch/app/spellfix"
+)
+
+// Sets up an answer API backed by a database with one page and a stub chat model that always gives the same answer
+func answerTestServer(t *testing.T) *http.ServeMux {
+	vec.Auto()
+	spellfix.Auto()
+	db, err := database.SQLiteFromFile(path.Join(t.TempDir(), "temp.db"))
+	if err != nil {
+		t.Fatalf("database creation failed: %v", err)
+	}
+	if err := db.Setup(context.Background()); err != nil {
+		t.Fatalf("database setup failed: %v", err)
+	}
+	_, err = db.AddDocument(context.Background(), "source1", 1, []int64{}, "https://example.com/pricing", database.Finished, "Pricing", "", "The basic plan costs $5 per month.", "", database.PageMetadata{})
+	if err != nil {
+		t.Fatalf("error adding document: %v", err)
+	}
+
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Stream   bool `json:"stream"`
+			Messages []struct {
+				Content any `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		if len(body.Messages) != 2 || !strings.Contains(fmt.Sprint(body.Messages[1].Content), "$5 per month") {
+			t.Errorf("expected the page's text in the prompt: %+v", body.Messages)
+		}
+		if body.Stream {
+			for _, part := range []string{"It costs $5 ", "per month [1]."} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", part)
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"It costs $5 per month [1]."}}]}`)
+	}))
+	t.Cleanup(llm.Close)
+
+	cfg := &config.Config{Sources: []config.Source{{ID: "source1"}, {ID: "source2"}}}
+	cfg.Sources[0].Answers.Enabled = true
+	cfg.Sources[0].Answers.OpenAIBaseURL = llm.URL
+	cfg.Sources[0].Answers.Model = "test-model"
+
+	mux := http.NewServeMux()
+	registerAnswerRoutes(mux, db, cfg, newSearchSettings(cfg))
+	return mux
+}
+
+func TestAnswer(t *testing.T) {
+	mux := answerTestServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/answer?source=source1&q=How+much+does+the+basic+plan+cost%3F", nil))
+	var response struct {
+		Success bool
+		Answer  struct {
+			Text      string
+			Citations []struct {
+				Number int
+				URL    string
+			}
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != 200 || response.Answer.Text != "It costs $5 per month [1]." || len(response.Answer.Citations) != 1 || response.Answer.Citations[0].URL != "https://example.com/pricing" {
+		t.Fatalf("unexpected response: %v", rec.Body.String())
+	}
+
+	// Sources without answers enabled can't be used
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/answer?source=source2&q=pricing", nil))
+	if rec.Code != 400 {
+		t.Fatalf("expected status 400 for a source without answers, got %v", rec.Code)
+	}
+}
+
+func TestAnswerStream(t *testing.T) {
+	mux := answerTestServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/answer/stream?source=source1&q=basic+plan", nil))
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %v", rec.Header().Get("Content-Type"))
+	}
+
+	want := `event: text
+data: {"text":"It costs $5 "}
+
+event: text
+data: {"text":"per month [1]."}
+
+event: done
+data: {"text":"It costs $5 per month [1].","citations":[{"number":1,"url":"https://example.com/pricing","title":"Pricing"}]}
+
+`
+	if rec.Body.String() != want {
+		t.Fatalf("unexpected events:\n%v", rec.Body.String())
+	}
+}
+
+func TestEmbedQuery(t *testing.T) {
+	// Two APIs that serve different models under the same name
+	requests := 0
+	api := func(vector string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			fmt.Fprintf(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":%v}]}`, vector)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	first, second := api("[1,0]"), api("[0,1]")
+
+	sources := []config.Source{{ID: "source1"}, {ID: "source2"}, {ID: "source3"}, {ID: "source4"}}
+	for i, url := range []string{first.URL, first.URL, second.URL} {
+		sources[i].Embeddings.Enabled = true
+		sources[i].Embeddings.OpenAIBaseURL = url
+		sources[i].Embeddings.Model = "test-model"
+	}
+
+	embedded, err := embedQuery(context.Background(), sources, "query")
+	if err != nil {
+		t.Fatalf("error embedding query: %v", err)
+	}
+	want := map[string][]float32{"source1": {1, 0}, "source2": {1, 0}, "source3": {0, 1}}
+	if !reflect.DeepEqual(embedded, want) || requests != 2 {
+		t.Fatalf("expected one embedding from each API, got %v after %v requests", embedded, requests)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/url"
+	"slices"
+
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// A list of values in the results page's sidebar that the user can click to narrow down their search
+type facetGroup struct {
+	Name   string
+	Values []facetValue
+	// A link that removes this group's filter, or an empty string if the group isn't filtered
+	ClearURL string
+}
+
+type facetValue struct {
+	Label    string
+	Count    uint32
+	URL      string
+	Selected bool
+}
+
+// Readable names for the content kinds stored in the database
+var kindLabels = map[string]string{
+	database.KindPage:     "Web pages",
+	database.KindFeedItem: "Feed items",
+	database.KindDocument: "Documents",
+}
+
+// Returns a copy of the URL with the parameter set to `value` (or removed if `value` is empty), starting from the first page of results
+func facetURL(u *url.URL, key string, value string) string {
+	copied := *u
+	q := copied.Query()
+	if value == "" {
+		q.Del(key)
+	} else {
+		q.Set(key, value)
+	}
+	q.Set("page", "1")
+	copied.RawQuery = q.Encode()
+	return copied.String()
+}
+
+func createFacetGroups(u *url.URL, sources []string, options database.SearchOptions, facets *database.Facets) []facetGroup {
+	if facets == nil {
+		return []facetGroup{}
+	}
+
+	groups := make([]facetGroup, 0, 5)
+
+	// Searching multiple sources is done by repeating the `source` parameter, so choosing one source replaces all of them
+	if len(facets.Sources) > 1 {
+		group := facetGroup{Name: "Source", Values: make([]facetValue, 0, len(facets.Sources))}
+		for _, v := range facets.Sources {
+			group.Values = append(group.Values, facetValue{
+				Label:    v.Value,
+				Count:    v.Count,
+				URL:      facetURL(u, "source", v.Value),
+				Selected: len(sources) == 1 && slices.Contains(sources, v.Value),
+			})
+		}
+		groups = append(groups, group)
+	}
+
+	for _, g := range []struct {
+		name     string
+		param    string
+		selected string
+		values   []database.FacetValue
+		label    func(string) string
+	}{
+		{name: "Site", param: "host", selected: options.Host, values: facets.Hosts, label: func(v string) string { return v }},
+		{name: "Section", param: "section", selected: options.Section, values: facets.Sections, label: func(v string) string { return "/" + v + "/" }},
+		{name: "Type", param: "kind", selected: options.Kind, values: facets.Kinds, label: func(v string) string {
+			if label, ok := kindLabels[v]; ok {
+				return label
+			}
+			return v
+		}},
+		{name: "Language", param: "lang", selected: options.Language, values: facets.Languages, label: languageLabel},
+	} {
+		group := facetGroup{Name: g.name, Values: make([]facetValue, 0, len(g.values))}
+		if g.selected != "" {
+			group.ClearURL = facetURL(u, g.param, "")
+		}
+		for _, v := range g.values {
+			if v.Value == "" {
+				// For example, pages at the root of a site don't have a section. These can't be filtered.
+				continue
+			}
+			group.Values = append(group.Values, facetValue{
+				Label:    g.label(v.Value),
+				Count:    v.Count,
+				URL:      facetURL(u, g.param, v.Value),
+				Selected: v.Value == g.selected,
+			})
+		}
+		// A group with one value isn't useful unless it's the value that the user chose
+		if len(group.Values) > 1 || g.selected != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// Returns a language's name in that language, like "Deutsch" for "de", or the code itself if the name is unknown
+func languageLabel(code string) string {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return code
+	}
+	if name := display.Self.Name(tag); name != "" {
+		return name
+	}
+	return code
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcapacitor2/easysearch/app/database"
+)
+
+func TestFacetGroups(t *testing.T) {
+	u, err := url.Parse("http://localhost:8080/?host=example.com&page=3&q=hello&source=a&source=b")
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+
+	facets := &database.Facets{
+		Sources:   []database.FacetValue{{Value: "a", Count: 5}, {Value: "b", Count: 1}},
+		Hosts:     []database.FacetValue{{Value: "example.com", Count: 4}},
+		Sections:  []database.FacetValue{{Value: "blog", Count: 3}, {Value: "", Count: 1}},
+		Kinds:     []database.FacetValue{{Value: database.KindPage, Count: 4}},
+		Languages: []database.FacetValue{{Value: "en", Count: 3}, {Value: "de", Count: 2}},
+	}
+
+	got := createFacetGroups(u, []string{"a", "b"}, database.SearchOptions{Host: "example.com"}, facets)
+	want := []facetGroup{
+		{Name: "Source", Values: []facetValue{
+			{Label: "a", Count: 5, URL: "http://localhost:8080/?host=example.com&page=1&q=hello&source=a"},
+			{Label: "b", Count: 1, URL: "http://localhost:8080/?host=example.com&page=1&q=hello&source=b"},
+		}},
+		// The host facet only has one value, but it's shown because it's selected
+		{Name: "Site", ClearURL: "http://localhost:8080/?page=1&q=hello&source=a&source=b", Values: []facetValue{
+			{Label: "example.com", Count: 4, URL: "http://localhost:8080/?host=example.com&page=1&q=hello&source=a&source=b", Selected: true},
+		}},
+		// Sections and kinds with only one value that can be filtered aren't shown
+		{Name: "Language", Values: []facetValue{
+			{Label: "English", Count: 3, URL: "http://localhost:8080/?host=example.com&lang=en&page=1&q=hello&source=a&source=b"},
+			{Label: "Deutsch", Count: 2, URL: "http://localhost:8080/?host=example.com&lang=de&page=1&q=hello&source=a&source=b"},
+		}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected facet groups:\nwanted %+v\ngot    %+v", want, got)
+	}
+
+	// Creating the links shouldn't modify the original URL
+	if u.String() != "http://localhost:8080/?host=example.com&page=3&q=hello&source=a&source=b" {
+		t.Fatalf("URL was modified: %v", u)
+	}
+}
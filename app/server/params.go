@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+)
+
+const (
+	// The number of results on each page if the request doesn't specify a page size
+	defaultPageSize = 10
+	// The largest page size if the config doesn't specify one
+	defaultMaxPageSize = 100
+	// Vector searches have to fetch every result up to the requested page, so they can't go deeper than this
+	maxVectorResults = database.MaxVectorResults
+	// The largest number of content snippets that each result can have
+	maxContentSnippets = 5
+	// The tag that wraps matches in HTML highlights if the request doesn't specify one
+	defaultHighlightTag = "mark"
+)
+
+var highlightTagPattern = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9-]*$")
+
+// The validated URL parameters of a search, which are shared by the results page and the search APIs
+type searchParams struct {
+	// The page number, starting at 1
+	Page     uint32
+	PageSize uint32
+	// The filters, ranking, and snippet settings
+	Options database.SearchOptions
+	// If set, the APIs return highlighted text as HTML strings where matches are wrapped in this tag, instead of arrays of matches
+	HighlightTag string
+	// The `class` attribute of the highlight tag, if any
+	HighlightClass string
+}
+
+// Reads the parameters that are common to all searches: `page`, `pageSize`, the snippet settings (`descriptionTokens`, `contentTokens`, and `snippets`),
+// the highlight format (`highlight`, `highlightTag`, and `highlightClass`), and the filters from searchOptionsFromRequest
+func searchParamsFromRequest(req *http.Request, cfg *config.Config, settings *searchSettings) (searchParams, error) {
+	params := searchParams{Page: 1, PageSize: defaultPageSize}
+	maxPageSize := defaultMaxPageSize
+	if cfg.Search.MaxPageSize > 0 {
+		maxPageSize = cfg.Search.MaxPageSize
+	}
+
+	// Reads an optional integer parameter, which must be between `min` and `max`
+	intParam := func(name string, min int, max int) (int, error) {
+		value := req.URL.Query().Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value for `%s`: expected an integer between %d and %d", name, min, max)
+		}
+		return n, nil
+	}
+
+	page, err := intParam("page", 1, 1<<31-1)
+	if err != nil {
+		return params, err
+	}
+	pageSize, err := intParam("pageSize", 1, maxPageSize)
+	if err != nil {
+		return params, err
+	}
+	if page > 0 {
+		params.Page = uint32(page)
+	}
+	if pageSize > 0 {
+		params.PageSize = uint32(pageSize)
+	}
+
+	if params.Options, err = searchOptionsFromRequest(req, settings); err != nil {
+		return params, err
+	}
+	if params.Options.Snippets.DescriptionTokens, err = intParam("descriptionTokens", 1, database.MaxSnippetTokens); err != nil {
+		return params, err
+	}
+	if params.Options.Snippets.ContentTokens, err = intParam("contentTokens", 1, database.MaxSnippetTokens); err != nil {
+		return params, err
+	}
+	if params.Options.Snippets.ContentSnippets, err = intParam("snippets", 1, maxContentSnippets); err != nil {
+		return params, err
+	}
+
+	switch req.URL.Query().Get("highlight") {
+	case "", "matches":
+	case "html":
+		params.HighlightTag = defaultHighlightTag
+		if tag := req.URL.Query().Get("highlightTag"); tag != "" {
+			if !highlightTagPattern.MatchString(tag) {
+				return params, fmt.Errorf("invalid value for `highlightTag`: expected an HTML tag name like %q", defaultHighlightTag)
+			}
+			params.HighlightTag = tag
+		}
+		params.HighlightClass = req.URL.Query().Get("highlightClass")
+	default:
+		return params, fmt.Errorf("invalid value for `highlight`: expected %q or %q", "matches", "html")
+	}
+
+	return params, nil
+}
+
+// Returns an error if a vector search would have to fetch too many results to reach the requested page
+func (p searchParams) checkVectorDepth() error {
+	if uint64(p.Page)*uint64(p.PageSize) > maxVectorResults {
+		return fmt.Errorf("invalid value for `page`: only the first %d results can be shown", maxVectorResults)
+	}
+	return nil
+}
+
+// Converts matches into HTML, escaping the text and wrapping highlighted parts in the highlight tag
+func (p searchParams) highlightHTML(matches []database.Match) string {
+	open := "<" + p.HighlightTag + ">"
+	if p.HighlightClass != "" {
+		open = "<" + p.HighlightTag + ` class="` + html.EscapeString(p.HighlightClass) + `">`
+	}
+
+	var str strings.Builder
+	for _, m := range matches {
+		if m.Highlighted {
+			str.WriteString(open + html.EscapeString(m.Content) + "</" + p.HighlightTag + ">")
+		} else {
+			str.WriteString(html.EscapeString(m.Content))
+		}
+	}
+	return str.String()
+}
+
+// Search results whose highlighted fields are HTML strings. The fields replace the embedded result's arrays of matches in JSON.
+type htmlFTSResult struct {
+	database.FTSResult
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+}
+
+type htmlHybridResult struct {
+	database.HybridResult
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+}
+
+// Returns the results as they should appear in an API response: unchanged, or with HTML highlights if the request asked for them
+func (p searchParams) formatResults(results []database.FTSResult) any {
+	if p.HighlightTag == "" {
+		return results
+	}
+	formatted := make([]htmlFTSResult, len(results))
+	for i, res := range results {
+		formatted[i] = htmlFTSResult{FTSResult: res, Title: p.highlightHTML(res.Title), Description: p.highlightHTML(res.Description), Content: p.highlightHTML(res.Content)}
+	}
+	return formatted
+}
+
+// Like formatResults, for hybrid search results
+func (p searchParams) formatHybridResults(results []database.HybridResult) any {
+	if p.HighlightTag == "" {
+		return results
+	}
+	formatted := make([]htmlHybridResult, len(results))
+	for i, res := range results {
+		formatted[i] = htmlHybridResult{HybridResult: res, Title: p.highlightHTML(res.Title), Description: p.highlightHTML(res.Description), Content: p.highlightHTML(res.Content)}
+	}
+	return formatted
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	"github.com/fluxcapacitor2/easysearch/app/query"
+	"github.com/fluxcapacitor2/easysearch/app/rerank"
+	slogctx "github.com/veqryn/slog-context"
+)
+
+// The number of top results that are reranked if the source doesn't specify a number
+const defaultRerankTopN = 20
+
+// How long a search waits for the reranker before returning the results in their original order
+var rerankTimeout = 5 * time.Second
+
+// Returns the reranker of the first source with reranking enabled and the number of results that it reranks, or nil if none of the sources use reranking
+func rerankerFor(cfg *config.Config, sources []string) (rerank.Reranker, int) {
+	for _, id := range sources {
+		for _, src := range cfg.Sources {
+			if src.ID != id || !src.Reranking.Enabled {
+				continue
+			}
+			topN := defaultRerankTopN
+			if src.Reranking.TopN > 0 {
+				topN = src.Reranking.TopN
+			}
+			if src.Reranking.Type == "local" {
+				return rerank.LocalReranker{}, topN
+			}
+			return &rerank.HTTPReranker{
+				URL:    src.Reranking.URL,
+				Model:  src.Reranking.Model,
+				APIKey: src.Reranking.APIKey,
+				Format: src.Reranking.Format,
+				Client: http.DefaultClient,
+			}, topN
+		}
+	}
+	return nil, 0
+}
+
+// Returns the words of a query without its operators and filters, which aren't meaningful to rerankers
+func rerankQuery(q string) string {
+	parsed, err := query.Parse(q)
+	if err != nil {
+		return q
+	}
+	return query.Text(parsed)
+}
+
+// Reranks the first `topN` results that weren't pinned by a curation. If the reranker fails or takes longer than `rerankTimeout`,
+// the results keep their original order.
+func rerankResults[T any](ctx context.Context, reranker rerank.Reranker, q string, results []T, topN int, pinned func(T) bool, text func(T) string, record func(*T, *database.RerankExplanation)) {
+	firstUnpinned := slices.IndexFunc(results, func(r T) bool { return !pinned(r) })
+	if firstUnpinned < 0 {
+		return
+	}
+	rerankCtx, cancel := context.WithTimeout(ctx, rerankTimeout)
+	defer cancel()
+	err := rerank.Apply(rerankCtx, reranker, rerankQuery(q), results[firstUnpinned:], topN, text, func(r *T, originalRank int, score float64) {
+		record(r, &database.RerankExplanation{OriginalRank: firstUnpinned + originalRank, Score: score})
+	})
+	if err != nil {
+		slogctx.Error(ctx, "Failed to rerank search results", "error", err)
+	}
+}
+
+// Runs a full-text search and reranks its top results if any of the sources use reranking
+func searchAndRerank(ctx context.Context, db database.Database, cfg *config.Config, sources []string, q string, options database.SearchOptions, page uint32, pageSize uint32) ([]database.FTSResult, *uint32, error) {
+	reranker, topN := rerankerFor(cfg, sources)
+	if reranker == nil || (page-1)*pageSize >= uint32(topN) {
+		return db.Search(ctx, sources, q, options, page, pageSize)
+	}
+
+	// Reranking can move results between pages, so all the results up to the requested page are fetched and reranked together
+	results, total, err := db.Search(ctx, sources, q, options, 1, max(uint32(topN), page*pageSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	rerankResults(ctx, reranker, q, results, topN,
+		func(r database.FTSResult) bool { return r.Pinned },
+		func(r database.FTSResult) string {
+			return matchText(r.Title) + "\n" + matchText(r.Description) + "\n" + matchText(r.Content)
+		},
+		func(r *database.FTSResult, e *database.RerankExplanation) { r.Rerank = e },
+	)
+
+	start := min(int((page-1)*pageSize), len(results))
+	end := min(int(page*pageSize), len(results))
+	return results[start:end], total, nil
+}
+
+// Runs a hybrid search and reranks its top results if any of the sources use reranking
+func hybridSearchAndRerank(ctx context.Context, db database.Database, cfg *config.Config, sources []string, q string, options database.SearchOptions, embeddedQueries map[string][]float32, page uint32, pageSize uint32) ([]database.HybridResult, *uint32, error) {
+	reranker, topN := rerankerFor(cfg, sources)
+	if reranker == nil || (page-1)*pageSize >= uint32(topN) {
+		return db.HybridSearch(ctx, sources, q, options, embeddedQueries, page, pageSize)
+	}
+
+	results, total, err := db.HybridSearch(ctx, sources, q, options, embeddedQueries, 1, max(uint32(topN), page*pageSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	rerankResults(ctx, reranker, q, results, topN,
+		func(r database.HybridResult) bool { return r.Pinned },
+		func(r database.HybridResult) string {
+			return matchText(r.Title) + "\n" + matchText(r.Description) + "\n" + matchText(r.Content)
+		},
+		func(r *database.HybridResult, e *database.RerankExplanation) { r.Rerank = e },
+	)
+
+	start := min(int((page-1)*pageSize), len(results))
+	end := min(int(page*pageSize), len(results))
+	return results[start:end], total, nil
+}
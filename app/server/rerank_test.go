@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	"github.com/fluxcapacitor2/easysearch/app/spellfix"
+)
+
+func TestSearchAndRerank(t *testing.T) {
+	spellfix.Auto()
+	db, err := database.SQLiteFromFile(path.Join(t.TempDir(), "temp.db"))
+	if err != nil {
+		t.Fatalf("database creation failed: %v", err)
+	}
+	if err := db.Setup(context.Background()); err != nil {
+		t.Fatalf("database setup failed: %v", err)
+	}
+	// The first page is the best full-text match, since the word appears the most times on it
+	for i, content := range []string{"apple apple apple", "apple apple banana", "apple banana cherry"} {
+		_, err := db.AddDocument(context.Background(), "source1", 1, []int64{}, fmt.Sprintf("https://example.com/%v", i+1), database.Finished, fmt.Sprintf("Page %v", i+1), "", content, "", database.PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+	}
+
+	// A reranker that prefers the results in reverse order
+	failing := false
+	slow := make(chan struct{})
+	reranker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing {
+			w.WriteHeader(500)
+			return
+		}
+		if req.URL.Query().Has("slow") {
+			<-slow
+			return
+		}
+		var body struct {
+			Documents []string `json:"documents"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		results := []map[string]any{}
+		for i := range body.Documents {
+			results = append(results, map[string]any{"index": i, "relevance_score": float64(i)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"results": results})
+	}))
+	defer reranker.Close()
+	defer close(slow)
+
+	cfg := &config.Config{Sources: []config.Source{{ID: "source1"}}}
+	cfg.Sources[0].Reranking.Enabled = true
+	cfg.Sources[0].Reranking.URL = reranker.URL
+	cfg.Sources[0].Reranking.Format = "cohere"
+
+	results, total, err := searchAndRerank(context.Background(), db, cfg, []string{"source1"}, "apple", database.SearchOptions{}, 2, 1)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if *total != 3 || len(results) != 1 || results[0].URL != "https://example.com/2" {
+		t.Fatalf("expected the second result on the second page, got %+v (total %v)", results, *total)
+	}
+
+	results, _, err = searchAndRerank(context.Background(), db, cfg, []string{"source1"}, "apple", database.SearchOptions{}, 1, 10)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results) != 3 || results[0].URL != "https://example.com/3" || results[0].Rerank == nil || results[0].Rerank.OriginalRank != 3 {
+		t.Fatalf("expected the results in reverse order, got %+v", results)
+	}
+
+	// If the reranker fails, the results keep their full-text order
+	failing = true
+	results, _, err = searchAndRerank(context.Background(), db, cfg, []string{"source1"}, "apple", database.SearchOptions{}, 1, 10)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results) != 3 || results[0].URL != "https://example.com/1" || results[0].Rerank != nil {
+		t.Fatalf("expected the original order, got %+v", results)
+	}
+
+	// If the reranker doesn't respond in time, the results keep their full-text order too
+	failing = false
+	cfg.Sources[0].Reranking.URL = reranker.URL + "?slow"
+	defer func(timeout time.Duration) { rerankTimeout = timeout }(rerankTimeout)
+	rerankTimeout = 50 * time.Millisecond
+	results, _, err = searchAndRerank(context.Background(), db, cfg, []string{"source1"}, "apple", database.SearchOptions{}, 1, 10)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results) != 3 || results[0].URL != "https://example.com/1" || results[0].Rerank != nil {
+		t.Fatalf("expected the original order after a timeout, got %+v", results)
+	}
+}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"cmp"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -18,8 +20,9 @@ import (
 
 	"github.com/fluxcapacitor2/easysearch/app/config"
 	"github.com/fluxcapacitor2/easysearch/app/database"
-	"github.com/fluxcapacitor2/easysearch/app/embedding"
+	"github.com/fluxcapacitor2/easysearch/app/query"
 	slogctx "github.com/veqryn/slog-context"
+	"golang.org/x/text/language"
 )
 
 //go:embed templates static
@@ -32,6 +35,7 @@ type paginationInfo struct {
 }
 
 func Start(db database.Database, cfg *config.Config) {
+	settings := newSearchSettings(cfg)
 
 	if cfg.ResultsPage.Enabled {
 
@@ -43,7 +47,7 @@ func Start(db database.Database, cfg *config.Config) {
 		}
 
 		http.HandleFunc("/{$}", func(w http.ResponseWriter, req *http.Request) {
-			renderTemplateWithResults(db, cfg, req, w, t, "index")
+			renderTemplateWithResults(db, cfg, settings, req, w, t, "index")
 		})
 
 		http.HandleFunc("/results", func(w http.ResponseWriter, req *http.Request) {
@@ -58,18 +62,35 @@ func Start(db database.Database, cfg *config.Config) {
 				w.Header().Set("HX-Replace-URL", url.String())
 			}
 
-			renderTemplateWithResults(db, cfg, req, w, t, "results")
+			renderTemplateWithResults(db, cfg, settings, req, w, t, "results")
+		})
+
+		http.HandleFunc("/suggestions", func(w http.ResponseWriter, req *http.Request) {
+			// This endpoint returns suggestions as HTML to be shown below the search box on the index page (/).
+			suggestions, err := db.Suggest(req.Context(), req.URL.Query()["source"], req.URL.Query().Get("q"), defaultSuggestionLimit)
+			if err != nil {
+				slogctx.Error(req.Context(), "Error fetching suggestions while serving suggestions template", "error", err)
+				w.WriteHeader(500)
+				w.Write([]byte("Internal server error"))
+				return
+			}
+
+			w.Header().Add("Content-Type", "text/html")
+			err = t.ExecuteTemplate(w, "suggestions", &suggestionParams{Suggestions: suggestions})
+			if err != nil {
+				w.Write([]byte(fmt.Sprintf("Internal server error: %v\n", err)))
+			}
 		})
 	}
 
-	http.HandleFunc("/api/search", func(w http.ResponseWriter, req *http.Request) {
+	http.HandleFunc("/api/similarity-search", func(w http.ResponseWriter, req *http.Request) {
 		type httpResponse struct {
 			status       int16
-			Success      bool                 `json:"success"`
-			Error        string               `json:"error,omitempty"`
-			Results      []database.FTSResult `json:"results"`
-			Pagination   paginationInfo       `json:"pagination"`
-			ResponseTime float64              `json:"responseTime"`
+			Success      bool                        `json:"success"`
+			Error        string                      `json:"error,omitempty"`
+			Results      []database.SimilarityResult `json:"results"`
+			Pagination   paginationInfo              `json:"pagination"`
+			ResponseTime float64                     `json:"responseTime"`
 		}
 
 		timeStart := time.Now().UnixMicro()
@@ -88,9 +109,8 @@ func Start(db database.Database, cfg *config.Config) {
 
 		src := req.URL.Query()["source"]
 		q := req.URL.Query().Get("q")
-		page, err := strconv.ParseUint(req.URL.Query().Get("page"), 10, 32)
 
-		if q == "" || src == nil || len(src) == 0 || err != nil {
+		if q == "" || src == nil || len(src) == 0 {
 			respond(httpResponse{
 				status:  400,
 				Success: false,
@@ -99,42 +119,167 @@ func Start(db database.Database, cfg *config.Config) {
 			return
 		}
 
-		spellchecked, err := db.Spellfix(req.Context(), q)
+		params, err := searchParamsFromRequest(req, cfg, settings)
+		if err == nil {
+			err = params.checkVectorDepth()
+		}
 		if err != nil {
-			slogctx.Error(req.Context(), "Failed to spellcheck query", "error", err)
-			spellchecked = q
+			respond(httpResponse{
+				status:  400,
+				Success: false,
+				Error:   "Bad request: " + err.Error(),
+			})
+			return
 		}
+		page, pageSize := params.Page, params.PageSize
+
+		foundSources := make([]config.Source, 0, len(src))
 
-		results, total, err := db.Search(req.Context(), src, spellchecked, uint32(page), 10)
+		for _, sourceID := range src {
+			for _, s := range cfg.Sources {
+				if s.ID == sourceID {
+					foundSources = append(foundSources, s)
+					break
+				}
+			}
+		}
+
+		queryEmbeds, err := embedQuery(req.Context(), foundSources, q)
 		if err != nil {
+			slogctx.Error(req.Context(), "Failed to generate embeddings for query", "error", err)
+
 			respond(httpResponse{
 				status:  500,
 				Success: false,
 				Error:   "Internal server error",
 			})
+			return
+		}
 
-			slogctx.Error(req.Context(), "Failed to generate search results", "error", err)
+		perSource := maxVectorResults
+		if p := req.URL.Query().Get("perSource"); p != "" {
+			if perSource, err = strconv.Atoi(p); err != nil || perSource < 1 || perSource > maxVectorResults {
+				respond(httpResponse{
+					status:  400,
+					Success: false,
+					Error:   fmt.Sprintf("Bad request: invalid value for `perSource`: expected an integer between 1 and %d", maxVectorResults),
+				})
+				return
+			}
+		}
+
+		// Results are grouped by embedding model, since only similarities from the same model can be compared
+		byModel := make(map[string][]database.SimilarityResult)
+		rrfConstants := make(map[string]float64)
+		total := uint32(0)
+
+		for _, s := range foundSources {
+			if !s.Embeddings.Enabled {
+				continue
+			}
+			// Each source returns enough results to fill every page up to the requested one, in case the other sources have no closer results
+			results, sourceTotal, err := db.SimilaritySearch(req.Context(), s.ID, queryEmbeds[s.ID], min(perSource, int(page*pageSize)))
+			if err != nil {
+				slogctx.Error(req.Context(), "Failed to generate search results", "error", err)
+
+				respond(httpResponse{
+					status:  500,
+					Success: false,
+					Error:   "Internal server error",
+				})
+				return
+			}
+			byModel[s.Embeddings.Model] = append(byModel[s.Embeddings.Model], results...)
+			if _, ok := rrfConstants[s.Embeddings.Model]; !ok {
+				rrfConstants[s.Embeddings.Model] = settings.ranking[s.ID].RRFConstant
+			}
+			// Each source contributes at most `perSource` pages, so the total is the sum of each source's estimated number of matching pages, up to that limit
+			total += min(*sourceTotal, uint32(perSource))
+		}
+
+		allResults := mergeSimilarityResults(byModel, rrfConstants)
+
+		start := min(int((page-1)*pageSize), len(allResults))
+		end := min(int(page*pageSize), len(allResults))
+
+		respond(httpResponse{
+			status:  200,
+			Success: true,
+			Results: allResults[start:end],
+			Pagination: paginationInfo{
+				Page:     page,
+				PageSize: pageSize,
+				Total:    total,
+			},
+		})
+	})
+
+	http.HandleFunc("/api/suggest", func(w http.ResponseWriter, req *http.Request) {
+		type httpResponse struct {
+			status       int16
+			Success      bool                  `json:"success"`
+			Error        string                `json:"error,omitempty"`
+			Results      []database.Suggestion `json:"results"`
+			ResponseTime float64               `json:"responseTime"`
+		}
+
+		timeStart := time.Now().UnixMicro()
+
+		respond := func(response httpResponse) {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(int(response.status))
+			response.ResponseTime = float64(time.Now().UnixMicro()-timeStart) / 1e6
+			str, err := json.Marshal(response)
+			if err != nil {
+				w.Write([]byte(`{"success":"false","error":"Failed to marshal struct into JSON"}`))
+			} else {
+				w.Write([]byte(str))
+			}
+		}
+
+		src := req.URL.Query()["source"]
+		q := req.URL.Query().Get("q")
+		limit := defaultSuggestionLimit
+		var err error
+		if l := req.URL.Query().Get("limit"); l != "" {
+			limit, err = strconv.Atoi(l)
+		}
+
+		if q == "" || src == nil || len(src) == 0 || err != nil || limit < 1 || limit > maxSuggestionLimit {
+			respond(httpResponse{
+				status:  400,
+				Success: false,
+				Error:   "Bad request",
+			})
 			return
-		} else {
+		}
+
+		results, err := db.Suggest(req.Context(), src, q, limit)
+		if err != nil {
+			slogctx.Error(req.Context(), "Failed to generate suggestions", "error", err)
+
 			respond(httpResponse{
-				status:  200,
-				Success: true,
-				Results: results,
-				Pagination: paginationInfo{
-					Page:     uint32(page),
-					PageSize: 10,
-					Total:    *total,
-				},
+				status:  500,
+				Success: false,
+				Error:   "Internal server error",
 			})
 			return
 		}
+
+		respond(httpResponse{
+			status:  200,
+			Success: true,
+			Results: results,
+		})
 	})
 
-	http.HandleFunc("/api/similarity-search", func(w http.ResponseWriter, req *http.Request) {
+	http.HandleFunc("/api/similar", func(w http.ResponseWriter, req *http.Request) {
 		type httpResponse struct {
-			status       int16
-			Success      bool                        `json:"success"`
-			Error        string                      `json:"error,omitempty"`
+			status  int16
+			Success bool   `json:"success"`
+			Error   string `json:"error,omitempty"`
+			// How the results were found: "embeddings", or "fts" if the source doesn't have embeddings for the page
+			Method       string                      `json:"method,omitempty"`
 			Results      []database.SimilarityResult `json:"results"`
 			ResponseTime float64                     `json:"responseTime"`
 		}
@@ -153,10 +298,24 @@ func Start(db database.Database, cfg *config.Config) {
 			}
 		}
 
-		src := req.URL.Query()["source"]
-		q := req.URL.Query().Get("q")
+		sourceID := req.URL.Query().Get("source")
+		pageURL := req.URL.Query().Get("url")
+		aggregation := database.ChunkAggregation(cmp.Or(req.URL.Query().Get("aggregate"), string(database.AggregateMean)))
+		limit := defaultSimilarLimit
+		var err error
+		if l := req.URL.Query().Get("limit"); l != "" {
+			limit, err = strconv.Atoi(l)
+		}
 
-		if q == "" || src == nil || len(src) == 0 {
+		var source *config.Source
+		for _, s := range cfg.Sources {
+			if s.ID == sourceID {
+				source = &s
+				break
+			}
+		}
+
+		if source == nil || pageURL == "" || err != nil || limit < 1 || limit > maxSimilarLimit || (aggregation != database.AggregateMean && aggregation != database.AggregateBest) {
 			respond(httpResponse{
 				status:  400,
 				Success: false,
@@ -165,71 +324,185 @@ func Start(db database.Database, cfg *config.Config) {
 			return
 		}
 
-		foundSources := make([]config.Source, 0, len(src))
+		method := "embeddings"
+		var results []database.SimilarityResult
+		if source.Embeddings.Enabled {
+			results, err = db.SimilarPages(req.Context(), source.ID, pageURL, aggregation, limit)
+		}
+		// Pages that haven't been embedded yet can still be compared by their text
+		if !source.Embeddings.Enabled || errors.Is(err, database.ErrNoEmbeddings) {
+			method = "fts"
+			results, err = db.RelatedPages(req.Context(), source.ID, pageURL, limit)
+		}
 
-		for _, sourceID := range src {
-			for _, s := range cfg.Sources {
-				if s.ID == sourceID {
-					foundSources = append(foundSources, s)
-					break
-				}
-			}
+		if errors.Is(err, database.ErrPageNotFound) {
+			respond(httpResponse{
+				status:  404,
+				Success: false,
+				Error:   "Page not found",
+			})
+			return
+		} else if err != nil {
+			slogctx.Error(req.Context(), "Failed to find similar pages", "error", err)
+
+			respond(httpResponse{
+				status:  500,
+				Success: false,
+				Error:   "Internal server error",
+			})
+			return
 		}
 
-		queryEmbeds := make(map[string][]float32)
+		respond(httpResponse{
+			status:  200,
+			Success: true,
+			Method:  method,
+			Results: results,
+		})
+	})
 
-		for _, s := range foundSources {
-			if s.Embeddings.Enabled && queryEmbeds[s.Embeddings.Model] == nil {
-				vector, err := embedding.GetEmbeddings(req.Context(), s.Embeddings.OpenAIBaseURL, s.Embeddings.Model, s.Embeddings.APIKey, []string{q})
-				if err != nil {
-					slogctx.Error(req.Context(), "Failed to generate embeddings for query", "error", err)
-
-					respond(httpResponse{
-						status:  500,
-						Success: false,
-						Error:   "Internal server error",
-					})
-					return
-				}
-				queryEmbeds[s.Embeddings.Model] = vector[0]
+	registerSearchRoutes(http.DefaultServeMux, db, cfg, settings)
+	registerAnswerRoutes(http.DefaultServeMux, db, cfg, settings)
+	registerAdminRoutes(http.DefaultServeMux, db, cfg)
+
+	addr := fmt.Sprintf("%v:%v", cfg.HTTP.Listen, cfg.HTTP.Port)
+	slog.Info("HTTP server is listening", "address", "http://"+addr)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// Registers the Search Results API (`/api/search`) and hybrid search (`/api/hybrid-search`)
+func registerSearchRoutes(mux *http.ServeMux, db database.Database, cfg *config.Config, settings *searchSettings) {
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, req *http.Request) {
+		type httpResponse struct {
+			status        int16
+			Success       bool   `json:"success"`
+			Error         string `json:"error,omitempty"`
+			ErrorPosition *int   `json:"errorPosition,omitempty"`
+			Results       any    `json:"results"`
+			// The query that the user searched for
+			OriginalQuery string `json:"originalQuery,omitempty"`
+			// If set, the original query had few results, so the results are for this spelling-corrected query instead
+			CorrectedQuery string           `json:"correctedQuery,omitempty"`
+			Facets         *database.Facets `json:"facets,omitempty"`
+			Pagination     paginationInfo   `json:"pagination"`
+			ResponseTime   float64          `json:"responseTime"`
+		}
+
+		timeStart := time.Now().UnixMicro()
+
+		respond := func(response httpResponse) {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(int(response.status))
+			response.ResponseTime = float64(time.Now().UnixMicro()-timeStart) / 1e6
+			str, err := json.Marshal(response)
+			if err != nil {
+				w.Write([]byte(`{"success":"false","error":"Failed to marshal struct into JSON"}`))
+			} else {
+				w.Write([]byte(str))
 			}
 		}
 
-		allResults := make([]database.SimilarityResult, 0)
+		src := req.URL.Query()["source"]
+		q := req.URL.Query().Get("q")
 
-		for _, s := range foundSources {
-			results, err := db.SimilaritySearch(req.Context(), s.ID, queryEmbeds[s.Embeddings.Model], 10)
+		if q == "" || src == nil || len(src) == 0 {
+			respond(httpResponse{
+				status:  400,
+				Success: false,
+				Error:   "Bad request",
+			})
+			return
+		}
+
+		params, err := searchParamsFromRequest(req, cfg, settings)
+		if err != nil {
+			respond(httpResponse{
+				status:  400,
+				Success: false,
+				Error:   "Bad request: " + err.Error(),
+			})
+			return
+		}
+		options := params.Options
+
+		results, total, err := searchAndRerank(req.Context(), db, cfg, src, q, options, params.Page, params.PageSize)
+		var parseErr *query.ParseError
+		if errors.As(err, &parseErr) {
+			respond(httpResponse{
+				status:        400,
+				Success:       false,
+				Error:         "Invalid query: " + parseErr.Error(),
+				ErrorPosition: &parseErr.Position,
+			})
+			return
+		} else if err != nil {
+			respond(httpResponse{
+				status:  500,
+				Success: false,
+				Error:   "Internal server error",
+			})
+
+			slogctx.Error(req.Context(), "Failed to generate search results", "error", err)
+			return
+		}
+
+		// If there are few results, check if a spelling-corrected query would return more
+		correctedQuery := ""
+		if corrected := suggestCorrection(req.Context(), db, src, q, int(*total), spellcheckEnabled(req)); corrected != "" {
+			correctedResults, correctedTotal, err := searchAndRerank(req.Context(), db, cfg, src, corrected, options, params.Page, params.PageSize)
 			if err != nil {
-				slogctx.Error(req.Context(), "Failed to generate search results", "error", err)
+				slogctx.Error(req.Context(), "Failed to generate search results for spelling-corrected query", "error", err)
+			} else if *correctedTotal > *total {
+				results, total, correctedQuery = correctedResults, correctedTotal, corrected
+			}
+		}
 
+		// Counting facets runs another query over all of the results, so it's only done when the client asks for them
+		var facets *database.Facets
+		if req.URL.Query().Get("facets") == "true" {
+			facets, err = db.Facets(req.Context(), src, cmp.Or(correctedQuery, q), options)
+			if err != nil {
 				respond(httpResponse{
 					status:  500,
 					Success: false,
 					Error:   "Internal server error",
 				})
+
+				slogctx.Error(req.Context(), "Failed to count facets", "error", err)
 				return
 			}
-			allResults = append(allResults, results...)
 		}
 
-		slices.SortFunc(allResults, func(a database.SimilarityResult, b database.SimilarityResult) int {
-			return cmp.Compare(a.Similarity, b.Similarity)
-		})
+		if params.Page <= 1 && *total > 0 {
+			recordQuery(req.Context(), db, src, cmp.Or(correctedQuery, q))
+		}
 
 		respond(httpResponse{
-			status:  200,
-			Success: true,
-			Results: allResults,
+			status:         200,
+			Success:        true,
+			Results:        params.formatResults(results),
+			OriginalQuery:  q,
+			CorrectedQuery: correctedQuery,
+			Facets:         facets,
+			Pagination: paginationInfo{
+				Page:     params.Page,
+				PageSize: params.PageSize,
+				Total:    *total,
+			},
 		})
 	})
 
-	http.HandleFunc("/api/hybrid-search", func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc("/api/hybrid-search", func(w http.ResponseWriter, req *http.Request) {
 		type httpResponse struct {
-			status       int16
-			Success      bool                    `json:"success"`
-			Error        string                  `json:"error,omitempty"`
-			Results      []database.HybridResult `json:"results"`
-			ResponseTime float64                 `json:"responseTime"`
+			status         int16
+			Success        bool           `json:"success"`
+			Error          string         `json:"error,omitempty"`
+			ErrorPosition  *int           `json:"errorPosition,omitempty"`
+			Results        any            `json:"results"`
+			OriginalQuery  string         `json:"originalQuery,omitempty"`
+			CorrectedQuery string         `json:"correctedQuery,omitempty"`
+			Pagination     paginationInfo `json:"pagination"`
+			ResponseTime   float64        `json:"responseTime"`
 		}
 
 		timeStart := time.Now().UnixMicro()
@@ -258,6 +531,35 @@ func Start(db database.Database, cfg *config.Config) {
 			return
 		}
 
+		params, err := searchParamsFromRequest(req, cfg, settings)
+		if err == nil {
+			err = params.checkVectorDepth()
+		}
+		if err != nil {
+			respond(httpResponse{
+				status:  400,
+				Success: false,
+				Error:   "Bad request: " + err.Error(),
+			})
+			return
+		}
+		page, pageSize := params.Page, params.PageSize
+
+		// Parse the query before generating embeddings so that invalid queries don't result in unnecessary API calls
+		parsed, err := query.Parse(q)
+		var parseErr *query.ParseError
+		if errors.As(err, &parseErr) {
+			respond(httpResponse{
+				status:        400,
+				Success:       false,
+				Error:         "Invalid query: " + parseErr.Error(),
+				ErrorPosition: &parseErr.Position,
+			})
+			return
+		}
+
+		options := params.Options
+
 		foundSources := make([]config.Source, 0, len(src))
 
 		for _, sourceID := range src {
@@ -269,31 +571,17 @@ func Start(db database.Database, cfg *config.Config) {
 			}
 		}
 
-		queryEmbeds := make(map[string][]float32)
-
-		for _, s := range foundSources {
-			if s.Embeddings.Enabled && queryEmbeds[s.Embeddings.Model] == nil {
-				vector, err := embedding.GetEmbeddings(req.Context(), s.Embeddings.OpenAIBaseURL, s.Embeddings.Model, s.Embeddings.APIKey, []string{q})
-				if err != nil {
-					slogctx.Error(req.Context(), "Failed to generate embeddings for search query", "error", err)
-
-					respond(httpResponse{
-						status:  500,
-						Success: false,
-						Error:   "Internal server error",
-					})
-					return
-				}
-				queryEmbeds[s.Embeddings.Model] = vector[0]
-			}
-		}
-
-		embeddedQueries := make(map[string][]float32)
+		// Operators and filters aren't meaningful to the embedding model, so only the search terms are embedded
+		embeddedQueries, err := embedQuery(req.Context(), foundSources, query.Text(parsed))
+		if err != nil {
+			slogctx.Error(req.Context(), "Failed to generate embeddings for search query", "error", err)
 
-		for _, s := range foundSources {
-			if s.Embeddings.Enabled {
-				embeddedQueries[s.ID] = queryEmbeds[s.Embeddings.Model]
-			}
+			respond(httpResponse{
+				status:  500,
+				Success: false,
+				Error:   "Internal server error",
+			})
+			return
 		}
 
 		sourceList := make([]string, 0)
@@ -310,13 +598,7 @@ func Start(db database.Database, cfg *config.Config) {
 			return
 		}
 
-		spellchecked, err := db.Spellfix(req.Context(), q)
-		if err != nil {
-			slogctx.Error(req.Context(), "Failed to spellcheck query", "error", err)
-			spellchecked = q
-		}
-
-		results, err := db.HybridSearch(req.Context(), sourceList, spellchecked, embeddedQueries, 10)
+		results, total, err := hybridSearchAndRerank(req.Context(), db, cfg, sourceList, q, options, embeddedQueries, page, pageSize)
 		if err != nil {
 			slogctx.Error(req.Context(), "Failed to generate search results", "error", err)
 
@@ -328,16 +610,36 @@ func Start(db database.Database, cfg *config.Config) {
 			return
 		}
 
+		// If the query's keywords match few pages, check if a spelling-corrected query would match more.
+		// The vector search uses the embedding of the original query, since embedding models are generally tolerant of typos.
+		correctedQuery := ""
+		if corrected := suggestHybridCorrection(req.Context(), db, sourceList, q, options, spellcheckEnabled(req)); corrected != "" {
+			correctedResults, correctedTotal, err := hybridSearchAndRerank(req.Context(), db, cfg, sourceList, corrected, options, embeddedQueries, page, pageSize)
+			if err != nil {
+				slogctx.Error(req.Context(), "Failed to generate search results for spelling-corrected query", "error", err)
+			} else {
+				results, total, correctedQuery = correctedResults, correctedTotal, corrected
+			}
+		}
+
+		// Vector search returns results for any query, so only record queries whose keywords matched something
+		if slices.ContainsFunc(results, func(r database.HybridResult) bool { return r.FTSRank != nil }) {
+			recordQuery(req.Context(), db, sourceList, cmp.Or(correctedQuery, q))
+		}
+
 		respond(httpResponse{
-			status:  200,
-			Success: true,
-			Results: results,
+			status:         200,
+			Success:        true,
+			Results:        params.formatHybridResults(results),
+			OriginalQuery:  q,
+			CorrectedQuery: correctedQuery,
+			Pagination: paginationInfo{
+				Page:     page,
+				PageSize: pageSize,
+				Total:    *total,
+			},
 		})
 	})
-
-	addr := fmt.Sprintf("%v:%v", cfg.HTTP.Listen, cfg.HTTP.Port)
-	slog.Info("HTTP server is listening", "address", "http://"+addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
 }
 
 type pageParams struct {
@@ -345,17 +647,36 @@ type pageParams struct {
 
 	Query   string
 	Sources []togglableSource
+	// A message describing why the query couldn't be parsed, if applicable
+	QueryError string
+	// If set, the results are for this spelling-corrected query instead of the original query
+	CorrectedQuery string
+	// A link to search for the original query without spelling correction
+	OriginalQueryURL string
+	// Whether any of the sources have embeddings, which hybrid search needs, and whether the results are from a hybrid search
+	HybridAvailable bool
+	Hybrid          bool
 
 	Results []searchResult
 	Time    float64
 
-	Total uint32
-	Pages []ResultsPage
+	Total  uint32
+	Pages  []ResultsPage
+	Facets []facetGroup
+}
+
+type suggestionParams struct {
+	Suggestions []database.Suggestion
 }
 
 type searchResult struct {
 	database.FTSResult
 	Breadcrumbs []breadcrumb
+	// When the page was published (or last modified, if the publish date is unknown), formatted for display
+	Date string
+	// A description of the results that were collapsed into this one, like "3 more from example.com", and a link that shows them
+	CollapsedText string
+	CollapsedURL  string
 }
 
 type breadcrumb struct {
@@ -368,37 +689,90 @@ type togglableSource struct {
 	Enabled bool
 }
 
-func renderTemplateWithResults(db database.Database, config *config.Config, req *http.Request, w http.ResponseWriter, t *template.Template, templateName string) {
+func renderTemplateWithResults(db database.Database, config *config.Config, settings *searchSettings, req *http.Request, w http.ResponseWriter, t *template.Template, templateName string) {
 	src := req.URL.Query()["source"]
 	q := req.URL.Query().Get("q")
-	page, err := strconv.ParseUint(req.URL.Query().Get("page"), 10, 32)
 
 	var results []database.FTSResult
 	var total *uint32
 	var totalTime int64
+	var queryError string
+	var correctedQuery string
+	var facets *database.Facets
+	params, optionsErr := searchParamsFromRequest(req, config, settings)
+	options, page := params.Options, params.Page
+
+	// `mode=hybrid` also finds pages whose meaning is close to the query, if any of the selected sources have embeddings
+	hybrid := req.URL.Query().Get("mode") == "hybrid" && embeddingsEnabled(config, src)
+	search := searchAndRerank
+	if hybrid {
+		search = hybridSearchForResultsPage
+	}
 
-	if len(src) > 0 && len(q) > 0 && err == nil {
+	if len(src) > 0 && len(q) > 0 {
 		var err error
 		start := time.Now().UnixMicro()
 
-		spellchecked, err := db.Spellfix(req.Context(), q)
-		if err != nil {
-			slogctx.Error(req.Context(), "Error correcting query spelling", "error", err)
-			w.WriteHeader(500)
-			w.Write([]byte("Internal server error"))
-			return
+		if optionsErr == nil {
+			results, total, err = search(req.Context(), db, config, src, q, options, page, params.PageSize)
 		}
-		results, total, err = db.Search(req.Context(), src, spellchecked, uint32(page), 10)
-		end := time.Now().UnixMicro()
-
-		totalTime = end - start
 
-		if err != nil || total == nil {
+		var parseErr *query.ParseError
+		if optionsErr != nil {
+			// Show the invalid filter in place of the results
+			queryError = "Invalid search options: " + optionsErr.Error()
+			results = make([]database.FTSResult, 0)
+			t := uint32(0)
+			total = &t
+		} else if errors.As(err, &parseErr) {
+			// Show the syntax error in place of the results
+			queryError = "Invalid query: " + parseErr.Error()
+			results = make([]database.FTSResult, 0)
+			t := uint32(0)
+			total = &t
+		} else if err != nil || total == nil {
 			slogctx.Error(req.Context(), "Error fetching results while serving results template", "error", err)
 			w.WriteHeader(500)
 			w.Write([]byte("Internal server error"))
 			return
+		} else if hybrid {
+			if corrected := suggestHybridCorrection(req.Context(), db, src, q, options, spellcheckEnabled(req)); corrected != "" {
+				correctedResults, correctedTotal, err := search(req.Context(), db, config, src, corrected, options, page, params.PageSize)
+				if err != nil {
+					slogctx.Error(req.Context(), "Error fetching results for spelling-corrected query while serving results template", "error", err)
+				} else {
+					results, total, correctedQuery = correctedResults, correctedTotal, corrected
+				}
+			}
+		} else if corrected := suggestCorrection(req.Context(), db, src, q, int(*total), spellcheckEnabled(req)); corrected != "" {
+			correctedResults, correctedTotal, err := search(req.Context(), db, config, src, corrected, options, page, params.PageSize)
+			if err != nil {
+				slogctx.Error(req.Context(), "Error fetching results for spelling-corrected query while serving results template", "error", err)
+			} else if *correctedTotal > *total {
+				results, total, correctedQuery = correctedResults, correctedTotal, corrected
+			}
+		}
+
+		// The results page searches as the user types, so partially-typed queries are only recorded when the user submits the form
+		// (which the page signals with a header) or loads the page directly.
+		submitted := req.Header.Get("HX-Request") == "" || req.Header.Get("Easysearch-Submitted") == "true"
+
+		// Counting facets runs another query over all of the results, so they're skipped while the user is typing.
+		// They're shown once the form is submitted, or when the user follows a link (like a facet or page number).
+		if queryError == "" && (submitted || req.Header.Get("HX-Boosted") == "true") {
+			facets, err = db.Facets(req.Context(), src, cmp.Or(correctedQuery, q), options)
+			if err != nil {
+				slogctx.Error(req.Context(), "Error counting facets while serving results template", "error", err)
+				w.WriteHeader(500)
+				w.Write([]byte("Internal server error"))
+				return
+			}
+		}
+		if submitted && page <= 1 && *total > 0 {
+			recordQuery(req.Context(), db, src, cmp.Or(correctedQuery, q))
 		}
+
+		totalTime = time.Now().UnixMicro() - start
 	} else {
 		results = make([]database.FTSResult, 0)
 		t := uint32(0)
@@ -415,12 +789,16 @@ func renderTemplateWithResults(db database.Database, config *config.Config, req
 		})
 	}
 
-	ceil := math.Ceil(float64(*total) / 10.0)
+	ceil := math.Ceil(float64(*total) / float64(params.PageSize))
 	pageCount := int32(math.Min(ceil, math.MaxInt32))
 	if pageCount < 1 {
 		pageCount = 1
 	}
-	pages := CreatePagination(req.URL, int32(page), 10, pageCount)
+	pages := CreatePagination(req.URL, int32(page), int32(params.PageSize), pageCount)
+
+	// Copy the URL so that adding the parameter doesn't affect the request's URL
+	originalQueryURL := *req.URL
+	urlWithParam(&originalQueryURL, "spellcheck", "false")
 
 	mappedResults := make([]searchResult, len(results))
 	for i, res := range results {
@@ -441,21 +819,340 @@ func renderTemplateWithResults(db database.Database, config *config.Config, req
 		mappedResults[i] = searchResult{
 			FTSResult:   res,
 			Breadcrumbs: breadcrumbs,
+			Date:        formatDate(cmp.Or(res.PublishedAt, res.ModifiedAt)),
+		}
+		if res.Collapsed > 0 && err == nil {
+			mappedResults[i].CollapsedText, mappedResults[i].CollapsedURL = expandCollapsedURL(req.URL, options.Collapse, res, url)
 		}
 	}
 
 	w.Header().Add("Content-Type", "text/html")
-	err = t.ExecuteTemplate(w, templateName, &pageParams{
-		Query:      q,
-		Sources:    sources,
-		Results:    mappedResults,
-		Total:      *total,
-		Time:       float64(totalTime) / 1e6,
-		Pages:      pages,
-		CustomHTML: template.HTML(config.ResultsPage.CustomHTML),
+	err := t.ExecuteTemplate(w, templateName, &pageParams{
+		Query:            q,
+		HybridAvailable:  embeddingsEnabled(config, nil),
+		Hybrid:           hybrid,
+		QueryError:       queryError,
+		CorrectedQuery:   correctedQuery,
+		OriginalQueryURL: originalQueryURL.String(),
+		Sources:          sources,
+		Results:          mappedResults,
+		Total:            *total,
+		Time:             float64(totalTime) / 1e6,
+		Pages:            pages,
+		Facets:           createFacetGroups(req.URL, src, options, facets),
+		CustomHTML:       template.HTML(config.ResultsPage.CustomHTML),
 	})
 
 	if err != nil {
 		w.Write([]byte(fmt.Sprintf("Internal server error: %v\n", err)))
 	}
 }
+
+// Returns whether any of the sources have embeddings enabled. If `sources` is nil, all sources in the config are checked.
+func embeddingsEnabled(cfg *config.Config, sources []string) bool {
+	return slices.ContainsFunc(cfg.Sources, func(s config.Source) bool {
+		return s.Embeddings.Enabled && (sources == nil || slices.Contains(sources, s.ID))
+	})
+}
+
+// Runs a hybrid search for the results page, which shows the results in the same format as full-text search results
+func hybridSearchForResultsPage(ctx context.Context, db database.Database, cfg *config.Config, sources []string, q string, options database.SearchOptions, page uint32, pageSize uint32) ([]database.FTSResult, *uint32, error) {
+	parsed, err := query.Parse(q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	foundSources := make([]config.Source, 0, len(sources))
+	for _, s := range cfg.Sources {
+		if slices.Contains(sources, s.ID) {
+			foundSources = append(foundSources, s)
+		}
+	}
+	embeddedQueries, err := embedQuery(ctx, foundSources, query.Text(parsed))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating embeddings for search query: %v", err)
+	}
+
+	results, total, err := hybridSearchAndRerank(ctx, db, cfg, sources, q, options, embeddedQueries, page, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapped := make([]database.FTSResult, len(results))
+	for i, res := range results {
+		mapped[i] = database.FTSResult{
+			URL:         res.URL,
+			Title:       res.Title,
+			Description: res.Description,
+			Content:     res.Content,
+			Rank:        res.HybridRank,
+			Language:    res.Language,
+			Pinned:      res.Pinned,
+			Rerank:      res.Rerank,
+		}
+	}
+	return mapped, total, nil
+}
+
+const (
+	// The number of suggestions returned if the request doesn't specify a limit
+	defaultSuggestionLimit = 8
+	maxSuggestionLimit     = 20
+
+	// The number of similar pages returned if the request doesn't specify a limit
+	defaultSimilarLimit = 5
+	maxSimilarLimit     = 50
+)
+
+// Returns a description of the results that were collapsed into `res` and a link to a search that shows them
+func expandCollapsedURL(u *url.URL, collapse database.CollapseMode, res database.FTSResult, resultURL *url.URL) (string, string) {
+	copied := *u
+	q := copied.Query()
+	q.Del("collapse")
+	q.Set("page", "1")
+
+	var text string
+	switch collapse {
+	case database.CollapseHost:
+		host := strings.ToLower(resultURL.Hostname())
+		q.Set("host", host)
+		text = fmt.Sprintf("%d more from %s", res.Collapsed, host)
+	case database.CollapseDuplicates:
+		q.Set("duplicatesOf", res.URL)
+		text = fmt.Sprintf("%d similar %s", res.Collapsed, pluralize(res.Collapsed, "page", "pages"))
+	default:
+		return "", ""
+	}
+
+	copied.RawQuery = q.Encode()
+	return text, copied.String()
+}
+
+func pluralize(count uint32, singular string, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
+
+// Reads the optional `host`, `section`, `kind`, `lang`, `after`, `before`, `sort`, `collapse`, and `duplicatesOf` URL parameters, which narrow down, order, and group search results,
+// and the languages preferred in the `Accept-Language` header. Each source's ranking settings and synonyms come from `settings`.
+func searchOptionsFromRequest(req *http.Request, settings *searchSettings) (database.SearchOptions, error) {
+	options := database.SearchOptions{
+		Host:         strings.ToLower(req.URL.Query().Get("host")),
+		Section:      req.URL.Query().Get("section"),
+		Kind:         req.URL.Query().Get("kind"),
+		DuplicatesOf: req.URL.Query().Get("duplicatesOf"),
+		// These maps are shared by every request, so they must not be modified
+		Ranking:  settings.ranking,
+		Synonyms: settings.synonyms,
+	}
+
+	options.Explain = req.URL.Query().Get("explain") == "true"
+
+	if lang := req.URL.Query().Get("lang"); lang != "" {
+		options.Language = database.NormalizeLanguage(lang)
+		if options.Language == "" {
+			return options, fmt.Errorf("invalid value for `lang`: %q is not a language tag", lang)
+		}
+	}
+	options.PreferredLanguages = preferredLanguages(req.Header.Get("Accept-Language"))
+
+	var err error
+	if options.After, err = parseDateParam(req.URL.Query().Get("after")); err != nil {
+		return options, fmt.Errorf("invalid value for `after`: %v", err)
+	}
+	if options.Before, err = parseDateParam(req.URL.Query().Get("before")); err != nil {
+		return options, fmt.Errorf("invalid value for `before`: %v", err)
+	}
+
+	switch sort := database.SortOrder(req.URL.Query().Get("sort")); sort {
+	case "", database.SortRelevance, database.SortDate:
+		options.Sort = sort
+	default:
+		return options, fmt.Errorf("invalid value for `sort`: expected %q or %q", database.SortRelevance, database.SortDate)
+	}
+
+	switch collapse := database.CollapseMode(req.URL.Query().Get("collapse")); collapse {
+	case "", database.CollapseNone, database.CollapseHost, database.CollapseDuplicates:
+		options.Collapse = collapse
+	default:
+		return options, fmt.Errorf("invalid value for `collapse`: expected %q, %q, or %q", database.CollapseNone, database.CollapseHost, database.CollapseDuplicates)
+	}
+
+	return options, nil
+}
+
+// Parses an `Accept-Language` header into the languages that the user prefers, ignoring invalid headers and wildcards
+func preferredLanguages(header string) []database.LanguagePreference {
+	if header == "" {
+		return nil
+	}
+	tags, weights, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		return nil
+	}
+
+	preferences := make([]database.LanguagePreference, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for i, tag := range tags {
+		lang := database.NormalizeLanguage(tag.String())
+		// The wildcard "*" is parsed as "mul" (multiple languages)
+		if _, ok := seen[lang]; ok || lang == "" || lang == "mul" || weights[i] <= 0 {
+			continue
+		}
+		seen[lang] = struct{}{}
+		// Quality values have at most three decimal places, so this removes the imprecision of the float32 weights
+		weight := math.Round(float64(weights[i])*1000) / 1000
+		preferences = append(preferences, database.LanguagePreference{Language: lang, Weight: weight})
+	}
+	return preferences
+}
+
+// Each source's ranking settings and synonyms in the format used by the database.
+// They're built from the config once when the server starts instead of for every search.
+type searchSettings struct {
+	ranking  map[string]database.Ranking
+	synonyms map[string]*query.Synonyms
+}
+
+func newSearchSettings(cfg *config.Config) *searchSettings {
+	settings := &searchSettings{
+		ranking:  make(map[string]database.Ranking, len(cfg.Sources)),
+		synonyms: make(map[string]*query.Synonyms, len(cfg.Sources)),
+	}
+	for _, src := range cfg.Sources {
+		settings.ranking[src.ID] = rankingFromConfig(src)
+		settings.synonyms[src.ID] = synonymsFromConfig(src)
+	}
+	return settings
+}
+
+// Converts a source's ranking settings into the format used by the database, filling in defaults for unspecified values
+func rankingFromConfig(src config.Source) database.Ranking {
+	ranking := database.DefaultRanking
+	cfg := src.Ranking
+
+	ranking.FieldWeights.URL = *cmp.Or(cfg.FieldWeights.URL, &ranking.FieldWeights.URL)
+	ranking.FieldWeights.Title = *cmp.Or(cfg.FieldWeights.Title, &ranking.FieldWeights.Title)
+	ranking.FieldWeights.Description = *cmp.Or(cfg.FieldWeights.Description, &ranking.FieldWeights.Description)
+	ranking.FieldWeights.Content = *cmp.Or(cfg.FieldWeights.Content, &ranking.FieldWeights.Content)
+	ranking.RRFConstant = *cmp.Or(cfg.RRFConstant, &ranking.RRFConstant)
+	ranking.VectorWeight = *cmp.Or(cfg.VectorWeight, &ranking.VectorWeight)
+	ranking.LanguageBoost = *cmp.Or(cfg.LanguageBoost, &ranking.LanguageBoost)
+
+	ranking.AuthorityWeight = cfg.AuthorityWeight
+	ranking.DepthBoost = cfg.DepthBoost
+	ranking.URLRules = make([]database.URLRule, 0, len(cfg.URLRules))
+	for _, rule := range cfg.URLRules {
+		ranking.URLRules = append(ranking.URLRules, database.URLRule{Contains: rule.Contains, Multiplier: rule.Multiplier})
+	}
+
+	return ranking
+}
+
+// Converts a source's synonyms into the format used to expand queries
+func synonymsFromConfig(src config.Source) *query.Synonyms {
+	synonyms := query.NewSynonyms()
+	for _, group := range src.Synonyms.Equivalent {
+		synonyms.AddEquivalent(group)
+	}
+	for _, rule := range src.Synonyms.OneWay {
+		synonyms.AddOneWay(rule.From, rule.To)
+	}
+	return synonyms
+}
+
+// Parses a date (like "2024-01-31") or an RFC 3339 timestamp. Returns nil if the string is empty.
+func parseDateParam(str string) (*time.Time, error) {
+	if str == "" {
+		return nil, nil
+	}
+	for _, format := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(format, str); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a date like %q", time.DateOnly)
+}
+
+// Formats one of the RFC 3339 timestamps stored with a page for display on the results page
+func formatDate(timestamp *string) string {
+	if timestamp == nil {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339, *timestamp)
+	if err != nil {
+		return ""
+	}
+	return t.Format("Jan 2, 2006")
+}
+
+// Saves a query that returned results so that it can be suggested to other users
+func recordQuery(ctx context.Context, db database.Database, sources []string, q string) {
+	if err := db.RecordQuery(ctx, sources, q); err != nil {
+		slogctx.Error(ctx, "Failed to record query", "error", err)
+	}
+}
+
+// If a query returns fewer results than this, a spelling-corrected version of the query is also searched.
+const correctionThreshold = 3
+
+// Users can add `spellcheck=false` to the query string to disable spelling correction
+func spellcheckEnabled(req *http.Request) bool {
+	return req.URL.Query().Get("spellcheck") != "false"
+}
+
+// Returns a spelling-corrected version of the query if the original query returned few results,
+// or an empty string if the query shouldn't be corrected.
+func suggestCorrection(ctx context.Context, db database.Database, sources []string, q string, resultCount int, enabled bool) string {
+	if !enabled || resultCount >= correctionThreshold {
+		return ""
+	}
+
+	corrected, err := db.Spellfix(ctx, sources, q)
+	if err != nil {
+		slogctx.Error(ctx, "Failed to spellcheck query", "error", err)
+		return ""
+	}
+
+	if corrected == q {
+		return ""
+	}
+	return corrected
+}
+
+// Returns a spelling-corrected version of a hybrid search's query, or an empty string if the query shouldn't be corrected.
+// The vector search finds the closest pages to any query, including a misspelled one, so its results aren't counted:
+// the query is corrected if its keywords match few pages and the corrected query's keywords match more.
+func suggestHybridCorrection(ctx context.Context, db database.Database, sources []string, q string, options database.SearchOptions, enabled bool) string {
+	if !enabled {
+		return ""
+	}
+	matches, err := keywordMatches(ctx, db, sources, q, options)
+	if err != nil {
+		slogctx.Error(ctx, "Failed to count keyword matches for spelling correction", "error", err)
+		return ""
+	}
+	corrected := suggestCorrection(ctx, db, sources, q, int(matches), true)
+	if corrected == "" {
+		return ""
+	}
+	correctedMatches, err := keywordMatches(ctx, db, sources, corrected, options)
+	if err != nil {
+		slogctx.Error(ctx, "Failed to count keyword matches for spelling correction", "error", err)
+		return ""
+	}
+	if correctedMatches <= matches {
+		return ""
+	}
+	return corrected
+}
+
+// Returns the number of pages that a query's keywords match with a full-text search
+func keywordMatches(ctx context.Context, db database.Database, sources []string, q string, options database.SearchOptions) (uint32, error) {
+	_, total, err := db.Search(ctx, sources, q, options, 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	return *total, nil
+}
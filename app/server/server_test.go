@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"testing"
+
+	vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+	"github.com/fluxcapacitor2/easysearch/app/config"
+	"github.com/fluxcapacitor2/easysearch/app/database"
+	"github.com/fluxcapacitor2/easysearch/app/spellfix"
+)
+
+func TestPreferredLanguages(t *testing.T) {
+	tests := map[string][]database.LanguagePreference{
+		"":        nil,
+		"invalid": nil,
+		"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5": {
+			{Language: "fr", Weight: 1},
+			{Language: "en", Weight: 0.8},
+			{Language: "de", Weight: 0.7},
+		},
+		"en;q=0, es": {{Language: "es", Weight: 1}},
+	}
+
+	for header, want := range tests {
+		if got := preferredLanguages(header); !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected preferences for %q: wanted %+v, got %+v", header, want, got)
+		}
+	}
+}
+
+func TestExpandCollapsedURL(t *testing.T) {
+	u, err := url.Parse("http://localhost:8080/?collapse=host&page=2&q=hello&source=a")
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	resultURL, err := url.Parse("https://Example.com/blog/post")
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	res := database.FTSResult{URL: resultURL.String(), Collapsed: 3}
+
+	text, link := expandCollapsedURL(u, database.CollapseHost, res, resultURL)
+	if text != "3 more from example.com" || link != "http://localhost:8080/?host=example.com&page=1&q=hello&source=a" {
+		t.Errorf("unexpected link for collapsed hosts: %q, %q", text, link)
+	}
+
+	res.Collapsed = 1
+	text, link = expandCollapsedURL(u, database.CollapseDuplicates, res, resultURL)
+	if text != "1 similar page" || link != "http://localhost:8080/?duplicatesOf=https%3A%2F%2FExample.com%2Fblog%2Fpost&page=1&q=hello&source=a" {
+		t.Errorf("unexpected link for collapsed duplicates: %q, %q", text, link)
+	}
+}
+
+func TestSearchParamsFromRequest(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Search.MaxPageSize = 50
+
+	tests := []struct {
+		query    string
+		page     uint32
+		pageSize uint32
+		valid    bool
+	}{
+		{"", 1, 10, true},
+		{"page=3&pageSize=25", 3, 25, true},
+		{"page=0", 0, 0, false},
+		{"pageSize=51", 0, 0, false},
+		{"page=abc", 0, 0, false},
+		{"contentTokens=65", 0, 0, false},
+		{"snippets=0", 0, 0, false},
+		{"highlight=html&highlightTag=<b>", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		params, err := searchParamsFromRequest(httptest.NewRequest("GET", "/api/search?"+test.query, nil), cfg, newSearchSettings(cfg))
+		if (err == nil) != test.valid || (test.valid && (params.Page != test.page || params.PageSize != test.pageSize)) {
+			t.Errorf("unexpected result for %q: page %v, page size %v, error %v", test.query, params.Page, params.PageSize, err)
+		}
+	}
+
+	// Pages past the first 1000 results can't be reached with vector searches
+	params, err := searchParamsFromRequest(httptest.NewRequest("GET", "/api/hybrid-search?page=21&pageSize=50", nil), cfg, newSearchSettings(cfg))
+	if err != nil || params.checkVectorDepth() == nil {
+		t.Errorf("expected page 21 to be too deep for a vector search: %v", err)
+	}
+}
+
+func TestHighlightHTML(t *testing.T) {
+	params, err := searchParamsFromRequest(httptest.NewRequest("GET", "/api/search?highlight=html&highlightTag=em&highlightClass=hit", nil), &config.Config{}, newSearchSettings(&config.Config{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := params.highlightHTML([]database.Match{{Content: "Use <script> with "}, {Highlighted: true, Content: "TypeScript"}})
+	if want := `Use &lt;script&gt; with <em class="hit">TypeScript</em>`; got != want {
+		t.Errorf("unexpected HTML: %v", got)
+	}
+}
+
+// A database with fixed spelling corrections, which records the queries that were spellchecked
+type correctingDatabase struct {
+	database.Database
+	corrections map[string]string
+	checked     []string
+}
+
+func (db *correctingDatabase) Spellfix(ctx context.Context, sources []string, search string) (string, error) {
+	db.checked = append(db.checked, search)
+	if corrected, ok := db.corrections[search]; ok {
+		return corrected, nil
+	}
+	return search, nil
+}
+
+func TestSearchSpellingCorrection(t *testing.T) {
+	spellfix.Auto()
+	sqlite, err := database.SQLiteFromFile(path.Join(t.TempDir(), "temp.db"))
+	if err != nil {
+		t.Fatalf("database creation failed: %v", err)
+	}
+	if err := sqlite.Setup(context.Background()); err != nil {
+		t.Fatalf("database setup failed: %v", err)
+	}
+	// "installation" is on four pages, "guide" is on three, and "tutorial" is on two
+	for i, content := range []string{"installation guide", "installation guide", "installation guide tutorial", "installation tutorial"} {
+		_, err := sqlite.AddDocument(context.Background(), "source1", 1, []int64{}, fmt.Sprintf("https://example.com/%v", i), database.Finished, "Page", "", content, "", database.PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+	}
+
+	db := &correctingDatabase{Database: sqlite, corrections: map[string]string{"instalation": "installation", "tutorial": "installation"}}
+	cfg := &config.Config{Sources: []config.Source{{ID: "source1"}}}
+	mux := http.NewServeMux()
+	registerSearchRoutes(mux, db, cfg, newSearchSettings(cfg))
+
+	type response struct {
+		Results        []json.RawMessage `json:"results"`
+		OriginalQuery  string            `json:"originalQuery"`
+		CorrectedQuery string            `json:"correctedQuery"`
+		Pagination     paginationInfo    `json:"pagination"`
+	}
+	search := func(params string) response {
+		t.Helper()
+		db.checked = nil
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/search?source=source1&"+params, nil))
+		if rec.Code != 200 {
+			t.Fatalf("unexpected status %v for %q: %v", rec.Code, params, rec.Body.String())
+		}
+		var res response
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		return res
+	}
+
+	// A query without results is replaced by its correction, and both queries are included in the response
+	res := search("q=instalation")
+	if res.OriginalQuery != "instalation" || res.CorrectedQuery != "installation" || len(res.Results) != 4 || res.Pagination.Total != 4 {
+		t.Fatalf("expected results for the corrected query, got %+v", res)
+	}
+
+	// Users can opt out of spelling correction
+	res = search("q=instalation&spellcheck=false")
+	if res.CorrectedQuery != "" || len(res.Results) != 0 || len(db.checked) != 0 {
+		t.Fatalf("expected no correction with spellcheck=false, got %+v (checked %v)", res, db.checked)
+	}
+
+	// Queries with fewer results than the threshold are spellchecked, and queries with more aren't
+	res = search("q=tutorial")
+	if len(db.checked) != 1 || res.CorrectedQuery != "installation" || res.OriginalQuery != "tutorial" {
+		t.Fatalf("expected a query with 2 results to be corrected, got %+v (checked %v)", res, db.checked)
+	}
+	res = search("q=guide")
+	if len(db.checked) != 0 || res.CorrectedQuery != "" || len(res.Results) != correctionThreshold {
+		t.Fatalf("expected a query with %v results not to be spellchecked, got %+v (checked %v)", correctionThreshold, res, db.checked)
+	}
+
+	// The correction is only used if it has more results than the original query
+	db.corrections["tutorial"] = "instalation"
+	res = search("q=tutorial")
+	if len(db.checked) != 1 || res.CorrectedQuery != "" || len(res.Results) != 2 {
+		t.Fatalf("expected the original results when the correction has fewer, got %+v (checked %v)", res, db.checked)
+	}
+}
+
+func TestHybridSearchSpellingCorrection(t *testing.T) {
+	vec.Auto()
+	spellfix.Auto()
+	sqlite, err := database.SQLiteFromFile(path.Join(t.TempDir(), "temp.db"))
+	if err != nil {
+		t.Fatalf("database creation failed: %v", err)
+	}
+	if err := sqlite.Setup(context.Background()); err != nil {
+		t.Fatalf("database setup failed: %v", err)
+	}
+	if err := sqlite.SetupVectorTables(context.Background(), "source1", 2); err != nil {
+		t.Fatalf("vector table setup failed: %v", err)
+	}
+	// Every page has an embedding, so the vector search returns all of them for any query
+	for i, content := range []string{"installation guide", "installation guide", "installation tutorial", "release notes"} {
+		id, err := sqlite.AddDocument(context.Background(), "source1", 1, []int64{}, fmt.Sprintf("https://example.com/%v", i), database.Finished, "Page", "", content, "", database.PageMetadata{})
+		if err != nil {
+			t.Fatalf("error adding document: %v", err)
+		}
+		if err := sqlite.AddEmbedding(context.Background(), id, "source1", 0, content, []float32{1, float32(i)}); err != nil {
+			t.Fatalf("error adding embedding: %v", err)
+		}
+	}
+
+	embeddings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[1,0]}]}`)
+	}))
+	t.Cleanup(embeddings.Close)
+
+	db := &correctingDatabase{Database: sqlite, corrections: map[string]string{"instalation": "installation", "release": "installation"}}
+	cfg := &config.Config{Sources: []config.Source{{ID: "source1"}}}
+	cfg.Sources[0].Embeddings.Enabled = true
+	cfg.Sources[0].Embeddings.OpenAIBaseURL = embeddings.URL
+	cfg.Sources[0].Embeddings.Model = "test-model"
+	cfg.Sources[0].Embeddings.Dimensions = 2
+	mux := http.NewServeMux()
+	registerSearchRoutes(mux, db, cfg, newSearchSettings(cfg))
+
+	type response struct {
+		Results        []json.RawMessage `json:"results"`
+		OriginalQuery  string            `json:"originalQuery"`
+		CorrectedQuery string            `json:"correctedQuery"`
+		Pagination     paginationInfo    `json:"pagination"`
+	}
+	search := func(params string) response {
+		t.Helper()
+		db.checked = nil
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/hybrid-search?source=source1&"+params, nil))
+		if rec.Code != 200 {
+			t.Fatalf("unexpected status %v for %q: %v", rec.Code, params, rec.Body.String())
+		}
+		var res response
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		return res
+	}
+
+	// The vector results put the total over the threshold, but the misspelled keywords don't match any pages
+	res := search("q=instalation")
+	if res.Pagination.Total < correctionThreshold || len(db.checked) != 1 || res.CorrectedQuery != "installation" || res.OriginalQuery != "instalation" {
+		t.Fatalf("expected a query whose keywords match nothing to be corrected, got %+v (checked %v)", res, db.checked)
+	}
+
+	// Queries whose keywords match enough pages aren't spellchecked
+	res = search("q=installation")
+	if len(db.checked) != 0 || res.CorrectedQuery != "" {
+		t.Fatalf("expected a query whose keywords match %v pages not to be spellchecked, got %+v (checked %v)", correctionThreshold, res, db.checked)
+	}
+
+	// The correction is only used if its keywords match more pages than the original query's
+	db.corrections["release"] = "instalation"
+	res = search("q=release")
+	if len(db.checked) != 1 || res.CorrectedQuery != "" {
+		t.Fatalf("expected the original results when the correction matches fewer pages, got %+v (checked %v)", res, db.checked)
+	}
+}
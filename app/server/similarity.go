@@ -0,0 +1,46 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/fluxcapacitor2/easysearch/app/database"
+)
+
+// Combines the similarity search results of sources that use different embedding models, keyed by model, into one ranking.
+//
+// Results from the same model are compared by their similarity. Each model has its own scale (one model's 0.6 can be as close as another's 0.8),
+// so the models' rankings are combined with reciprocal rank fusion: each result gets `1 / (k + rank)` points, where `k` is the model's constant from `rrfConstants`
+// and `rank` is the result's position in its model's ranking.
+func mergeSimilarityResults(byModel map[string][]database.SimilarityResult, rrfConstants map[string]float64) []database.SimilarityResult {
+	type scored struct {
+		database.SimilarityResult
+		score float64
+	}
+
+	// Results with the same score are ordered by URL, so that the order is the same on every page
+	compare := func(a, b database.SimilarityResult) int {
+		return cmp.Or(cmp.Compare(b.Similarity, a.Similarity), strings.Compare(a.URL, b.URL), strings.Compare(a.Chunk, b.Chunk), strings.Compare(a.Source, b.Source))
+	}
+
+	merged := make([]scored, 0)
+	for model, results := range byModel {
+		slices.SortFunc(results, compare)
+		for i, res := range results {
+			merged = append(merged, scored{SimilarityResult: res, score: 1 / (rrfConstants[model] + float64(i+1))})
+		}
+	}
+	slices.SortFunc(merged, func(a, b scored) int {
+		if len(byModel) == 1 {
+			return compare(a.SimilarityResult, b.SimilarityResult)
+		}
+		return cmp.Or(cmp.Compare(b.score, a.score), compare(a.SimilarityResult, b.SimilarityResult))
+	})
+
+	results := make([]database.SimilarityResult, len(merged))
+	for i, res := range merged {
+		results[i] = res.SimilarityResult
+	}
+	return results
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fluxcapacitor2/easysearch/app/database"
+)
+
+func TestMergeSimilarityResults(t *testing.T) {
+	urls := func(results []database.SimilarityResult) []string {
+		urls := make([]string, len(results))
+		for i, res := range results {
+			urls[i] = res.URL
+		}
+		return urls
+	}
+
+	// Sources that use the same model are merged by similarity
+	sameModel := map[string][]database.SimilarityResult{
+		"model-a": {
+			{URL: "https://a.example.com/1", Similarity: 0.9, Source: "a"},
+			{URL: "https://a.example.com/2", Similarity: 0.5, Source: "a"},
+			{URL: "https://b.example.com/1", Similarity: 0.7, Source: "b"},
+			{URL: "https://b.example.com/2", Similarity: 0.5, Source: "b"},
+		},
+	}
+	want := []string{"https://a.example.com/1", "https://b.example.com/1", "https://a.example.com/2", "https://b.example.com/2"}
+	if got := urls(mergeSimilarityResults(sameModel, map[string]float64{"model-a": 60})); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected order for one model: %v", got)
+	}
+
+	// A model whose similarities are all lower still gets its best results near the top
+	differentModels := map[string][]database.SimilarityResult{
+		"model-a": {
+			{URL: "https://a.example.com/1", Similarity: 0.9},
+			{URL: "https://a.example.com/2", Similarity: 0.8},
+		},
+		"model-b": {
+			{URL: "https://b.example.com/1", Similarity: 0.4},
+			{URL: "https://b.example.com/2", Similarity: 0.3},
+		},
+	}
+	want = []string{"https://a.example.com/1", "https://b.example.com/1", "https://a.example.com/2", "https://b.example.com/2"}
+	if got := urls(mergeSimilarityResults(differentModels, map[string]float64{"model-a": 60, "model-b": 60})); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected order for two models: %v", got)
+	}
+}
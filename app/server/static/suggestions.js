@@ -0,0 +1,83 @@
+// Adds keyboard navigation to the search suggestions dropdown on the index page.
+// The suggestions themselves are fetched and inserted by HTMX (see index.html.tmpl).
+
+document.addEventListener("DOMContentLoaded", () => {
+  const input = document.querySelector(".search-box input[type=search]");
+  const list = document.getElementById("suggestions");
+  if (!input || !list) return;
+  const form = input.form;
+
+  let active = -1;
+
+  const options = () => Array.from(list.querySelectorAll("[role=option]"));
+
+  const close = () => {
+    list.replaceChildren();
+    input.setAttribute("aria-expanded", "false");
+    input.removeAttribute("aria-activedescendant");
+    active = -1;
+  };
+
+  const highlight = (index) => {
+    const items = options();
+    active = index;
+    items.forEach((item, i) => item.setAttribute("aria-selected", i === index ? "true" : "false"));
+    if (index >= 0 && index < items.length) {
+      input.setAttribute("aria-activedescendant", items[index].id);
+      items[index].scrollIntoView({ block: "nearest" });
+    } else {
+      input.removeAttribute("aria-activedescendant");
+    }
+  };
+
+  const choose = (item) => {
+    if (item.dataset.url) {
+      window.location.href = item.dataset.url;
+      return;
+    }
+    input.value = item.dataset.query;
+    close();
+    form.requestSubmit();
+  };
+
+  list.addEventListener("htmx:afterSwap", () => {
+    active = -1;
+    input.removeAttribute("aria-activedescendant");
+    input.setAttribute("aria-expanded", options().length > 0 ? "true" : "false");
+  });
+
+  input.addEventListener("keydown", (e) => {
+    const items = options();
+    if (e.key === "ArrowDown" && items.length > 0) {
+      e.preventDefault();
+      highlight((active + 1) % items.length);
+    } else if (e.key === "ArrowUp" && items.length > 0) {
+      e.preventDefault();
+      highlight(active <= 0 ? items.length - 1 : active - 1);
+    } else if (e.key === "Enter" && active >= 0 && active < items.length) {
+      e.preventDefault();
+      choose(items[active]);
+    } else if (e.key === "Escape" && items.length > 0) {
+      e.preventDefault();
+      close();
+    }
+  });
+
+  // Prevent the input from losing focus when a suggestion is clicked, so that the blur handler doesn't close the list first
+  list.addEventListener("mousedown", (e) => e.preventDefault());
+  list.addEventListener("click", (e) => {
+    const item = e.target.closest("[role=option]");
+    if (item) choose(item);
+  });
+
+  input.addEventListener("blur", close);
+  form.addEventListener("submit", close);
+
+  // Tell the server which searches were submitted, as opposed to searches made while the user was typing,
+  // so that only finished queries are suggested to other users.
+  form.addEventListener("htmx:configRequest", (e) => {
+    if (e.detail.elt === form && e.detail.triggeringEvent?.type === "submit") {
+      e.detail.headers["Easysearch-Submitted"] = "true";
+    }
+  });
+});